@@ -1,8 +1,13 @@
 package cmd
 
 import (
+	_ "github.com/sikalabs/webhook-dispatcher/cmd/diff"
+	_ "github.com/sikalabs/webhook-dispatcher/cmd/export"
+	_ "github.com/sikalabs/webhook-dispatcher/cmd/manifest"
+	_ "github.com/sikalabs/webhook-dispatcher/cmd/report"
 	"github.com/sikalabs/webhook-dispatcher/cmd/root"
 	_ "github.com/sikalabs/webhook-dispatcher/cmd/server"
+	_ "github.com/sikalabs/webhook-dispatcher/cmd/trash"
 	_ "github.com/sikalabs/webhook-dispatcher/cmd/version"
 	"github.com/spf13/cobra"
 )
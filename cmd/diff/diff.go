@@ -0,0 +1,59 @@
+package diff
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/sikalabs/webhook-dispatcher/cmd/root"
+	"github.com/sikalabs/webhook-dispatcher/pkg/jsondiff"
+	"github.com/sikalabs/webhook-dispatcher/pkg/server"
+	"github.com/spf13/cobra"
+)
+
+var Cmd = &cobra.Command{
+	Use:   "diff <event-key-a> <event-key-b>",
+	Short: "Compare bodies of two stored events",
+	Args:  cobra.ExactArgs(2),
+	Run: func(c *cobra.Command, args []string) {
+		store := server.OpenStorage()
+		defer store.Close()
+
+		changes, err := server.DiffEvents(context.Background(), store, args[0], args[1])
+		if err != nil {
+			log.Fatalf("Failed to diff events: %v", err)
+		}
+
+		if len(changes) == 0 {
+			fmt.Println("Events are equal")
+			return
+		}
+		for _, change := range changes {
+			printChange(change)
+		}
+	},
+}
+
+func printChange(change jsondiff.Change) {
+	switch change.Op {
+	case jsondiff.OpAdded:
+		fmt.Printf("+ %s: %s\n", change.Path, toJSON(change.New))
+	case jsondiff.OpRemoved:
+		fmt.Printf("- %s: %s\n", change.Path, toJSON(change.Old))
+	default:
+		fmt.Printf("~ %s: %s -> %s\n", change.Path, toJSON(change.Old), toJSON(change.New))
+	}
+}
+
+func toJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(data)
+}
+
+func init() {
+	root.Cmd.AddCommand(Cmd)
+}
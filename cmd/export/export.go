@@ -0,0 +1,78 @@
+package export
+
+import (
+	"context"
+	"log"
+
+	"github.com/sikalabs/webhook-dispatcher/cmd/root"
+	"github.com/sikalabs/webhook-dispatcher/pkg/export"
+	"github.com/sikalabs/webhook-dispatcher/pkg/server"
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	FlagFile            string
+	FlagCheckpoint      string
+	FlagCheckpointEvery int64
+)
+
+var ExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export stored events to a JSON Lines file, resuming from a checkpoint",
+	Args:  cobra.NoArgs,
+	Run: func(c *cobra.Command, args []string) {
+		store := server.OpenStorage()
+		defer store.Close()
+
+		exporter, ok := store.(storage.Exporter)
+		if !ok {
+			log.Fatalf("The storage backend does not support export")
+		}
+		n, err := export.Export(context.Background(), exporter, options())
+		if err != nil {
+			log.Fatalf("Failed to export events after %d events: %v", n, err)
+		}
+		log.Printf("Exported %d events to %s", n, FlagFile)
+	},
+}
+
+var ImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import events from a JSON Lines file, resuming from a checkpoint",
+	Args:  cobra.NoArgs,
+	Run: func(c *cobra.Command, args []string) {
+		store := server.OpenStorage()
+		defer store.Close()
+
+		n, err := export.Import(context.Background(), store, options())
+		if err != nil {
+			log.Fatalf("Failed to import events after %d events: %v", n, err)
+		}
+		log.Printf("Imported %d events from %s", n, FlagFile)
+	},
+}
+
+func options() export.Options {
+	if FlagCheckpointEvery < 1 {
+		log.Fatalf("--checkpoint-every must be positive")
+	}
+	checkpoint := FlagCheckpoint
+	if checkpoint == "" {
+		checkpoint = FlagFile + ".checkpoint"
+	}
+	return export.Options{
+		File:            FlagFile,
+		CheckpointFile:  checkpoint,
+		CheckpointEvery: FlagCheckpointEvery,
+	}
+}
+
+func init() {
+	for _, c := range []*cobra.Command{ExportCmd, ImportCmd} {
+		root.Cmd.AddCommand(c)
+		c.Flags().StringVar(&FlagFile, "file", "events.jsonl", "JSON Lines file of the events")
+		c.Flags().StringVar(&FlagCheckpoint, "checkpoint", "", "Checkpoint file, defaults to the file with .checkpoint appended")
+		c.Flags().Int64Var(&FlagCheckpointEvery, "checkpoint-every", 10000, "Number of events between checkpoints")
+	}
+}
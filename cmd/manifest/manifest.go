@@ -0,0 +1,39 @@
+package manifest
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/sikalabs/webhook-dispatcher/cmd/root"
+	"github.com/sikalabs/webhook-dispatcher/pkg/manifest"
+	"github.com/spf13/cobra"
+)
+
+var Cmd = &cobra.Command{
+	Use:   "verify-manifests <manifest-log> <public-key.pem>",
+	Short: "Verify signatures and the chain of delivery manifests",
+	Args:  cobra.ExactArgs(2),
+	Run: func(c *cobra.Command, args []string) {
+		key, err := manifest.LoadPublicKey(args[1])
+		if err != nil {
+			log.Fatalf("Failed to load public key: %v", err)
+		}
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			log.Fatalf("Failed to open manifest log: %v", err)
+		}
+		defer f.Close()
+
+		count, err := manifest.Verify(f, key)
+		if err != nil {
+			log.Fatalf("Verification failed after %d valid manifests: %v", count, err)
+		}
+		fmt.Printf("%d manifests verified\n", count)
+	},
+}
+
+func init() {
+	root.Cmd.AddCommand(Cmd)
+}
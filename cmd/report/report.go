@@ -0,0 +1,131 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/cmd/root"
+	"github.com/sikalabs/webhook-dispatcher/pkg/deliverylog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	FlagLog     string
+	FlagSince   string
+	FlagGroupBy string
+	FlagFormat  string
+)
+
+var Cmd = &cobra.Command{
+	Use:   "report",
+	Short: "Summarize deliveries recorded in the delivery log",
+	Args:  cobra.NoArgs,
+	Run: func(c *cobra.Command, args []string) {
+		since, err := parseSince(FlagSince)
+		if err != nil {
+			log.Fatalf("Invalid --since: %v", err)
+		}
+		var groupBy []string
+		if FlagGroupBy != "" {
+			groupBy = strings.Split(FlagGroupBy, ",")
+		}
+		report, err := deliverylog.NewReport(groupBy, since)
+		if err != nil {
+			log.Fatalf("Invalid --group-by: %v", err)
+		}
+
+		f, err := os.Open(FlagLog)
+		if err != nil {
+			log.Fatalf("Failed to open delivery log: %v", err)
+		}
+		defer f.Close()
+		if err := deliverylog.Read(f, report.Add); err != nil {
+			log.Fatalf("Failed to read delivery log: %v", err)
+		}
+
+		switch FlagFormat {
+		case "csv":
+			err = writeCSV(report)
+		case "json":
+			err = json.NewEncoder(os.Stdout).Encode(report.Rows())
+		case "table":
+			err = writeTable(report)
+		default:
+			log.Fatalf("Unknown --format %q, expected csv, json or table", FlagFormat)
+		}
+		if err != nil {
+			log.Fatalf("Failed to write report: %v", err)
+		}
+	},
+}
+
+// parseSince parses a duration like 7d or 12h into the start time of the
+// report, an empty value reports all deliveries
+func parseSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("%q is not a number of days", value)
+		}
+		return time.Now().AddDate(0, 0, -n), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(-d), nil
+}
+
+func header(report *deliverylog.Report) []string {
+	return append(append([]string{}, report.GroupBy...),
+		"total", "succeeded", "failed", "success_rate", "avg_ms", "p50_ms", "p95_ms", "max_ms")
+}
+
+func values(row deliverylog.Row) []string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	return append(append([]string{}, row.Group...),
+		strconv.Itoa(row.Total), strconv.Itoa(row.Succeeded), strconv.Itoa(row.Failed),
+		f(row.SuccessRate), f(row.AvgMs), f(row.P50Ms), f(row.P95Ms), f(row.MaxMs))
+}
+
+func writeCSV(report *deliverylog.Report) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write(header(report))
+	for _, row := range report.Rows() {
+		w.Write(values(row))
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func writeTable(report *deliverylog.Report) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header(report), "\t"))
+	for _, row := range report.Rows() {
+		fmt.Fprintln(w, strings.Join(values(row), "\t"))
+	}
+	return w.Flush()
+}
+
+func init() {
+	root.Cmd.AddCommand(Cmd)
+	logPath := os.Getenv("DELIVERY_LOG")
+	if logPath == "" {
+		logPath = "deliveries.jsonl"
+	}
+	Cmd.Flags().StringVar(&FlagLog, "log", logPath, "Delivery log written by the server (env DELIVERY_LOG)")
+	Cmd.Flags().StringVar(&FlagSince, "since", "", "Only report deliveries within the period, e.g. 7d or 12h")
+	Cmd.Flags().StringVar(&FlagGroupBy, "group-by", "path,target",
+		"Comma separated fields to group by: "+strings.Join(deliverylog.GroupFields, ", "))
+	Cmd.Flags().StringVar(&FlagFormat, "format", "table", "Output format: csv, json or table")
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"os"
+
 	"github.com/sikalabs/webhook-dispatcher/cmd/root"
 	"github.com/sikalabs/webhook-dispatcher/pkg/server"
 	"github.com/spf13/cobra"
@@ -18,4 +20,8 @@ var Cmd = &cobra.Command{
 
 func init() {
 	root.Cmd.AddCommand(Cmd)
+	Cmd.Flags().StringVar(&server.QueueName, "queue-name", os.Getenv("QUEUE_NAME"),
+		"Redis list mirroring the delivery backlog for autoscalers (env QUEUE_NAME)")
+	Cmd.Flags().BoolVar(&server.VerifyTargets, "verify-targets", false,
+		"Probe all targets on startup and exit if any is unreachable (env VERIFY_TARGETS=1)")
 }
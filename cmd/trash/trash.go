@@ -0,0 +1,118 @@
+package trash
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/cmd/root"
+	"github.com/sikalabs/webhook-dispatcher/pkg/server"
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	FlagKey   string
+	FlagPath  string
+	FlagLimit int64
+	FlagAll   bool
+)
+
+var Cmd = &cobra.Command{
+	Use:   "trash",
+	Short: "Delete, restore and purge stored events",
+}
+
+var ListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List events in the trash",
+	Args:  cobra.NoArgs,
+	Run: func(c *cobra.Command, args []string) {
+		withTrasher(func(ctx context.Context, trasher storage.Trasher) {
+			events, err := trasher.ListTrash(ctx, FlagLimit)
+			if err != nil {
+				log.Fatalf("Failed to list the trash: %v", err)
+			}
+			for _, event := range events {
+				deleted := ""
+				if event.DeletedAt != nil {
+					deleted = event.DeletedAt.Format(time.RFC3339)
+				}
+				fmt.Printf("%s\t%s\t%s\n", event.Key, event.Path, deleted)
+			}
+		})
+	},
+}
+
+var DeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Move events selected by --key or --path to the trash",
+	Args:  cobra.NoArgs,
+	Run: func(c *cobra.Command, args []string) {
+		withTrasher(func(ctx context.Context, trasher storage.Trasher) {
+			n, err := trasher.Trash(ctx, storage.TrashQuery{Key: FlagKey, Path: FlagPath})
+			if err != nil {
+				log.Fatalf("Failed to delete events: %v", err)
+			}
+			fmt.Printf("Moved %d events to the trash\n", n)
+		})
+	},
+}
+
+var RestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore events selected by --key or --path from the trash",
+	Args:  cobra.NoArgs,
+	Run: func(c *cobra.Command, args []string) {
+		withTrasher(func(ctx context.Context, trasher storage.Trasher) {
+			n, err := trasher.Restore(ctx, storage.TrashQuery{Key: FlagKey, Path: FlagPath})
+			if err != nil {
+				log.Fatalf("Failed to restore events: %v", err)
+			}
+			fmt.Printf("Restored %d events\n", n)
+		})
+	},
+}
+
+var PurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Permanently delete events past the trash TTL, or all with --all",
+	Args:  cobra.NoArgs,
+	Run: func(c *cobra.Command, args []string) {
+		before := time.Now()
+		if !FlagAll {
+			before = before.Add(-server.TrashTTL())
+		}
+		withTrasher(func(ctx context.Context, trasher storage.Trasher) {
+			n, err := trasher.PurgeTrash(ctx, before)
+			if err != nil {
+				log.Fatalf("Failed to purge the trash: %v", err)
+			}
+			fmt.Printf("Purged %d events\n", n)
+		})
+	},
+}
+
+// withTrasher opens the storage and calls fn if it supports the trash
+func withTrasher(fn func(context.Context, storage.Trasher)) {
+	store := server.OpenStorage()
+	defer store.Close()
+
+	trasher, ok := store.(storage.Trasher)
+	if !ok {
+		log.Fatalf("The storage backend does not support the trash")
+	}
+	fn(context.Background(), trasher)
+}
+
+func init() {
+	root.Cmd.AddCommand(Cmd)
+	Cmd.AddCommand(ListCmd, DeleteCmd, RestoreCmd, PurgeCmd)
+	ListCmd.Flags().Int64Var(&FlagLimit, "limit", 50, "Maximum number of events to list")
+	for _, c := range []*cobra.Command{DeleteCmd, RestoreCmd} {
+		c.Flags().StringVar(&FlagKey, "key", "", "Key of the event")
+		c.Flags().StringVar(&FlagPath, "path", "", "Path of the events")
+	}
+	PurgeCmd.Flags().BoolVar(&FlagAll, "all", false, "Purge the whole trash")
+}
@@ -0,0 +1,157 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+// Formats payloads can be converted between
+const (
+	JSON    = "json"
+	Form    = "form"
+	XML     = "xml"
+	MsgPack = "msgpack"
+	// Protobuf payloads are encoded by a ProtoMessage
+	Protobuf = "protobuf"
+)
+
+// Formats lists the formats payloads can be converted to
+var Formats = []string{JSON, Form, XML, MsgPack, Protobuf}
+
+var contentTypes = map[string]string{
+	JSON:     "application/json",
+	Form:     "application/x-www-form-urlencoded",
+	XML:      "application/xml",
+	MsgPack:  "application/msgpack",
+	Protobuf: "application/x-protobuf",
+}
+
+// ContentType returns the content type of payloads in the format, or false
+// if the format is unknown
+func ContentType(format string) (string, bool) {
+	contentType, ok := contentTypes[format]
+	return contentType, ok
+}
+
+// FormatOf returns the format of payloads with the content type, or false
+// if it is not one of Formats. Payloads without a content type are JSON.
+func FormatOf(contentType string) (string, bool) {
+	if contentType == "" {
+		return JSON, true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return JSON, true
+	case mediaType == "application/x-www-form-urlencoded":
+		return Form, true
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return XML, true
+	case mediaType == "application/msgpack" || mediaType == "application/x-msgpack" || mediaType == "application/vnd.msgpack":
+		return MsgPack, true
+	case mediaType == "application/x-protobuf" || mediaType == "application/protobuf" || mediaType == "application/vnd.google.protobuf":
+		return Protobuf, true
+	}
+	return "", false
+}
+
+// Convert converts a payload with the content type to the format. Payloads
+// already in the format are returned unchanged.
+func Convert(data []byte, contentType string, format string) ([]byte, error) {
+	if _, ok := contentTypes[format]; !ok {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	from, ok := FormatOf(contentType)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert %s payloads", contentType)
+	}
+	if from == format {
+		return data, nil
+	}
+	v, err := Decode(data, from)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(v, format)
+}
+
+// Decode decodes a payload in the format into JSON values, numbers are
+// json.Number. Form values are strings, or lists of strings for repeated
+// keys. XML elements are objects of their child elements, attributes
+// prefixed with @ and text in #text, or strings for text-only elements.
+func Decode(data []byte, format string) (interface{}, error) {
+	switch format {
+	case JSON:
+		return decodeJSON(data)
+	case Form:
+		return decodeForm(data)
+	case XML:
+		return decodeXML(data)
+	}
+	return nil, fmt.Errorf("cannot decode %s payloads", format)
+}
+
+// Encode encodes JSON values as decoded by Decode in the format
+func Encode(v interface{}, format string) ([]byte, error) {
+	switch format {
+	case JSON:
+		return encodeJSON(v)
+	case Form:
+		return encodeForm(v)
+	case XML:
+		return encodeXML(v)
+	case MsgPack:
+		return encodeMsgPack(v)
+	case Protobuf:
+		return nil, fmt.Errorf("protobuf encoding requires a message, see ProtoMessage")
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid JSON: trailing data")
+	}
+	return v, nil
+}
+
+func encodeJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// scalar returns the text of a string, number or boolean
+func scalar(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		if v {
+			return "true", true
+		}
+		return "false", true
+	case nil:
+		return "", true
+	}
+	return "", false
+}
@@ -0,0 +1,57 @@
+package convert
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+func decodeForm(data []byte) (interface{}, error) {
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid form: %w", err)
+	}
+	obj := make(map[string]interface{}, len(values))
+	for key, list := range values {
+		if len(list) == 1 {
+			obj[key] = list[0]
+			continue
+		}
+		items := make([]interface{}, len(list))
+		for i, item := range list {
+			items[i] = item
+		}
+		obj[key] = items
+	}
+	return obj, nil
+}
+
+// encodeForm encodes an object as a form. Nested objects and lists are
+// flattened with brackets, e.g. user[name]=x and tags[0]=a.
+func encodeForm(v interface{}) ([]byte, error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("only objects can be encoded as forms")
+	}
+	values := url.Values{}
+	for key, value := range obj {
+		flattenForm(values, key, value)
+	}
+	return []byte(values.Encode()), nil
+}
+
+func flattenForm(values url.Values, key string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			flattenForm(values, key+"["+k+"]", item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flattenForm(values, key+"["+strconv.Itoa(i)+"]", item)
+		}
+	default:
+		s, _ := scalar(v)
+		values.Add(key, s)
+	}
+}
@@ -0,0 +1,97 @@
+package convert
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ProtoMessage encodes payloads as a Protobuf message
+type ProtoMessage struct {
+	desc protoreflect.MessageDescriptor
+}
+
+// NewProtoMessage returns the message with the full name, e.g.
+// acme.orders.v1.OrderCreated, from a binary FileDescriptorSet as written
+// by protoc --descriptor_set_out --include_imports or buf build
+func NewProtoMessage(descriptorSet []byte, name string) (*ProtoMessage, error) {
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(descriptorSet, &set); err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %w", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %w", err)
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("message %s: %w", name, err)
+	}
+	message, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", name)
+	}
+	return &ProtoMessage{desc: message}, nil
+}
+
+// Name returns the full name of the message
+func (m *ProtoMessage) Name() string {
+	return string(m.desc.FullName())
+}
+
+// Encode encodes JSON values as decoded by Decode as the message, mapped
+// as in the Protobuf JSON mapping. Fields not in the message are dropped,
+// single values of repeated fields, as decoded from forms and XML, are
+// taken as lists.
+func (m *ProtoMessage) Encode(v interface{}) ([]byte, error) {
+	data, err := encodeJSON(fitMessage(m.desc, v))
+	if err != nil {
+		return nil, err
+	}
+	message := dynamicpb.NewMessage(m.desc)
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, message); err != nil {
+		return nil, fmt.Errorf("payload does not match %s: %w", m.Name(), err)
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(message)
+}
+
+// fitMessage wraps single values of repeated fields of the message in lists
+func fitMessage(desc protoreflect.MessageDescriptor, v interface{}) interface{} {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	fields := desc.Fields()
+	for key, value := range obj {
+		field := fields.ByJSONName(key)
+		if field == nil {
+			field = fields.ByTextName(key)
+		}
+		if field == nil || field.IsMap() {
+			continue
+		}
+		list, isList := value.([]interface{})
+		if field.IsList() && !isList && value != nil {
+			list, isList = []interface{}{value}, true
+		}
+		if field.Message() != nil {
+			if isList {
+				for i := range list {
+					list[i] = fitMessage(field.Message(), list[i])
+				}
+			} else {
+				value = fitMessage(field.Message(), value)
+			}
+		}
+		if isList {
+			value = list
+		}
+		obj[key] = value
+	}
+	return obj
+}
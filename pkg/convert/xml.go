@@ -0,0 +1,179 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+const (
+	// xmlRoot is the root element of encoded payloads
+	xmlRoot = "webhook"
+	// xmlItem is the element of list items without a name
+	xmlItem = "item"
+	// xmlText holds the text of elements with attributes or children
+	xmlText = "#text"
+)
+
+func decodeXML(data []byte) (interface{}, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("invalid XML: no root element")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid XML: %w", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			v, err := decodeXMLElement(decoder, start)
+			if err != nil {
+				return nil, fmt.Errorf("invalid XML: %w", err)
+			}
+			return v, nil
+		}
+	}
+}
+
+// decodeXMLElement decodes the content of the element, repeated child
+// elements become lists
+func decodeXMLElement(decoder *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	obj := map[string]interface{}{}
+	for _, attr := range start.Attr {
+		obj["@"+attr.Name.Local] = attr.Value
+	}
+	var text strings.Builder
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			child, err := decodeXMLElement(decoder, tok)
+			if err != nil {
+				return nil, err
+			}
+			name := tok.Name.Local
+			switch existing := obj[name].(type) {
+			case nil:
+				obj[name] = child
+			case []interface{}:
+				obj[name] = append(existing, child)
+			default:
+				obj[name] = []interface{}{existing, child}
+			}
+		case xml.CharData:
+			text.Write(tok)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(obj) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				obj[xmlText] = s
+			}
+			return obj, nil
+		}
+	}
+}
+
+// encodeXML encodes the value as a webhook element. Object keys become
+// child elements, or attributes when prefixed with @, and list items
+// repeated elements.
+func encodeXML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if list, ok := v.([]interface{}); ok {
+		v = map[string]interface{}{xmlItem: list}
+	}
+	if err := writeXMLElement(&buf, xmlRoot, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeXMLElement(buf *bytes.Buffer, name string, v interface{}) error {
+	if list, ok := v.([]interface{}); ok {
+		for _, item := range list {
+			if err := writeXMLElement(buf, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	buf.WriteString("<" + name)
+	obj, isObj := v.(map[string]interface{})
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	var children []string
+	for _, key := range keys {
+		attr, isAttr := strings.CutPrefix(key, "@")
+		value, isScalar := scalar(obj[key])
+		if !isAttr || !isScalar {
+			children = append(children, key)
+			continue
+		}
+		buf.WriteString(" " + xmlName(attr) + `="`)
+		xml.EscapeText(buf, []byte(value))
+		buf.WriteString(`"`)
+	}
+	if v == nil || (isObj && len(children) == 0) {
+		buf.WriteString("/>")
+		return nil
+	}
+	buf.WriteString(">")
+
+	if !isObj {
+		text, ok := scalar(v)
+		if !ok {
+			return fmt.Errorf("cannot encode %T as XML", v)
+		}
+		xml.EscapeText(buf, []byte(text))
+	}
+	for _, key := range children {
+		if key == xmlText {
+			if text, ok := scalar(obj[key]); ok {
+				xml.EscapeText(buf, []byte(text))
+				continue
+			}
+		}
+		if err := writeXMLElement(buf, xmlName(key), obj[key]); err != nil {
+			return err
+		}
+	}
+	buf.WriteString("</" + name + ">")
+	return nil
+}
+
+// xmlName returns the key as a valid element or attribute name, replacing
+// invalid characters with _
+func xmlName(key string) string {
+	var b strings.Builder
+	for i, r := range key {
+		valid := unicode.IsLetter(r) || r == '_' ||
+			(i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'))
+		if !valid {
+			if i == 0 && (unicode.IsDigit(r) || r == '-' || r == '.') {
+				b.WriteRune('_')
+				b.WriteRune(r)
+				continue
+			}
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
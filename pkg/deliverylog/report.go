@@ -0,0 +1,113 @@
+package deliverylog
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GroupFields are the fields a report can be grouped by
+var GroupFields = []string{"path", "target", "status", "day"}
+
+// Row is the summary of the deliveries of a group
+type Row struct {
+	// Group holds the values of the group-by fields in order
+	Group     []string `json:"group"`
+	Total     int      `json:"total"`
+	Succeeded int      `json:"succeeded"`
+	Failed    int      `json:"failed"`
+	// SuccessRate is the percentage of successful deliveries
+	SuccessRate float64 `json:"success_rate"`
+	AvgMs       float64 `json:"avg_ms"`
+	P50Ms       float64 `json:"p50_ms"`
+	P95Ms       float64 `json:"p95_ms"`
+	MaxMs       float64 `json:"max_ms"`
+
+	durations []float64
+}
+
+// Report summarizes delivery records by the group-by fields
+type Report struct {
+	GroupBy []string
+	Since   time.Time
+	rows    map[string]*Row
+}
+
+// NewReport returns a report of the records since the time. Records are
+// grouped by the fields, which must be GroupFields.
+func NewReport(groupBy []string, since time.Time) (*Report, error) {
+	for _, field := range groupBy {
+		if !slices.Contains(GroupFields, field) {
+			return nil, fmt.Errorf("unknown group-by field %q, expected one of %s", field, strings.Join(GroupFields, ", "))
+		}
+	}
+	return &Report{GroupBy: groupBy, Since: since, rows: map[string]*Row{}}, nil
+}
+
+// Add adds a record to the report, records before Since are skipped
+func (r *Report) Add(record Record) error {
+	if record.Time.Before(r.Since) {
+		return nil
+	}
+	group := make([]string, len(r.GroupBy))
+	for i, field := range r.GroupBy {
+		switch field {
+		case "path":
+			group[i] = record.Path
+		case "target":
+			group[i] = record.Target
+		case "status":
+			group[i] = strconv.Itoa(record.Status)
+			if record.Error != "" {
+				group[i] = "error"
+			}
+		case "day":
+			group[i] = record.Time.UTC().Format(time.DateOnly)
+		}
+	}
+	id := strings.Join(group, "\x00")
+	row, ok := r.rows[id]
+	if !ok {
+		row = &Row{Group: group}
+		r.rows[id] = row
+	}
+	row.Total++
+	if record.OK() {
+		row.Succeeded++
+	} else {
+		row.Failed++
+	}
+	row.durations = append(row.durations, record.Duration)
+	return nil
+}
+
+// Rows returns the summaries sorted by group
+func (r *Report) Rows() []Row {
+	rows := make([]Row, 0, len(r.rows))
+	for _, row := range r.rows {
+		sort.Float64s(row.durations)
+		var sum float64
+		for _, d := range row.durations {
+			sum += d
+		}
+		row.SuccessRate = 100 * float64(row.Succeeded) / float64(row.Total)
+		row.AvgMs = sum / float64(row.Total)
+		row.P50Ms = percentile(row.durations, 0.50)
+		row.P95Ms = percentile(row.durations, 0.95)
+		row.MaxMs = row.durations[len(row.durations)-1]
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return slices.Compare(rows[i].Group, rows[j].Group) < 0
+	})
+	return rows
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	i := int(float64(len(sorted))*p+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
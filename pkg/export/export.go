@@ -0,0 +1,212 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+)
+
+// Events are exported as JSON Lines, one event per line. Progress is saved
+// to a checkpoint file every CheckpointEvery events so an interrupted run
+// resumes where the last checkpoint was taken and only holds a single
+// event in memory at a time.
+
+// Checkpoint is the progress of an export or import
+type Checkpoint struct {
+	// Cursor resumes the export from the storage, unused by imports
+	Cursor string `json:"cursor,omitempty"`
+	// Offset is the size of the file written or read so far
+	Offset int64 `json:"offset"`
+	// Count is the number of events processed so far
+	Count int64 `json:"count"`
+}
+
+// Options configure an export or import
+type Options struct {
+	// File is the JSON Lines file written or read
+	File string
+	// CheckpointFile keeps the progress, it is removed when done
+	CheckpointFile string
+	// CheckpointEvery is the number of events between checkpoints
+	CheckpointEvery int64
+}
+
+// loadCheckpoint reads the checkpoint, it is empty if the file does not
+// exist
+func loadCheckpoint(path string) (Checkpoint, error) {
+	var c Checkpoint
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// save writes the checkpoint atomically
+func (c Checkpoint) save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Export writes all events of the storage to the file. With an existing
+// checkpoint the export resumes, dropping whatever was written to the file
+// after it.
+func Export(ctx context.Context, exporter storage.Exporter, opts Options) (int64, error) {
+	checkpoint, err := loadCheckpoint(opts.CheckpointFile)
+	if err != nil {
+		return 0, err
+	}
+	if checkpoint.Count > 0 {
+		log.Printf("Resuming export after %d events", checkpoint.Count)
+	}
+
+	file, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if err := file.Truncate(checkpoint.Offset); err != nil {
+		return 0, err
+	}
+	if _, err := file.Seek(checkpoint.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	offset := checkpoint.Offset
+	sync := func(cursor string) error {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if err := file.Sync(); err != nil {
+			return err
+		}
+		pos, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		offset = pos
+		checkpoint.Cursor, checkpoint.Offset = cursor, pos
+		return checkpoint.save(opts.CheckpointFile)
+	}
+
+	var cursor string
+	err = exporter.Export(ctx, checkpoint.Cursor, func(event storage.Event, next string) error {
+		if err := enc.Encode(event); err != nil {
+			return fmt.Errorf("failed to encode event %s: %w", event.Key, err)
+		}
+		checkpoint.Count++
+		cursor = next
+		if checkpoint.Count%opts.CheckpointEvery == 0 {
+			return sync(cursor)
+		}
+		return nil
+	})
+	if err != nil {
+		// Keep what was exported up to now for the next run
+		if cursor != "" && sync(cursor) == nil {
+			log.Printf("Saved checkpoint at %d events (%d bytes)", checkpoint.Count, offset)
+		}
+		return checkpoint.Count, err
+	}
+	if err := w.Flush(); err != nil {
+		return checkpoint.Count, err
+	}
+	if err := file.Sync(); err != nil {
+		return checkpoint.Count, err
+	}
+	return checkpoint.Count, removeCheckpoint(opts.CheckpointFile)
+}
+
+// Import stores the events of the file. With an existing checkpoint the
+// import resumes after the last event stored before it. Events stored
+// after the last checkpoint of an interrupted run are stored again.
+func Import(ctx context.Context, store storage.Storage, opts Options) (int64, error) {
+	checkpoint, err := loadCheckpoint(opts.CheckpointFile)
+	if err != nil {
+		return 0, err
+	}
+	if checkpoint.Count > 0 {
+		log.Printf("Resuming import after %d events", checkpoint.Count)
+	}
+
+	file, err := os.Open(opts.File)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(checkpoint.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReader(file)
+	offset := checkpoint.Offset
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			if err := importEvent(ctx, store, line); err != nil {
+				return checkpoint.Count, fmt.Errorf("event at offset %d: %w", offset, err)
+			}
+			offset += int64(len(line))
+			checkpoint.Count++
+			if checkpoint.Count%opts.CheckpointEvery == 0 {
+				checkpoint.Offset = offset
+				if err := checkpoint.save(opts.CheckpointFile); err != nil {
+					return checkpoint.Count, err
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return checkpoint.Count, err
+		}
+	}
+	return checkpoint.Count, removeCheckpoint(opts.CheckpointFile)
+}
+
+// importEvent stores an event read from an export, streaming bodies of
+// events which were stored streamed
+func importEvent(ctx context.Context, store storage.Storage, line []byte) error {
+	var event storage.Event
+	if err := json.Unmarshal(line, &event); err != nil {
+		return fmt.Errorf("invalid event: %w", err)
+	}
+	if streamer, ok := store.(storage.StreamStorer); ok && event.Streamed {
+		body := event.Body
+		event.Body = ""
+		return streamer.StoreStream(ctx, &event, strings.NewReader(body))
+	}
+	return store.Store(ctx, &event)
+}
+
+func removeCheckpoint(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package jsondiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Change describes a single difference between two JSON documents
+type Change struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// Operations reported in Change.Op
+const (
+	OpAdded   = "added"
+	OpRemoved = "removed"
+	OpChanged = "changed"
+)
+
+// DiffBytes parses two JSON documents and returns their differences
+func DiffBytes(a []byte, b []byte) ([]Change, error) {
+	var va, vb interface{}
+	if err := json.Unmarshal(a, &va); err != nil {
+		return nil, fmt.Errorf("failed to parse first document: %w", err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		return nil, fmt.Errorf("failed to parse second document: %w", err)
+	}
+	return Diff(va, vb), nil
+}
+
+// Diff returns the differences between two decoded JSON values
+func Diff(a interface{}, b interface{}) []Change {
+	changes := []Change{}
+	diff("$", a, b, &changes)
+	return changes
+}
+
+func diff(path string, a interface{}, b interface{}, changes *[]Change) {
+	switch va := a.(type) {
+	case map[string]interface{}:
+		vb, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := map[string]bool{}
+		for k := range va {
+			keys[k] = true
+		}
+		for k := range vb {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			childPath := path + "." + k
+			av, inA := va[k]
+			bv, inB := vb[k]
+			switch {
+			case !inA:
+				*changes = append(*changes, Change{Path: childPath, Op: OpAdded, New: bv})
+			case !inB:
+				*changes = append(*changes, Change{Path: childPath, Op: OpRemoved, Old: av})
+			default:
+				diff(childPath, av, bv, changes)
+			}
+		}
+		return
+	case []interface{}:
+		vb, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(va) || i < len(vb); i++ {
+			childPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(va):
+				*changes = append(*changes, Change{Path: childPath, Op: OpAdded, New: vb[i]})
+			case i >= len(vb):
+				*changes = append(*changes, Change{Path: childPath, Op: OpRemoved, Old: va[i]})
+			default:
+				diff(childPath, va[i], vb[i], changes)
+			}
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, Change{Path: path, Op: OpChanged, Old: a, New: b})
+	}
+}
@@ -0,0 +1,102 @@
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path is a compiled JSONPath expression. Only the subset addressing a
+// single value is supported: $.field, $['field'] and $.list[0].
+type Path struct {
+	expr  string
+	steps []step
+}
+
+// step is either an object key or an array index
+type step struct {
+	key   string
+	index int
+	isKey bool
+}
+
+// Compile parses a JSONPath expression
+func Compile(expr string) (*Path, error) {
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("jsonpath %q: must start with $", expr)
+	}
+
+	p := &Path{expr: expr}
+	rest := expr[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("jsonpath %q: empty field name", expr)
+			}
+			p.steps = append(p.steps, step{key: rest[:end], isKey: true})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("jsonpath %q: missing ]", expr)
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				p.steps = append(p.steps, step{key: inner[1 : len(inner)-1], isKey: true})
+				continue
+			}
+			index, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, fmt.Errorf("jsonpath %q: invalid index %q", expr, inner)
+			}
+			p.steps = append(p.steps, step{index: index})
+		default:
+			return nil, fmt.Errorf("jsonpath %q: unexpected %q", expr, rest[0])
+		}
+	}
+	return p, nil
+}
+
+// String returns the source expression
+func (p *Path) String() string {
+	return p.expr
+}
+
+// Get returns the value addressed by the path in a decoded JSON document.
+// Negative indexes count from the end of the array.
+func (p *Path) Get(doc interface{}) (interface{}, bool) {
+	current := doc
+	for _, s := range p.steps {
+		if s.isKey {
+			obj, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			current, ok = obj[s.key]
+			if !ok {
+				return nil, false
+			}
+			continue
+		}
+		arr, ok := current.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		index := s.index
+		if index < 0 {
+			index += len(arr)
+		}
+		if index < 0 || index >= len(arr) {
+			return nil, false
+		}
+		current = arr[index]
+	}
+	return current, true
+}
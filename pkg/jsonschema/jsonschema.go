@@ -0,0 +1,297 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Schema is a compiled JSON Schema. Only a subset of the keywords is
+// supported: type, enum, const, required, properties,
+// additionalProperties (boolean), items, minItems, maxItems, minLength,
+// maxLength, pattern, minimum and maximum.
+type Schema struct {
+	types                []string
+	enum                 []interface{}
+	constant             interface{}
+	hasConst             bool
+	required             []string
+	properties           map[string]*Schema
+	additionalProperties *bool
+	items                *Schema
+	minItems, maxItems   *int
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+	minimum, maximum     *float64
+}
+
+// supportedKeywords lists the keywords Compile understands, other keywords
+// are rejected so a schema is never silently weaker than written
+var supportedKeywords = map[string]bool{
+	"$schema": true, "$id": true, "title": true, "description": true,
+	"type": true, "enum": true, "const": true, "required": true,
+	"properties": true, "additionalProperties": true, "items": true,
+	"minItems": true, "maxItems": true, "minLength": true, "maxLength": true,
+	"pattern": true, "minimum": true, "maximum": true,
+}
+
+// knownTypes are the JSON Schema type names
+var knownTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+// Compile compiles a schema given as decoded JSON or YAML
+func Compile(raw interface{}) (*Schema, error) {
+	// Normalize YAML values to their JSON representation
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return compile(doc, "#")
+}
+
+func compile(doc interface{}, at string) (*Schema, error) {
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object", at)
+	}
+
+	s := &Schema{}
+	for key, value := range m {
+		if !supportedKeywords[key] {
+			return nil, fmt.Errorf("%s: unsupported keyword %q", at, key)
+		}
+		var err error
+		switch key {
+		case "type":
+			s.types, err = stringList(value)
+			for _, t := range s.types {
+				if !knownTypes[t] {
+					err = fmt.Errorf("unknown type %q", t)
+				}
+			}
+		case "enum":
+			list, ok := value.([]interface{})
+			if !ok {
+				err = fmt.Errorf("must be an array")
+			}
+			s.enum = list
+		case "const":
+			s.constant, s.hasConst = value, true
+		case "required":
+			s.required, err = stringList(value)
+		case "properties":
+			props, ok := value.(map[string]interface{})
+			if !ok {
+				err = fmt.Errorf("must be an object")
+				break
+			}
+			s.properties = map[string]*Schema{}
+			for name, prop := range props {
+				if s.properties[name], err = compile(prop, at+"/properties/"+name); err != nil {
+					return nil, err
+				}
+			}
+		case "additionalProperties":
+			b, ok := value.(bool)
+			if !ok {
+				err = fmt.Errorf("must be a boolean")
+			}
+			s.additionalProperties = &b
+		case "items":
+			s.items, err = compile(value, at+"/items")
+			if err != nil {
+				return nil, err
+			}
+		case "minItems":
+			s.minItems, err = count(value)
+		case "maxItems":
+			s.maxItems, err = count(value)
+		case "minLength":
+			s.minLength, err = count(value)
+		case "maxLength":
+			s.maxLength, err = count(value)
+		case "pattern":
+			str, ok := value.(string)
+			if !ok {
+				err = fmt.Errorf("must be a string")
+				break
+			}
+			s.pattern, err = regexp.Compile(str)
+		case "minimum":
+			s.minimum, err = number(value)
+		case "maximum":
+			s.maximum, err = number(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %w", at, key, err)
+		}
+	}
+	return s, nil
+}
+
+func stringList(value interface{}) ([]string, error) {
+	if s, ok := value.(string); ok {
+		return []string{s}, nil
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be a string or an array of strings")
+	}
+	out := make([]string, len(list))
+	for i, v := range list {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a string or an array of strings")
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+func count(value interface{}) (*int, error) {
+	f, ok := value.(float64)
+	if !ok || f < 0 || f != float64(int(f)) {
+		return nil, fmt.Errorf("must be a non-negative integer")
+	}
+	n := int(f)
+	return &n, nil
+}
+
+func number(value interface{}) (*float64, error) {
+	f, ok := value.(float64)
+	if !ok {
+		return nil, fmt.Errorf("must be a number")
+	}
+	return &f, nil
+}
+
+// Validate checks a decoded JSON document against the schema, returning
+// the first violation found
+func (s *Schema) Validate(doc interface{}) error {
+	return s.validate(doc, "$")
+}
+
+func (s *Schema) validate(doc interface{}, at string) error {
+	if len(s.types) > 0 {
+		ok := false
+		for _, t := range s.types {
+			if hasType(doc, t) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%s: expected %s", at, strings.Join(s.types, " or "))
+		}
+	}
+	if s.enum != nil {
+		ok := false
+		for _, v := range s.enum {
+			if reflect.DeepEqual(doc, v) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%s: value is not one of the allowed values", at)
+		}
+	}
+	if s.hasConst && !reflect.DeepEqual(doc, s.constant) {
+		return fmt.Errorf("%s: value does not equal the constant", at)
+	}
+
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", at, name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.properties[name]
+			if !ok {
+				if s.additionalProperties != nil && !*s.additionalProperties {
+					return fmt.Errorf("%s: property %q is not allowed", at, name)
+				}
+				continue
+			}
+			if err := prop.validate(v[name], at+"."+name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.minItems != nil && len(v) < *s.minItems {
+			return fmt.Errorf("%s: expected at least %d items", at, *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			return fmt.Errorf("%s: expected at most %d items", at, *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range v {
+				if err := s.items.validate(item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := len([]rune(v))
+		if s.minLength != nil && length < *s.minLength {
+			return fmt.Errorf("%s: expected at least %d characters", at, *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			return fmt.Errorf("%s: expected at most %d characters", at, *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fmt.Errorf("%s: does not match pattern %s", at, s.pattern)
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			return fmt.Errorf("%s: must be at least %v", at, *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			return fmt.Errorf("%s: must be at most %v", at, *s.maximum)
+		}
+	}
+	return nil
+}
+
+// hasType reports whether a decoded JSON value is of the JSON Schema type
+func hasType(doc interface{}, t string) bool {
+	switch t {
+	case "object":
+		_, ok := doc.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := doc.([]interface{})
+		return ok
+	case "string":
+		_, ok := doc.(string)
+		return ok
+	case "number":
+		_, ok := doc.(float64)
+		return ok
+	case "integer":
+		f, ok := doc.(float64)
+		return ok && f == float64(int64(f))
+	case "boolean":
+		_, ok := doc.(bool)
+		return ok
+	case "null":
+		return doc == nil
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package keygen
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// crockford is the base32 alphabet of ULIDs
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a ULID of the time: 48 bits of milliseconds and 80
+// random bits, encoded as 26 characters of Crockford base32
+func NewULID(t time.Time) string {
+	var b [16]byte
+	putMillis(b[:6], t)
+	rand.Read(b[6:])
+
+	// 128 bits in 26 characters of 5 bits, the first holding 3 bits
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// NewUUIDv7 returns a version 7 UUID of the time: 48 bits of milliseconds
+// followed by random bits
+func NewUUIDv7(t time.Time) string {
+	var b [16]byte
+	putMillis(b[:6], t)
+	rand.Read(b[6:])
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+
+	var out [36]byte
+	hex.Encode(out[0:8], b[0:4])
+	out[8] = '-'
+	hex.Encode(out[9:13], b[4:6])
+	out[13] = '-'
+	hex.Encode(out[14:18], b[6:8])
+	out[18] = '-'
+	hex.Encode(out[19:23], b[8:10])
+	out[23] = '-'
+	hex.Encode(out[24:], b[10:])
+	return string(out[:])
+}
+
+// putMillis writes the Unix milliseconds of the time as 48 bits
+func putMillis(b []byte, t time.Time) {
+	ms := uint64(t.UnixMilli())
+	for i := 5; i >= 0; i-- {
+		b[i] = byte(ms)
+		ms >>= 8
+	}
+}
+
+type ulidGenerator struct{}
+
+func (ulidGenerator) Generate(e Event) (string, error) {
+	return NewULID(e.Time), nil
+}
+
+type uuidV7Generator struct{}
+
+func (uuidV7Generator) Generate(e Event) (string, error) {
+	return NewUUIDv7(e.Time), nil
+}
@@ -0,0 +1,106 @@
+package keygen
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is what generators know about the event a key is generated for
+type Event struct {
+	// Path is the request path and Slug its slugified form
+	Path string
+	Slug string
+	// Rule is the slugified name or path of the rule handling the event
+	Rule string
+	Time time.Time
+}
+
+// Generator generates the keys of events. Keys must be unique, downstream
+// systems deduplicate by them.
+type Generator interface {
+	Generate(Event) (string, error)
+}
+
+// Options configure a strategy
+type Options struct {
+	// Template is the key template of the template strategy
+	Template string
+	// NodeID distinguishes dispatcher instances in snowflake IDs, 0-1023
+	NodeID int64
+}
+
+// Factory creates a generator of a strategy
+type Factory func(Options) (Generator, error)
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]Factory{}
+)
+
+// Built-in strategies
+const (
+	// Timestamp is webhook-<slug>-<unix seconds>, the default
+	Timestamp = "timestamp"
+	// ULID is a lexicographically sortable 26 character ULID
+	ULID = "ulid"
+	// UUIDv7 is a time-ordered RFC 9562 UUID
+	UUIDv7 = "uuidv7"
+	// Snowflake is a 64-bit decimal ID of milliseconds, node and sequence
+	Snowflake = "snowflake"
+	// Template renders Options.Template, see TemplateData
+	Template = "template"
+)
+
+// Default generates keys with the Timestamp strategy
+var Default Generator = timestampGenerator{}
+
+func init() {
+	Register(Timestamp, func(Options) (Generator, error) { return Default, nil })
+	Register(ULID, func(Options) (Generator, error) { return ulidGenerator{}, nil })
+	Register(UUIDv7, func(Options) (Generator, error) { return uuidV7Generator{}, nil })
+	Register(Snowflake, newSnowflakeGenerator)
+	Register(Template, newTemplateGenerator)
+}
+
+// Register makes a strategy available by name, replacing a strategy of
+// the same name
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	factories[name] = factory
+}
+
+// New returns a generator of the named strategy, Timestamp if empty
+func New(strategy string, opts Options) (Generator, error) {
+	if strategy == "" {
+		strategy = Timestamp
+	}
+	mu.RLock()
+	factory, ok := factories[strategy]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key strategy %q, expected one of %s", strategy, strings.Join(Strategies(), ", "))
+	}
+	return factory(opts)
+}
+
+// Strategies returns the names of the registered strategies
+func Strategies() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type timestampGenerator struct{}
+
+func (timestampGenerator) Generate(e Event) (string, error) {
+	return fmt.Sprintf("webhook-%s-%d", e.Slug, e.Time.Unix()), nil
+}
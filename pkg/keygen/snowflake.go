@@ -0,0 +1,64 @@
+package keygen
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// snowflakeEpoch is the epoch of snowflake IDs, the one of Twitter's
+var snowflakeEpoch = time.UnixMilli(1288834974657)
+
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	maxSnowflakeNode      = 1<<snowflakeNodeBits - 1
+	maxSnowflakeSequence  = 1<<snowflakeSequenceBits - 1
+)
+
+// snowflakeGenerator generates 64-bit IDs of 41 bits of milliseconds since
+// the epoch, 10 bits of node and a 12 bit sequence within the millisecond.
+// IDs follow the clock of the generator rather than the event time, so
+// they stay unique and ordered.
+type snowflakeGenerator struct {
+	node int64
+
+	mu       sync.Mutex
+	last     int64
+	sequence int64
+}
+
+func newSnowflakeGenerator(opts Options) (Generator, error) {
+	if opts.NodeID < 0 || opts.NodeID > maxSnowflakeNode {
+		return nil, fmt.Errorf("snowflake NodeID must be 0-%d", maxSnowflakeNode)
+	}
+	return &snowflakeGenerator{node: opts.NodeID}, nil
+}
+
+func (g *snowflakeGenerator) Generate(Event) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Since(snowflakeEpoch).Milliseconds()
+	if now < g.last {
+		// The clock went back, keep counting from the last millisecond
+		now = g.last
+	}
+	if now == g.last {
+		g.sequence = (g.sequence + 1) & maxSnowflakeSequence
+		if g.sequence == 0 {
+			// Sequence exhausted, wait for the next millisecond
+			for now <= g.last {
+				time.Sleep(100 * time.Microsecond)
+				now = time.Since(snowflakeEpoch).Milliseconds()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.last = now
+
+	id := now<<(snowflakeNodeBits+snowflakeSequenceBits) | g.node<<snowflakeSequenceBits | g.sequence
+	return strconv.FormatInt(id, 10), nil
+}
@@ -0,0 +1,81 @@
+package keygen
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateData is available to key templates, e.g.
+// "{{ .Rule }}-{{ .Time.Format \"20060102\" }}-{{ .ULID }}"
+type TemplateData struct {
+	Path string
+	Slug string
+	Rule string
+	Time time.Time
+	// Unix and UnixMilli are the event time
+	Unix      int64
+	UnixMilli int64
+	// ULID and UUID are generated for the event
+	ULID string
+	UUID string
+}
+
+// validKeyRegexp restricts rendered keys to characters safe in storage
+// keys and URLs
+var validKeyRegexp = regexp.MustCompile(`^[a-zA-Z0-9._:-]+$`)
+
+type templateGenerator struct {
+	tmpl *template.Template
+	// ulid and uuid are set when the template uses the IDs
+	ulid, uuid bool
+}
+
+func newTemplateGenerator(opts Options) (Generator, error) {
+	if opts.Template == "" {
+		return nil, fmt.Errorf("template strategy requires a Template")
+	}
+	tmpl, err := template.New("key").Option("missingkey=error").Parse(opts.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key template: %w", err)
+	}
+	source := tmpl.Root.String()
+	g := &templateGenerator{
+		tmpl: tmpl,
+		ulid: strings.Contains(source, ".ULID"),
+		uuid: strings.Contains(source, ".UUID"),
+	}
+	if _, err := g.Generate(Event{Path: "/", Slug: "root", Rule: "default", Time: time.Now()}); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *templateGenerator) Generate(e Event) (string, error) {
+	data := TemplateData{
+		Path:      e.Path,
+		Slug:      e.Slug,
+		Rule:      e.Rule,
+		Time:      e.Time,
+		Unix:      e.Time.Unix(),
+		UnixMilli: e.Time.UnixMilli(),
+	}
+	if g.ulid {
+		data.ULID = NewULID(e.Time)
+	}
+	if g.uuid {
+		data.UUID = NewUUIDv7(e.Time)
+	}
+
+	var b strings.Builder
+	if err := g.tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("key template: %w", err)
+	}
+	key := b.String()
+	if !validKeyRegexp.MatchString(key) {
+		return "", fmt.Errorf("key template rendered invalid key %q", key)
+	}
+	return key, nil
+}
@@ -0,0 +1,207 @@
+package manifest
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Manifest is a signed record of a single delivery attempt. Manifests form
+// a chain, each referencing the hash of the previous one, so removed or
+// reordered records are detected as well as modified ones.
+type Manifest struct {
+	Sequence      uint64    `bson:"seq" json:"seq"`
+	Time          time.Time `bson:"time" json:"time"`
+	Target        string    `bson:"target" json:"target"`
+	PayloadSHA256 string    `bson:"payload_sha256" json:"payload_sha256"`
+	Status        int       `bson:"status,omitempty" json:"status,omitempty"`
+	Error         string    `bson:"error,omitempty" json:"error,omitempty"`
+	Previous      string    `bson:"prev" json:"prev"`
+	Signature     string    `bson:"sig,omitempty" json:"sig,omitempty"`
+}
+
+// signedBytes returns the bytes covered by the signature
+func (m Manifest) signedBytes() []byte {
+	m.Signature = ""
+	data, _ := json.Marshal(m)
+	return data
+}
+
+// Verify checks the signature of the manifest, e.g. one stored with a
+// delivery record, without its chain
+func (m Manifest) Verify(key ed25519.PublicKey) error {
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil || !ed25519.Verify(key, m.signedBytes(), signature) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// Log appends signed manifests to a JSON Lines file
+type Log struct {
+	mu       sync.Mutex
+	file     *os.File
+	key      ed25519.PrivateKey
+	sequence uint64
+	previous string
+}
+
+// Open opens the manifest log for appending, continuing the chain of
+// manifests already in the file
+func Open(path string, key ed25519.PrivateKey) (*Log, error) {
+	l := &Log{key: key}
+
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			var m Manifest
+			if err := json.Unmarshal(line, &m); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("manifest log %s: %w", path, err)
+			}
+			l.sequence = m.Sequence
+			l.previous = hashLine(line)
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("manifest log %s: %w", path, err)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l.file = file
+	return l, nil
+}
+
+// Record signs and appends a manifest of a delivery attempt and returns
+// it, payloadSHA256 is the hex encoded digest of the delivered payload
+func (l *Log) Record(target string, payloadSHA256 string, status int, deliveryErr error) (Manifest, error) {
+	m := Manifest{
+		Time:          time.Now().UTC(),
+		Target:        target,
+		PayloadSHA256: payloadSHA256,
+		Status:        status,
+	}
+	if deliveryErr != nil {
+		m.Error = deliveryErr.Error()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	m.Sequence = l.sequence + 1
+	m.Previous = l.previous
+	m.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(l.key, m.signedBytes()))
+
+	line, err := json.Marshal(m)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return Manifest{}, err
+	}
+	l.sequence = m.Sequence
+	l.previous = hashLine(line)
+	return m, nil
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+// Verify checks signatures and the chain of all manifests read from r and
+// returns the number of verified manifests
+func Verify(r io.Reader, key ed25519.PublicKey) (int, error) {
+	scanner := bufio.NewScanner(r)
+	count := 0
+	var previous string
+	var sequence uint64
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		count++
+
+		var m Manifest
+		if err := json.Unmarshal(line, &m); err != nil {
+			return count - 1, fmt.Errorf("line %d: %w", count, err)
+		}
+		if err := m.Verify(key); err != nil {
+			return count - 1, fmt.Errorf("line %d: %w", count, err)
+		}
+		// The first manifest may continue a chain from a rotated file
+		if count > 1 && (m.Previous != previous || m.Sequence != sequence+1) {
+			return count - 1, fmt.Errorf("line %d: broken chain, a manifest was removed or reordered", count)
+		}
+		previous = hashLine(line)
+		sequence = m.Sequence
+	}
+	if err := scanner.Err(); err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+func hashLine(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadPrivateKey reads a PEM encoded PKCS #8 Ed25519 private key
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 private key", path)
+	}
+	return edKey, nil
+}
+
+// LoadPublicKey reads a PEM encoded PKIX Ed25519 public key
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 public key", path)
+	}
+	return edKey, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	return block, nil
+}
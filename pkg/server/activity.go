@@ -0,0 +1,132 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxRecentEvents is the number of recently received events kept in memory
+const maxRecentEvents = 20
+
+// RecentEvent is a short summary of a received webhook
+type RecentEvent struct {
+	Key  string    `json:"key"`
+	Path string    `json:"path"`
+	Size int       `json:"size"`
+	Time time.Time `json:"time"`
+}
+
+// TargetHealth holds delivery results for a single target
+type TargetHealth struct {
+	URL         string    `json:"url"`
+	Successes   int64     `json:"successes"`
+	Failures    int64     `json:"failures"`
+	LastStatus  int       `json:"last_status"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastAttempt time.Time `json:"last_attempt"`
+	Healthy     bool      `json:"healthy"`
+}
+
+// activityTracker keeps live pipeline figures in memory
+type activityTracker struct {
+	mu       sync.Mutex
+	received int64
+	today    int64
+	day      string
+	recent   []RecentEvent
+	targets  map[string]*TargetHealth
+	inFlight atomic.Int64
+}
+
+var activity = &activityTracker{targets: map[string]*TargetHealth{}}
+
+// recordEvent records a received and stored webhook
+func (a *activityTracker) recordEvent(key string, path string, size int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ingestRate.add()
+	a.received++
+	if day := time.Now().Format("2006-01-02"); day != a.day {
+		a.day = day
+		a.today = 0
+	}
+	a.today++
+	a.recent = append([]RecentEvent{{Key: key, Path: path, Size: size, Time: time.Now()}}, a.recent...)
+	if len(a.recent) > maxRecentEvents {
+		a.recent = a.recent[:maxRecentEvents]
+	}
+}
+
+// recordDelivery records the result of a delivery attempt to a target,
+// status is 0 when the request failed before receiving a response
+func (a *activityTracker) recordDelivery(url string, status int, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	th, ok := a.targets[url]
+	if !ok {
+		th = &TargetHealth{URL: url}
+		a.targets[url] = th
+	}
+
+	th.LastAttempt = time.Now()
+	th.LastStatus = status
+	th.LastError = ""
+	if err != nil {
+		th.LastError = err.Error()
+	}
+	th.Healthy = err == nil && status >= 200 && status < 300
+	if th.Healthy {
+		th.Successes++
+	} else {
+		th.Failures++
+	}
+}
+
+// deliveryStarted and deliveryFinished track deliveries in progress
+func (a *activityTracker) deliveryStarted() {
+	a.inFlight.Add(1)
+	forwardsInFlightGauge.Inc()
+}
+
+func (a *activityTracker) deliveryFinished() {
+	a.inFlight.Add(-1)
+	forwardsInFlightGauge.Dec()
+}
+
+// ActivitySnapshot is a point in time copy of the tracked activity
+type ActivitySnapshot struct {
+	Received int64          `json:"received"`
+	Today    int64          `json:"today"`
+	InFlight int64          `json:"in_flight"`
+	Recent   []RecentEvent  `json:"recent"`
+	Targets  []TargetHealth `json:"targets"`
+}
+
+// snapshot returns a copy of the current activity
+func (a *activityTracker) snapshot() ActivitySnapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.day != time.Now().Format("2006-01-02") {
+		a.today = 0
+	}
+
+	s := ActivitySnapshot{
+		Received: a.received,
+		Today:    a.today,
+		InFlight: a.inFlight.Load(),
+		Recent:   append([]RecentEvent{}, a.recent...),
+		Targets:  make([]TargetHealth, 0, len(a.targets)),
+	}
+	for _, th := range a.targets {
+		s.Targets = append(s.Targets, *th)
+	}
+	sort.Slice(s.Targets, func(i, j int) bool {
+		return s.Targets[i].URL < s.Targets[j].URL
+	})
+	return s
+}
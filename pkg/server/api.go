@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/jsondiff"
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+)
+
+// registerAPI registers the admin API handlers if ADMIN_TOKEN, API tokens
+// or OIDC are configured
+func registerAPI(mux *http.ServeMux, store storage.Storage, config *Config, auth *apiAuth) {
+	if auth == nil {
+		log.Printf("Admin API and dashboard disabled (ADMIN_TOKEN not set)")
+		return
+	}
+	if auth.oidc != nil {
+		auth.oidc.register(mux)
+		log.Printf("OIDC login enabled for %s", config.API.OIDC.Issuer)
+	}
+	mux.HandleFunc("/dashboard", auth.protectUI(auth.require(RoleViewer, handleDashboard)))
+
+	mux.HandleFunc("/api/stats", auth.require(RoleAdmin, handleStats))
+	mux.HandleFunc("/api/load", auth.require(RoleViewer, handleLoad))
+	mux.HandleFunc("/api/shapes", auth.require(RoleAdmin, handleShapes))
+	mux.HandleFunc("/api/events", auth.require(RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			handleDeleteEvents(w, r, store)
+			return
+		}
+		handleSearchEvents(w, r, store)
+	}))
+	mux.HandleFunc("/api/trash", auth.require(RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		handleTrash(w, r, store)
+	}))
+	mux.HandleFunc("/api/trash/restore", auth.require(RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		handleRestoreEvents(w, r, store)
+	}))
+	mux.HandleFunc("/api/events/diff", auth.require(RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		handleDiffEvents(w, r, store)
+	}))
+	mux.HandleFunc("/api/events/replay", auth.require(RoleOperator, func(w http.ResponseWriter, r *http.Request) {
+		handleReplayEvent(w, r, store, liveConfig.Load())
+	}))
+	mux.HandleFunc("/api/bulk", auth.require(RoleOperator, func(w http.ResponseWriter, r *http.Request) {
+		handleBulk(w, r, store, liveConfig.Load())
+	}))
+	// Rules hold secrets such as Verify and Signing keys, reading them
+	// requires the admin role as well
+	mux.HandleFunc("/api/rules", auth.requireAll(RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		handleRules(w, r, store)
+	}))
+	mux.HandleFunc("/api/deliveries", auth.require(RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		handleDeliveries(w, r, store)
+	}))
+	mux.HandleFunc("/api/dlq", auth.require(RoleOperator, handleDeadLetters))
+	mux.HandleFunc("/api/dlq/replay", auth.require(RoleOperator, func(w http.ResponseWriter, r *http.Request) {
+		handleReplayDeadLetter(w, r, store)
+	}))
+	mux.HandleFunc("/api/debug/capture", auth.require(RoleOperator, handleDebugCapture))
+	log.Printf("Admin API enabled on /api/")
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+// handleStats returns payload statistics per path and the delivery backlog
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"since":     startTime,
+		"paths":     payloadStats.snapshot(),
+		"queue":     deliveries.snapshot(),
+		"in_flight": activity.inFlight.Load(),
+	})
+}
+
+// searchFieldRegexp restricts field names in search queries to plain JSON paths
+var searchFieldRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*$`)
+
+// searchMetaFields are event metadata fields searchable by name, as well
+// as rule labels (labels.<name>), other fields refer to the payload
+var searchMetaFields = map[string]bool{
+	"remote_ip":   true,
+	"geo.country": true,
+	"geo.city":    true,
+	"geo.asn":     true,
+	"geo.as_org":  true,
+	"flags":       true,
+	"rule":        true,
+	"raw_path":    true,
+}
+
+// parseSearchQuery parses a query like "order_id:12345 refund" into
+// field conditions and free text terms
+func parseSearchQuery(q string) (storage.SearchQuery, error) {
+	query := storage.SearchQuery{Fields: map[string]string{}, Meta: map[string]string{}}
+	var text []string
+	for _, term := range strings.Fields(q) {
+		field, value, ok := strings.Cut(term, ":")
+		if !ok || field == "" || value == "" {
+			text = append(text, term)
+			continue
+		}
+		if searchMetaFields[field] || strings.HasPrefix(field, "labels.") && searchFieldRegexp.MatchString(field) {
+			query.Meta[field] = value
+			continue
+		}
+		if !searchFieldRegexp.MatchString(field) {
+			return query, fmt.Errorf("invalid field name: %s", field)
+		}
+		query.Fields[field] = value
+	}
+	query.Text = strings.Join(text, " ")
+	return query, nil
+}
+
+// maxSearchEvents limits the events returned by a search
+const maxSearchEvents = 500
+
+// handleSearchEvents lists stored events, optionally filtered by a search query
+func handleSearchEvents(w http.ResponseWriter, r *http.Request, store storage.Storage) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+
+	searcher, ok := store.(storage.Searcher)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, ErrCodeNotImplemented, "Search requires MongoDB storage")
+		return
+	}
+
+	query, err := parseSearchQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+	query.Path = r.URL.Query().Get("path")
+	query.Limit = 50
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || n < 1 || n > maxSearchEvents {
+			writeError(w, http.StatusBadRequest, ErrCodeBadRequest, fmt.Sprintf("limit must be 1-%d", maxSearchEvents))
+			return
+		}
+		query.Limit = n
+	}
+
+	events, err := searcher.Search(r.Context(), query)
+	if errors.Is(err, storage.ErrNotSupported) {
+		writeError(w, http.StatusNotImplemented, ErrCodeNotImplemented, "Search requires MongoDB storage")
+		return
+	}
+	if errors.Is(err, storage.ErrUnavailable) {
+		writeError(w, http.StatusServiceUnavailable, ErrCodeStorageUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeStorageFailed, "Failed to search events")
+		log.Printf("Failed to search events: %v", err)
+		return
+	}
+
+	// Bodies are shown as previews unless the full bodies are requested
+	if r.URL.Query().Get("full") != "1" {
+		for i := range events {
+			events[i].Body = previewConfig.render([]byte(events[i].Body))
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"count":  len(events),
+		"events": events,
+	})
+}
+
+// handleDiffEvents compares the bodies of two stored events
+func handleDiffEvents(w http.ResponseWriter, r *http.Request, store storage.Storage) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+
+	keyA := r.URL.Query().Get("a")
+	keyB := r.URL.Query().Get("b")
+	if keyA == "" || keyB == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeBadRequest, "Both a and b event keys are required")
+		return
+	}
+
+	changes, err := DiffEvents(r.Context(), store, keyA, keyB)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, "Failed to diff events")
+		log.Printf("Failed to diff events %s and %s: %v", keyA, keyB, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"a":       keyA,
+		"b":       keyB,
+		"equal":   len(changes) == 0,
+		"changes": changes,
+	})
+}
+
+// DiffEvents loads two stored events and returns the JSON diff of their bodies
+func DiffEvents(ctx context.Context, store storage.Storage, keyA string, keyB string) ([]jsondiff.Change, error) {
+	a, err := store.Get(ctx, keyA)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyA, err)
+	}
+	b, err := store.Get(ctx, keyB)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyB, err)
+	}
+	return jsondiff.DiffBytes([]byte(a.Body), []byte(b.Body))
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Roles of admin API tokens, each includes the permissions of the
+// previous one
+const (
+	// RoleViewer can browse events, stats and captures
+	RoleViewer = "viewer"
+	// RoleOperator can also replay events and arm debug captures
+	RoleOperator = "operator"
+	// RoleAdmin can also delete data and edit rules
+	RoleAdmin = "admin"
+)
+
+var roleLevels = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// APIConfig configures access to the admin API
+type APIConfig struct {
+	// Tokens accepted in addition to ADMIN_TOKEN, which has the admin role
+	Tokens []APIToken `yaml:"Tokens"`
+	// OIDC enables single sign-on, required for the dashboard when set
+	OIDC OIDCConfig `yaml:"OIDC"`
+	// Lockout rate-limits admin API clients and locks out those failing
+	// authentication
+	Lockout LockoutConfig `yaml:"Lockout"`
+}
+
+// APIToken is a bearer token with a role
+type APIToken struct {
+	Name   string `yaml:"Name"`
+	Role   string `yaml:"Role"`
+	Secret `yaml:",inline"`
+}
+
+// prepare validates the roles and resolves the token secrets
+func (a *APIConfig) prepare() error {
+	for i := range a.Tokens {
+		token := &a.Tokens[i]
+		if token.Name == "" {
+			token.Name = fmt.Sprintf("token-%d", i)
+		}
+		if _, ok := roleLevels[token.Role]; !ok {
+			return fmt.Errorf("api token %s: unknown role %q", token.Name, token.Role)
+		}
+		if err := token.load(); err != nil {
+			return fmt.Errorf("api token %s: %w", token.Name, err)
+		}
+		if token.Get() == "" {
+			return fmt.Errorf("api token %s: empty token", token.Name)
+		}
+	}
+	if err := a.Lockout.prepare(); err != nil {
+		return err
+	}
+	return a.OIDC.prepare()
+}
+
+// apiAuth authenticates admin API and dashboard requests
+type apiAuth struct {
+	tokens  []APIToken
+	oidc    *oidcLogin
+	limiter *authLimiter
+}
+
+// newAPIAuth returns the authenticator for ADMIN_TOKEN, the configured
+// tokens and OIDC login, or nil when none is configured
+func newAPIAuth(adminToken string, config *Config) (*apiAuth, error) {
+	// The config may be empty when it failed to load, prepare sets defaults
+	lockout := config.API.Lockout
+	if err := lockout.prepare(); err != nil {
+		return nil, err
+	}
+	auth := &apiAuth{limiter: newAuthLimiter(lockout)}
+	if adminToken != "" {
+		token := APIToken{Name: "ADMIN_TOKEN", Role: RoleAdmin}
+		token.resolved = adminToken
+		auth.tokens = append(auth.tokens, token)
+	}
+	auth.tokens = append(auth.tokens, config.API.Tokens...)
+	if config.API.OIDC.enabled() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		login, err := newOIDCLogin(ctx, &config.API.OIDC)
+		if err != nil {
+			return nil, err
+		}
+		auth.oidc = login
+	}
+	if len(auth.tokens) == 0 && auth.oidc == nil {
+		return nil, nil
+	}
+	return auth, nil
+}
+
+// authenticate returns the name and role of the bearer token or OIDC
+// session of the request
+func (a *apiAuth) authenticate(r *http.Request) (string, string, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		provided := strings.TrimPrefix(header, "Bearer ")
+		for i := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(a.tokens[i].Get())) == 1 {
+				return a.tokens[i].Name, a.tokens[i].Role, true
+			}
+		}
+		return "", "", false
+	}
+	if a.oidc != nil {
+		if s, ok := a.oidc.session(r); ok {
+			return s.Name, s.Role, true
+		}
+	}
+	return "", "", false
+}
+
+// protectUI redirects to the OIDC login when a web UI page is requested
+// without a session or bearer token. Without OIDC it does nothing, pages
+// are still protected by require.
+func (a *apiAuth) protectUI(next http.HandlerFunc) http.HandlerFunc {
+	if a == nil || a.oidc == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := a.oidc.session(r); !ok && r.Header.Get("Authorization") == "" {
+			http.Redirect(w, r, oidcLoginPath+"?redirect="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// require wraps a handler with bearer token authentication. Reads need the
+// viewer role, deletes the admin role and other methods writeRole.
+func (a *apiAuth) require(writeRole string, next http.HandlerFunc) http.HandlerFunc {
+	return a.requireRole(func(method string) string {
+		switch method {
+		case http.MethodGet, http.MethodHead:
+			return RoleViewer
+		case http.MethodDelete:
+			return RoleAdmin
+		}
+		return writeRole
+	}, next)
+}
+
+// requireAll wraps a handler with bearer token authentication requiring
+// the role for every method, for endpoints whose reads expose secrets
+func (a *apiAuth) requireAll(role string, next http.HandlerFunc) http.HandlerFunc {
+	return a.requireRole(func(string) string { return role }, next)
+}
+
+// requireRole wraps a handler with bearer token authentication, requiring
+// the role returned for the request method
+func (a *apiAuth) requireRole(methodRole func(string) string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r).String()
+		if wait, ok := a.limiter.allow(ip); !ok {
+			writeLockedOut(w, wait)
+			return
+		}
+		name, tokenRole, ok := a.authenticate(r)
+		if !ok {
+			if a.limiter.fail(ip) {
+				a.limiter.lockOut(r, ip)
+			}
+			writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Missing or invalid admin token")
+			return
+		}
+
+		role := methodRole(r.Method)
+		if roleLevels[tokenRole] < roleLevels[role] {
+			writeError(w, http.StatusForbidden, ErrCodeForbidden, fmt.Sprintf("%s %s requires the %s role", r.Method, r.URL.Path, role))
+			return
+		}
+
+		if role != RoleViewer {
+			log.Printf("Admin API: %s %s by %s", r.Method, r.URL.Path, name)
+		}
+		next(w, r)
+	}
+}
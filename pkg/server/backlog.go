@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+)
+
+// QueueName is the name of the Redis list mirroring the delivery backlog,
+// set by the --queue-name flag or QUEUE_NAME. See storage.RedisQueue for
+// the naming convention.
+var QueueName string
+
+// backlogReconcileInterval is how often the mirrored backlog is reconciled
+// with the deliveries in progress
+const backlogReconcileInterval = 15 * time.Second
+
+// backlogMirror keeps the Redis backlog list in sync with the deliveries
+// of this instance
+type backlogMirror struct {
+	name     string
+	instance string
+	seq      atomic.Uint64
+
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+// backlog is nil unless QueueName is set
+var backlog *backlogMirror
+
+// startBacklogMirror starts mirroring the delivery backlog to Redis if
+// QueueName is set
+func startBacklogMirror() {
+	if QueueName == "" {
+		return
+	}
+	instance, _ := os.Hostname()
+	backlog = &backlogMirror{
+		name:     QueueName,
+		instance: instance + "-" + strconv.Itoa(os.Getpid()),
+		pending:  map[string]bool{},
+	}
+	go backlog.run()
+	log.Printf("Mirroring delivery backlog to Redis list %s", QueueName)
+}
+
+// queue returns the Redis queue, nil while Redis is not connected
+func (b *backlogMirror) queue() *storage.RedisQueue {
+	redis := connectedRedis.Load()
+	if redis == nil {
+		return nil
+	}
+	return redis.Queue(b.name, b.instance)
+}
+
+// add records a delivery, the returned function removes it once finished
+func (b *backlogMirror) add() func() {
+	if b == nil {
+		return func() {}
+	}
+	id := strconv.FormatUint(b.seq.Add(1), 10)
+	b.mu.Lock()
+	b.pending[id] = true
+	b.mu.Unlock()
+	b.update(id, (*storage.RedisQueue).Push)
+
+	return func() {
+		b.mu.Lock()
+		delete(b.pending, id)
+		b.mu.Unlock()
+		b.update(id, (*storage.RedisQueue).Remove)
+	}
+}
+
+// update applies a change to the Redis list, failures are fixed by the
+// next reconciliation
+func (b *backlogMirror) update(id string, op func(*storage.RedisQueue, context.Context, string) error) {
+	q := b.queue()
+	if q == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+	if err := op(q, ctx, id); err != nil {
+		log.Printf("Failed to update delivery backlog %s: %v", b.name, err)
+	}
+}
+
+// isPending reports whether the delivery is still queued or in progress
+func (b *backlogMirror) isPending(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pending[id]
+}
+
+// run periodically reconciles the Redis list, removing entries left by
+// failed updates and stopped instances
+func (b *backlogMirror) run() {
+	defer recoverGoroutine("delivery backlog mirror")
+
+	ticker := time.NewTicker(backlogReconcileInterval)
+	defer ticker.Stop()
+	for {
+		if q := b.queue(); q != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+			if err := q.Reconcile(ctx, b.isPending, 4*backlogReconcileInterval); err != nil {
+				log.Printf("Failed to reconcile delivery backlog %s: %v", b.name, err)
+			}
+			cancel()
+		}
+		<-ticker.C
+	}
+}
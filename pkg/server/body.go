@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"unsafe"
+)
+
+// streamThreshold is the body size above which request bodies are spilled
+// to a temporary file in BODY_SPOOL_DIR instead of being held in memory,
+// configurable via STREAM_THRESHOLD (bytes)
+var streamThreshold int64 = 8 << 20
+
+// maxBodySize limits request bodies including spilled ones, configurable
+// via MAX_BODY_SIZE (bytes)
+var maxBodySize int64 = 256 << 20
+
+// errBodyTooLarge is returned by readBody for bodies over maxBodySize
+var errBodyTooLarge = errors.New("request body too large")
+
+// payload is a request body, either held in memory or spilled to a file.
+// Spilled payloads are stored with storage.StreamStorer and forwarded
+// straight from the file.
+type payload struct {
+	data []byte
+	file string
+	size int64
+	hash string
+
+	// key and path of the event, recorded with its deliveries
+	key, path string
+}
+
+// memoryPayload returns a payload held in memory
+func memoryPayload(data []byte) payload {
+	return payload{data: data, size: int64(len(data))}
+}
+
+// withData returns a payload of the same event held in memory, e.g. the
+// result of processing the payload
+func (p payload) withData(data []byte) payload {
+	out := memoryPayload(data)
+	out.key, out.path = p.key, p.path
+	return out
+}
+
+// spilled reports whether the payload is kept in a file
+func (p payload) spilled() bool {
+	return p.file != ""
+}
+
+// open returns a reader of the whole payload
+func (p payload) open() (io.ReadSeekCloser, error) {
+	if p.spilled() {
+		return os.Open(p.file)
+	}
+	return nopCloser{bytes.NewReader(p.data)}, nil
+}
+
+// head returns up to n leading bytes of the payload
+func (p payload) head(n int) []byte {
+	if !p.spilled() {
+		return p.data[:min(n, len(p.data))]
+	}
+	f, err := os.Open(p.file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	buf := make([]byte, n)
+	read, _ := io.ReadFull(f, buf)
+	return buf[:read]
+}
+
+// sha256 returns the hex encoded SHA-256 digest of the payload
+func (p payload) sha256() string {
+	if p.hash != "" {
+		return p.hash
+	}
+	sum := sha256.Sum256(p.data)
+	return hex.EncodeToString(sum[:])
+}
+
+// remove deletes the file of a spilled payload
+func (p payload) remove() {
+	if p.spilled() {
+		os.Remove(p.file)
+	}
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+// readBody reads a request body, spilling it to a temporary file once it
+// exceeds streamThreshold so large payloads do not have to fit in memory.
+// With a known content length the body is read into a single buffer of
+// the exact size.
+func readBody(r io.Reader, contentLength int64) (payload, error) {
+	if contentLength > maxBodySize {
+		return payload{}, errBodyTooLarge
+	}
+	if contentLength >= 0 && contentLength <= streamThreshold {
+		data := make([]byte, contentLength)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return payload{}, err
+		}
+		return memoryPayload(data), nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, streamThreshold+1))
+	if err != nil {
+		return payload{}, err
+	}
+	if int64(len(data)) > maxBodySize {
+		return payload{}, errBodyTooLarge
+	}
+	if int64(len(data)) <= streamThreshold {
+		return memoryPayload(data), nil
+	}
+
+	f, err := os.CreateTemp(os.Getenv("BODY_SPOOL_DIR"), "webhook-body-*")
+	if err != nil {
+		return payload{}, fmt.Errorf("failed to create body file: %w", err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	w := io.MultiWriter(f, hash)
+	n, err := w.Write(data)
+	if err == nil {
+		var rest int64
+		rest, err = io.Copy(w, io.LimitReader(r, maxBodySize-int64(n)+1))
+		n += int(rest)
+	}
+	if err == nil && int64(n) > maxBodySize {
+		err = errBodyTooLarge
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return payload{}, err
+	}
+	return payload{file: f.Name(), size: int64(n), hash: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// validateJSON checks the payload is a single valid JSON value without
+// decoding it into memory
+func validateJSON(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			break
+		}
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return errors.New("invalid character after top-level value")
+	}
+	return nil
+}
+
+// bytesToString returns the bytes as a string without copying them, the
+// bytes must not be modified afterwards
+func bytesToString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return unsafe.String(&b[0], len(b))
+}
+
+// sizeFromEnv reads a size in bytes from the environment variable
+func sizeFromEnv(name string, fallback int64) int64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 1 {
+		log.Printf("Warning: Invalid %s %q, using default %d", name, v, fallback)
+		return fallback
+	}
+	return n
+}
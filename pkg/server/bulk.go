@@ -0,0 +1,261 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+)
+
+const (
+	// maxBulkSize limits the body of a bulk ingestion request
+	maxBulkSize = 64 << 20
+	// maxBulkEvents limits the events of a bulk ingestion request
+	maxBulkEvents = 10000
+)
+
+// BulkEvent is an event of a bulk ingestion request
+type BulkEvent struct {
+	// Path is the virtual path the event is handled as, selecting its rule
+	Path string `json:"path"`
+	// Body is the JSON payload of the event
+	Body json.RawMessage `json:"body"`
+	// Headers are matched by rule conditions and forwarded like request
+	// headers
+	Headers map[string]string `json:"headers,omitempty"`
+	// Timestamp is the time of the event, defaults to now
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+}
+
+// Outcomes of bulk events
+const (
+	BulkAccepted    = "accepted"
+	BulkDropped     = "dropped"
+	BulkDuplicate   = "duplicate"
+	BulkRateLimited = "rate_limited"
+	BulkRejected    = "rejected"
+)
+
+// bulkResult is the outcome of an event of a bulk ingestion request
+type bulkResult struct {
+	Index   int    `json:"index"`
+	Key     string `json:"key,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Outcome string `json:"outcome"`
+	Stored  bool   `json:"stored"`
+	Targets int    `json:"targets,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// handleBulk ingests a JSON array or NDJSON body of events, e.g. to
+// backfill events from other systems. Each event is matched, stored and
+// dispatched individually like a webhook to its path, except that
+// signatures are not verified. Events failing are reported and the others
+// still ingested. Keys of the default strategy have a resolution of one
+// second, backfills should use a Keys strategy like ulid so events of the
+// same second do not overwrite each other.
+func handleBulk(w http.ResponseWriter, r *http.Request, store storage.Storage, config *Config) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+
+	events, err := readBulkEvents(http.MaxBytesReader(w, r.Body, maxBulkSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, fmt.Sprintf("Bulk requests are limited to %d bytes", maxBulkSize))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJSON, err.Error())
+		return
+	}
+
+	results := make([]bulkResult, len(events))
+	accepted := 0
+	for i, event := range events {
+		results[i] = ingestBulkEvent(r.Context(), event, store, config)
+		results[i].Index = i
+		if results[i].Outcome != BulkRejected {
+			accepted++
+		}
+	}
+
+	log.Printf("Bulk ingested %d of %d events", accepted, len(events))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"count":    len(events),
+		"accepted": accepted,
+		"rejected": len(events) - accepted,
+		"results":  results,
+	})
+}
+
+// readBulkEvents decodes a JSON array of events or one event per line
+func readBulkEvents(r io.Reader) ([]BulkEvent, error) {
+	br := bufio.NewReader(r)
+	array := false
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no events")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b == ' ' || b == '\t' || b == '\r' || b == '\n' {
+			continue
+		}
+		array = b == '['
+		br.UnreadByte()
+		break
+	}
+
+	dec := json.NewDecoder(br)
+	if array {
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+	}
+	var events []BulkEvent
+	for array && dec.More() || !array {
+		var event BulkEvent
+		if err := dec.Decode(&event); err == io.EOF && !array {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("event %d: %w", len(events), err)
+		}
+		events = append(events, event)
+		if len(events) > maxBulkEvents {
+			return nil, fmt.Errorf("bulk requests are limited to %d events", maxBulkEvents)
+		}
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("no events")
+	}
+	return events, nil
+}
+
+// ingestBulkEvent stores and dispatches an event of a bulk request
+func ingestBulkEvent(ctx context.Context, e BulkEvent, store storage.Storage, config *Config) (result bulkResult) {
+	result = bulkResult{Path: e.Path, Outcome: BulkRejected}
+	if e.Path == "" || e.Path[0] != '/' {
+		result.Error = "path must start with /"
+		return result
+	}
+	if len(e.Body) == 0 {
+		result.Error = "body is required"
+		return result
+	}
+	now := time.Now()
+	if e.Timestamp != nil {
+		now = *e.Timestamp
+	}
+
+	headers := http.Header{}
+	for name, value := range e.Headers {
+		headers.Set(name, value)
+	}
+	headers.Set("Content-Type", "application/json")
+	in := ruleInput{Path: e.Path, Method: http.MethodPost, Headers: headers}
+	if err := json.Unmarshal(e.Body, &in.Body); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	in.HasBody = true
+
+	rule := findRule(in, config)
+	if rule != nil && rule.Drop {
+		result.Outcome = BulkDropped
+		return result
+	}
+	if rule != nil && rule.Schema != "" {
+		version, err := rule.matchSchema(in.Body)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		in.SchemaVersion = version
+	}
+	releaseDedup, ok := rule.claimDedup(ctx, in)
+	if !ok {
+		result.Outcome = BulkDuplicate
+		return result
+	}
+	defer func() {
+		if result.Outcome != BulkAccepted {
+			releaseDedup()
+		}
+	}()
+
+	key := eventKeyAt(e.Path, rule, now)
+	in.Key, result.Key = key, key
+	var targets []Target
+	if rule != nil {
+		var err error
+		if targets, err = rule.renderTargets(in); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+	if rule != nil && len(targets) > 0 && (!rule.forwardsAt(time.Now()) || !rule.sampled()) {
+		targets = nil
+	}
+	additional, err := renderAdditional(in, config, rule, targets)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	event := &storage.Event{
+		Key:       key,
+		Path:      e.Path,
+		RawPath:   e.Path,
+		Timestamp: now,
+		Body:      string(e.Body),
+		Payload:   in.Body,
+	}
+	if rule != nil {
+		event.Rule, event.Labels = rule.label(), rule.Labels
+	}
+	if in.SchemaVersion != 0 {
+		event.Schema, event.SchemaVersion = rule.Schema, in.SchemaVersion
+	}
+	if rule.stores() {
+		storeCtx, cancel := context.WithTimeout(ctx, storageTimeout)
+		defer cancel()
+		if err := store.Store(storeCtx, event); err != nil {
+			log.Printf("Failed to store bulk event %s: %v", key, err)
+			result.Error = "failed to store event"
+			return result
+		}
+		result.Stored = true
+	}
+	activity.recordEvent(key, e.Path, len(e.Body))
+
+	result.Outcome = BulkAccepted
+	p := memoryPayload(e.Body)
+	p.key, p.path = key, e.Path
+	if rule != nil && len(targets) > 0 {
+		delay, allowed := rule.throttle()
+		if !allowed {
+			result.Outcome = BulkRateLimited
+			return result
+		}
+		dispatchAfter(delay, rule, targets, p, rule.forwardHeaders(in))
+		result.Targets = len(targets)
+	}
+	for _, rt := range additional {
+		if delay, ok := rt.rule.throttle(); ok {
+			dispatchAfter(delay, rt.rule, rt.targets, p, rt.rule.forwardHeaders(in))
+			result.Targets += len(rt.targets)
+		}
+	}
+	return result
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Circuit breaker actions when the circuit is open
+const (
+	// CircuitOpenFail fails deliveries right away, so fallbacks and the
+	// dead letter queue take them
+	CircuitOpenFail = "fail"
+	// CircuitOpenWait holds deliveries until the circuit closes
+	CircuitOpenWait = "wait"
+)
+
+// Circuit breaker defaults
+const (
+	defaultCircuitFailures = 5
+	defaultCircuitCoolDown = 30 * time.Second
+)
+
+// errCircuitOpen fails deliveries to targets with an open circuit
+var errCircuitOpen = errors.New("circuit breaker open")
+
+var (
+	circuitOpenGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webhook_dispatcher_circuit_open",
+		Help: "Number of open circuit breakers by target",
+	}, []string{"target"})
+	circuitRejectedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_dispatcher_circuit_rejected_total",
+		Help: "Number of deliveries failed by an open circuit by target",
+	}, []string{"target"})
+)
+
+func init() {
+	prometheus.MustRegister(circuitOpenGauge, circuitRejectedCounter)
+}
+
+// CircuitBreakerConfig stops deliveries to a target after consecutive
+// failures for a cool-down period, after which a single trial delivery
+// closes the circuit again or reopens it. Network errors, 408, 429 and
+// 5xx responses are failures, like for retries.
+type CircuitBreakerConfig struct {
+	// Failures is the number of consecutive failures opening the circuit,
+	// defaults to 5
+	Failures int `yaml:"Failures"`
+	// CoolDown is how long the circuit stays open, defaults to 30s
+	CoolDown time.Duration `yaml:"CoolDown"`
+	// OnOpen is fail (default) or wait
+	OnOpen string `yaml:"OnOpen"`
+}
+
+// prepare validates the circuit breaker and applies defaults
+func (c *CircuitBreakerConfig) prepare() error {
+	if c.Failures == 0 {
+		c.Failures = defaultCircuitFailures
+	}
+	if c.CoolDown == 0 {
+		c.CoolDown = defaultCircuitCoolDown
+	}
+	if c.Failures < 0 || c.CoolDown < 0 {
+		return fmt.Errorf("invalid circuit breaker Failures or CoolDown")
+	}
+	switch c.OnOpen {
+	case "":
+		c.OnOpen = CircuitOpenFail
+	case CircuitOpenFail, CircuitOpenWait:
+	default:
+		return fmt.Errorf("unknown circuit breaker OnOpen %q", c.OnOpen)
+	}
+	return nil
+}
+
+// circuit is the breaker state of a target URL
+type circuit struct {
+	mu       sync.Mutex
+	failures int
+	// openUntil is set while the circuit is open or half-open, when it is
+	// in the past
+	openUntil time.Time
+	// trial is set while the trial delivery of a half-open circuit runs
+	trial bool
+}
+
+// circuits holds the circuit of each target URL
+var circuits sync.Map
+
+// circuitFor returns the circuit of the target URL
+func circuitFor(url string) *circuit {
+	c, _ := circuits.LoadOrStore(url, &circuit{})
+	return c.(*circuit)
+}
+
+// allow reports whether a delivery may start, or how long to wait
+func (c *circuit) allow(now time.Time) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.openUntil.IsZero():
+		return 0, true
+	case now.Before(c.openUntil):
+		return c.openUntil.Sub(now), false
+	case c.trial:
+		return time.Second, false
+	}
+	c.trial = true
+	return 0, true
+}
+
+// record updates the circuit with the outcome of a delivery
+func (c *circuit) record(target Target, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ok {
+		c.failures = 0
+		if !c.openUntil.IsZero() {
+			c.openUntil, c.trial = time.Time{}, false
+			circuitOpenGauge.WithLabelValues(target.label()).Dec()
+			log.Printf("Circuit breaker of %s closed", target.URL)
+		}
+		return
+	}
+
+	c.failures++
+	if !c.trial && c.failures < target.CircuitBreaker.Failures {
+		return
+	}
+	if c.openUntil.IsZero() {
+		circuitOpenGauge.WithLabelValues(target.label()).Inc()
+	}
+	c.openUntil, c.trial = time.Now().Add(target.CircuitBreaker.CoolDown), false
+	log.Printf("Circuit breaker of %s opened after %d failures, pausing deliveries for %s", target.URL, c.failures, target.CircuitBreaker.CoolDown)
+}
+
+// await returns once the circuit allows a delivery, or errCircuitOpen
+// when the breaker fails deliveries on an open circuit
+func (c *circuit) await(ctx context.Context, target Target) error {
+	for {
+		wait, ok := c.allow(time.Now())
+		if ok {
+			return nil
+		}
+		if target.CircuitBreaker.OnOpen != CircuitOpenWait {
+			return errCircuitOpen
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// deliverThroughCircuit delivers to the target unless its circuit breaker
+// is open
+func deliverThroughCircuit(ctx context.Context, target Target, body payload, headers http.Header) DeliveryResult {
+	if target.CircuitBreaker == nil {
+		return deliver(ctx, target, body, headers)
+	}
+	c := circuitFor(target.destination())
+	if err := c.await(ctx, target); err != nil {
+		circuitRejectedCounter.WithLabelValues(target.label()).Inc()
+		log.Printf("Delivery to %s not started: %v", target.URL, err)
+		return DeliveryResult{URL: target.URL, Error: err.Error()}
+	}
+	result := deliver(ctx, target, body, headers)
+	c.record(target, !retryable(result))
+	return result
+}
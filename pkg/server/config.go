@@ -0,0 +1,575 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/sikalabs/webhook-dispatcher/pkg/wasm"
+	"gopkg.in/yaml.v3"
+)
+
+// Config represents the webhook dispatch configuration
+type Config struct {
+	Meta struct {
+		SchemaVersion int `yaml:"SchemaVersion"`
+	} `yaml:"Meta"`
+	// Outbound configures requests forwarded to targets
+	Outbound OutboundConfig `yaml:"Outbound"`
+	// API configures admin API tokens and their roles
+	API APIConfig `yaml:"API"`
+	// ResponseHeaders are added to every ingestion response
+	ResponseHeaders map[string]string `yaml:"ResponseHeaders"`
+	// PassHeaders lists incoming headers forwarded to the targets of all
+	// rules, e.g. X-GitHub-Event. Only Content-Type and trace context are
+	// forwarded otherwise.
+	PassHeaders []string `yaml:"PassHeaders"`
+	// CORS allows browser-based senders from other origins
+	CORS *CORSConfig `yaml:"CORS"`
+	// TargetGroups are named target lists rules can reference
+	TargetGroups map[string][]Target `yaml:"TargetGroups"`
+	// Schemas are named and versioned payload schemas rules can require
+	Schemas map[string][]SchemaVersion `yaml:"Schemas"`
+	// Preview controls how payloads appear in logs and the admin API
+	Preview PreviewConfig `yaml:"Preview"`
+	// Slug controls how paths are turned into event keys
+	Slug SlugConfig `yaml:"Slug"`
+	// Keys selects the format of event keys
+	Keys KeyConfig `yaml:"Keys"`
+	// Notifications sends meta-notifications about failed deliveries
+	Notifications *NotificationConfig `yaml:"Notifications"`
+	// Consumers poll for the events of pull://<name> targets
+	Consumers []PullConsumer `yaml:"Consumers"`
+	// Match is first (default, the first matching rule handles the event)
+	// or all (the targets of all matching rules receive the event, the
+	// first rule still decides verification, storage and the response)
+	Match    string         `yaml:"Match"`
+	Dispatch []DispatchRule `yaml:"Dispatch"`
+	// Include lists files or glob patterns, relative to the including
+	// file, whose Dispatch rules, TargetGroups and Schemas are merged into
+	// the config
+	Include []string `yaml:"Include"`
+	// Default receives webhooks matching no Dispatch rule, so nothing is
+	// stored without being forwarded. It takes no Path, other conditions
+	// still apply.
+	Default *DispatchRule `yaml:"Default"`
+
+	// needsBody is set when rules match on or render from the parsed payload
+	needsBody bool
+}
+
+// DispatchRule represents a single dispatch rule
+type DispatchRule struct {
+	// Name identifies the rule in logs, metrics and stored events instead
+	// of its path
+	Name string `yaml:"Name"`
+	// Labels are stored with the events the rule handles and exported by
+	// the webhook_dispatcher_rule_labels metric
+	Labels map[string]string `yaml:"Labels"`
+	// Path is matched exactly, or as a glob when it contains wildcards
+	// (/github/* matches one segment, /hooks/** any number of segments).
+	// Segments like :owner in /repos/:owner/:repo are parameters matching
+	// one segment, available to When as params.owner, to target URL
+	// templates as .Params.owner and to processors as the
+	// X-Webhook-Param-Owner header.
+	Path string `yaml:"Path"`
+	// PathRegex matches the path with a regular expression instead, capture
+	// groups are available to target URL templates
+	PathRegex string `yaml:"PathRegex"`
+	// NotPathRegex excludes paths matching the regular expression
+	NotPathRegex string `yaml:"NotPathRegex"`
+	// Methods restricts the rule to the listed HTTP methods
+	Methods []string `yaml:"Methods"`
+	// OnMethodMismatch is ignore (default, the rule does not match) or
+	// reject (respond 405)
+	OnMethodMismatch string `yaml:"OnMethodMismatch"`
+	// ForwardQuery appends the query string of the request to the target
+	// URLs, e.g. to keep ?env= or ?token= parameters
+	ForwardQuery bool `yaml:"ForwardQuery"`
+	// ForwardMethod is the default ForwardMethod of the rule targets, e.g.
+	// original to forward PUT, PATCH and DELETE requests as they came
+	ForwardMethod string `yaml:"ForwardMethod"`
+	// MatchHeaders restricts the rule to requests carrying the given header
+	// values (e.g. X-GitHub-Event: push), * matches any value
+	MatchHeaders map[string]string `yaml:"MatchHeaders"`
+	// NotHeaders excludes requests carrying any of the given header values,
+	// e.g. X-CI-Skip: "true", * excludes requests having the header at all
+	NotHeaders map[string]string `yaml:"NotHeaders"`
+	// MatchContentType restricts the rule to the listed media types, e.g.
+	// application/x-www-form-urlencoded or text/*. Payloads of non-JSON
+	// types the rule matches are accepted without JSON validation.
+	MatchContentType []string `yaml:"MatchContentType"`
+	// MatchBody restricts the rule to payloads with a given value, evaluated
+	// after the payload has been validated as JSON
+	MatchBody *BodyCondition `yaml:"MatchBody"`
+	// NotBody excludes payloads matching the condition
+	NotBody *BodyCondition `yaml:"NotBody"`
+	// When is a CEL expression over path, method, headers, params (path
+	// parameters) and body (the parsed payload), e.g. body.action ==
+	// "opened". Header names are lower case: headers["x-github-event"] ==
+	// "pull_request"
+	When string `yaml:"When"`
+	// MatchSourceIP restricts the rule to senders from the listed IPs and
+	// CIDRs. Webhooks the rule does not match only because of the sender
+	// are flagged with source_mismatch.
+	MatchSourceIP []string `yaml:"MatchSourceIP"`
+	// Priority orders rule evaluation, higher first. Rules with the same
+	// priority are evaluated in config order.
+	Priority int `yaml:"Priority"`
+	// Schema names a registered schema payloads must match, they are
+	// rejected with 422 otherwise. Events are tagged with the newest
+	// matching version.
+	Schema string `yaml:"Schema"`
+	// MatchGeo restricts the rule to senders from given countries or
+	// networks, requires GEOIP_DB or GEOIP_ASN_DB
+	MatchGeo *GeoCondition `yaml:"MatchGeo"`
+	Targets  []Target      `yaml:"Targets"`
+	// ActiveWindows limit forwarding to the time windows, events outside of
+	// them are stored but not forwarded
+	ActiveWindows []TimeWindow `yaml:"ActiveWindows"`
+	// Dedup acknowledges repeated webhooks with the same key within a
+	// window without storing or forwarding them again
+	Dedup *DedupConfig `yaml:"Dedup"`
+	// RateLimit limits how fast events are forwarded
+	RateLimit *RateLimit `yaml:"RateLimit"`
+	// SamplePercent forwards only the percentage of matching events, e.g.
+	// 5 to mirror a sample of production traffic to a test environment.
+	// All events are still stored. Defaults to 100.
+	SamplePercent *float64 `yaml:"SamplePercent"`
+	// Shadow targets receive a copy of every event whatever the Strategy,
+	// for testing new consumers. Their failures are ignored, they have no
+	// fallbacks and Sync does not wait for them.
+	Shadow []Target `yaml:"Shadow"`
+	// TargetGroups adds the targets of the named groups to Targets
+	TargetGroups []string `yaml:"TargetGroups"`
+	// Strategy is all (default, deliver to every target), roundrobin
+	// (deliver to one target, rotating between them), weighted (deliver
+	// to one target picked in proportion to the target weights) or canary
+	// (like weighted, but picked deterministically by the event key)
+	Strategy string `yaml:"Strategy"`
+	// TargetTimeout is the delivery timeout of targets without their own
+	TargetTimeout time.Duration `yaml:"TargetTimeout"`
+	// Retry is the retry policy of targets without their own, shadow
+	// targets are never retried
+	Retry *RetryConfig `yaml:"Retry"`
+	// CircuitBreaker is the circuit breaker of targets without their own
+	CircuitBreaker *CircuitBreakerConfig `yaml:"CircuitBreaker"`
+	// Processors transform the payload before it is sent to targets, each
+	// receives the event and its response becomes the new payload
+	Processors []Processor `yaml:"Processors"`
+	// Wasm lists WebAssembly plugins filtering and transforming the payload,
+	// run before processors
+	Wasm []string `yaml:"Wasm"`
+	// Experiment splits events between transformation variants, applied
+	// after Wasm and Processors
+	Experiment      *Experiment       `yaml:"Experiment"`
+	ResponseHeaders map[string]string `yaml:"ResponseHeaders"`
+	// PassHeaders lists incoming headers forwarded to the targets of the
+	// rule in addition to the global PassHeaders
+	PassHeaders []string `yaml:"PassHeaders"`
+	// Response customizes the status and body returned to the sender
+	Response *ResponseConfig `yaml:"Response"`
+	Replay   ReplayConfig    `yaml:"Replay"`
+	Verify   VerifyConfig    `yaml:"Verify"`
+	// DebugCapture captures full requests and responses of the next N
+	// deliveries to each target, see /api/debug/capture
+	DebugCapture int `yaml:"DebugCapture"`
+	// Drop discards matching webhooks without storing or forwarding them,
+	// responding 200 to the sender
+	Drop bool `yaml:"Drop"`
+	// Store set to false forwards webhooks without storing them, defaults
+	// to true
+	Store *bool `yaml:"Store"`
+	// Sync waits for deliveries before responding to the sender
+	Sync bool `yaml:"Sync"`
+	// OnStorageFailure is reject (respond 500), spool (accept and spool to
+	// disk) or forward (accept and forward without storing). Defaults to
+	// spool when SPOOL_DIR is set, reject otherwise.
+	OnStorageFailure string `yaml:"OnStorageFailure"`
+	// OnTargetFailure is accept (respond 200), reject (respond 502 when
+	// all deliveries failed) or report (respond with the result of each
+	// delivery, with 200 when all succeeded, 207 when some failed and 502
+	// when all failed). Reject and report apply to Sync only, defaults to
+	// accept.
+	OnTargetFailure string `yaml:"OnTargetFailure"`
+
+	wasmPlugins   []*wasm.Plugin
+	pathRegexp    *regexp.Regexp
+	notPathRegexp *regexp.Regexp
+	when          cel.Program
+	rotation      *atomic.Uint64
+	totalWeight   int
+	isDefault     bool
+	schema        []SchemaVersion
+	sourceNets    []*net.IPNet
+}
+
+// Error handling policies of dispatch rules
+const (
+	StorageFailureReject  = "reject"
+	StorageFailureSpool   = "spool"
+	StorageFailureForward = "forward"
+	TargetFailureAccept   = "accept"
+	TargetFailureReject   = "reject"
+	TargetFailureReport   = "report"
+	MethodMismatchIgnore  = "ignore"
+	MethodMismatchReject  = "reject"
+)
+
+// Rule matching modes
+const (
+	MatchFirst = "first"
+	MatchAll   = "all"
+)
+
+// loadConfig loads and parses the config file
+func loadConfig(path string) (*Config, error) {
+	config, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.prepare(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// readConfig reads the config file and the files it includes without
+// preparing the rules
+func readConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	if err := config.include(path, map[string]bool{}); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// prepare loads and compiles everything the rules reference
+func (c *Config) prepare() error {
+	policy, err := newHostPolicy(c.Outbound)
+	if err != nil {
+		return err
+	}
+	c.Outbound.policy = policy
+
+	if err := c.API.prepare(); err != nil {
+		return err
+	}
+	if err := c.Preview.prepare(); err != nil {
+		return err
+	}
+	if err := c.Slug.prepare(); err != nil {
+		return err
+	}
+	if err := c.Keys.prepare(); err != nil {
+		return err
+	}
+	if c.Notifications != nil {
+		if err := c.Notifications.prepare(c.Outbound); err != nil {
+			return err
+		}
+	}
+	if c.CORS != nil {
+		if err := c.CORS.prepare(); err != nil {
+			return err
+		}
+	}
+	if _, err := passHeaderNames(c.PassHeaders, nil); err != nil {
+		return fmt.Errorf("PassHeaders: %w", err)
+	}
+	for i := range c.Consumers {
+		if err := c.Consumers[i].prepare(); err != nil {
+			return err
+		}
+		if first, _ := c.consumer(c.Consumers[i].Name); first != &c.Consumers[i] {
+			return fmt.Errorf("consumer %s: duplicate Name", c.Consumers[i].Name)
+		}
+	}
+	switch c.Match {
+	case "", MatchFirst, MatchAll:
+	default:
+		return fmt.Errorf("unknown Match %q", c.Match)
+	}
+	if err := c.prepareSchemas(); err != nil {
+		return err
+	}
+	sort.SliceStable(c.Dispatch, func(i, j int) bool {
+		return c.Dispatch[i].Priority > c.Dispatch[j].Priority
+	})
+
+	names := map[string]bool{}
+	for i := range c.Dispatch {
+		if name := c.Dispatch[i].Name; name != "" {
+			if names[name] {
+				return fmt.Errorf("duplicate rule name %q", name)
+			}
+			names[name] = true
+		}
+		if err := c.prepareRule(&c.Dispatch[i]); err != nil {
+			return err
+		}
+	}
+	if c.Default != nil {
+		if c.Default.Path != "" || c.Default.PathRegex != "" {
+			return fmt.Errorf("rule default: Path and PathRegex are not allowed")
+		}
+		c.Default.Path = "/**"
+		c.Default.isDefault = true
+		if err := c.prepareRule(c.Default); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareRule validates and compiles a single rule
+func (c *Config) prepareRule(rule *DispatchRule) error {
+	switch {
+	case rule.Path != "" && rule.PathRegex != "":
+		return fmt.Errorf("rule %s: Path and PathRegex are mutually exclusive", rule.label())
+	case rule.PathRegex != "":
+		re, err := regexp.Compile(rule.PathRegex)
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid PathRegex: %w", rule.label(), err)
+		}
+		rule.pathRegexp = re
+	case hasPathParams(rule.Path):
+		re, err := compilePathParams(rule.Path)
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid path pattern: %w", rule.label(), err)
+		}
+		rule.pathRegexp = re
+	default:
+		if err := validateGlob(rule.Path); err != nil {
+			return fmt.Errorf("rule %s: invalid path pattern: %w", rule.label(), err)
+		}
+	}
+	if len(rule.MatchSourceIP) > 0 {
+		hosts, nets, err := parseHostEntries(rule.MatchSourceIP)
+		if err != nil {
+			return fmt.Errorf("rule %s: MatchSourceIP: %w", rule.label(), err)
+		}
+		if len(hosts) > 0 {
+			return fmt.Errorf("rule %s: MatchSourceIP accepts IPs and CIDRs only, got %q", rule.label(), hosts[0])
+		}
+		rule.sourceNets = nets
+	}
+	if rule.MatchBody != nil {
+		if err := rule.MatchBody.prepare(); err != nil {
+			return fmt.Errorf("rule %s: MatchBody: %w", rule.label(), err)
+		}
+	}
+	if rule.NotBody != nil {
+		if err := rule.NotBody.prepare(); err != nil {
+			return fmt.Errorf("rule %s: NotBody: %w", rule.label(), err)
+		}
+	}
+	if rule.NotPathRegex != "" {
+		re, err := regexp.Compile(rule.NotPathRegex)
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid NotPathRegex: %w", rule.label(), err)
+		}
+		rule.notPathRegexp = re
+	}
+	if rule.When != "" {
+		program, err := compileWhen(rule.When)
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid When: %w", rule.label(), err)
+		}
+		rule.when = program
+	}
+	if rule.Dedup != nil {
+		if err := rule.Dedup.prepare(); err != nil {
+			return fmt.Errorf("rule %s: Dedup: %w", rule.label(), err)
+		}
+	}
+	if rule.RateLimit != nil {
+		if err := rule.RateLimit.prepare(); err != nil {
+			return fmt.Errorf("rule %s: RateLimit: %w", rule.label(), err)
+		}
+	}
+	if err := rule.prepareSample(); err != nil {
+		return err
+	}
+	for j := range rule.ActiveWindows {
+		if err := rule.ActiveWindows[j].prepare(); err != nil {
+			return fmt.Errorf("rule %s: ActiveWindows: %w", rule.label(), err)
+		}
+	}
+	if rule.Schema != "" {
+		schema, ok := c.Schemas[rule.Schema]
+		if !ok {
+			return fmt.Errorf("rule %s: unknown schema %q", rule.label(), rule.Schema)
+		}
+		rule.schema = schema
+		c.needsBody = true
+	}
+	if rule.Response != nil {
+		if err := rule.Response.prepare(); err != nil {
+			return fmt.Errorf("rule %s: %w", rule.label(), err)
+		}
+	}
+	if err := rule.Verify.prepare(); err != nil {
+		return fmt.Errorf("rule %s: %w", rule.label(), err)
+	}
+	passHeaders, err := passHeaderNames(c.PassHeaders, rule.PassHeaders)
+	if err != nil {
+		return fmt.Errorf("rule %s: PassHeaders: %w", rule.label(), err)
+	}
+	for _, name := range rule.TargetGroups {
+		group, ok := c.TargetGroups[name]
+		if !ok {
+			return fmt.Errorf("rule %s: unknown target group %q", rule.label(), name)
+		}
+		for _, target := range group {
+			rule.Targets = append(rule.Targets, target.clone())
+		}
+	}
+	for j := range rule.Targets {
+		if rule.Targets[j].Timeout == 0 {
+			rule.Targets[j].Timeout = rule.TargetTimeout
+		}
+		if rule.Targets[j].Retry == nil && rule.Retry != nil {
+			retry := *rule.Retry
+			rule.Targets[j].Retry = &retry
+		}
+		if rule.Targets[j].ForwardMethod == "" {
+			rule.Targets[j].ForwardMethod = rule.ForwardMethod
+		}
+		if rule.Targets[j].CircuitBreaker == nil && rule.CircuitBreaker != nil {
+			breaker := *rule.CircuitBreaker
+			rule.Targets[j].CircuitBreaker = &breaker
+		}
+		if err := rule.Targets[j].prepare(c.Outbound); err != nil {
+			return fmt.Errorf("rule %s: %w", rule.label(), err)
+		}
+		rule.Targets[j].rule = rule.label()
+		rule.Targets[j].setPassHeaders(passHeaders)
+		if err := c.checkPullTargets(rule.Targets[j : j+1]); err != nil {
+			return fmt.Errorf("rule %s: %w", rule.label(), err)
+		}
+		if rule.DebugCapture > 0 {
+			debugCaptures.arm(rule.Targets[j].URL, rule.DebugCapture)
+		}
+	}
+	for j := range rule.Shadow {
+		shadow := &rule.Shadow[j]
+		if len(shadow.Fallback) > 0 {
+			return fmt.Errorf("rule %s: shadow target %s cannot have fallbacks", rule.label(), shadow.URL)
+		}
+		if shadow.Timeout == 0 {
+			shadow.Timeout = rule.TargetTimeout
+		}
+		if shadow.ForwardMethod == "" {
+			shadow.ForwardMethod = rule.ForwardMethod
+		}
+		if err := shadow.prepare(c.Outbound); err != nil {
+			return fmt.Errorf("rule %s: %w", rule.label(), err)
+		}
+		shadow.shadow = true
+		shadow.rule = rule.label()
+		shadow.setPassHeaders(passHeaders)
+		if err := c.checkPullTargets(rule.Shadow[j : j+1]); err != nil {
+			return fmt.Errorf("rule %s: %w", rule.label(), err)
+		}
+		if shadow.templated() {
+			c.needsBody = true
+		}
+	}
+	for j, method := range rule.Methods {
+		rule.Methods[j] = strings.ToUpper(method)
+	}
+	if err := rule.prepareStrategy(); err != nil {
+		return fmt.Errorf("rule %s: %w", rule.label(), err)
+	}
+	switch rule.OnMethodMismatch {
+	case "", MethodMismatchIgnore, MethodMismatchReject:
+	default:
+		return fmt.Errorf("rule %s: unknown OnMethodMismatch %q", rule.label(), rule.OnMethodMismatch)
+	}
+	switch rule.OnStorageFailure {
+	case "", StorageFailureReject, StorageFailureSpool, StorageFailureForward:
+	default:
+		return fmt.Errorf("rule %s: unknown OnStorageFailure %q", rule.label(), rule.OnStorageFailure)
+	}
+	switch rule.OnTargetFailure {
+	case "", TargetFailureAccept, TargetFailureReject, TargetFailureReport:
+	default:
+		return fmt.Errorf("rule %s: unknown OnTargetFailure %q", rule.label(), rule.OnTargetFailure)
+	}
+	if rule.OnTargetFailure == TargetFailureReport && rule.Response != nil {
+		return fmt.Errorf("rule %s: Response cannot be used with OnTargetFailure report", rule.label())
+	}
+	if err := prepareProcessors(rule.Processors, c.Outbound); err != nil {
+		return fmt.Errorf("rule %s: %w", rule.label(), err)
+	}
+	if rule.Experiment != nil {
+		if err := rule.Experiment.prepare(c.Outbound); err != nil {
+			return fmt.Errorf("rule %s: %w", rule.label(), err)
+		}
+	}
+	for _, path := range rule.Wasm {
+		plugin, err := wasm.Load(path)
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", rule.label(), err)
+		}
+		rule.wasmPlugins = append(rule.wasmPlugins, plugin)
+	}
+	if rule.MatchBody != nil || rule.NotBody != nil || rule.when != nil || rule.Dedup != nil && rule.Dedup.Field != "" {
+		c.needsBody = true
+	}
+	for j := range rule.Targets {
+		if rule.Targets[j].templated() {
+			c.needsBody = true
+		}
+	}
+	return nil
+}
+
+// stores reports whether webhooks matching the rule are stored, webhooks
+// matching no rule always are
+func (r *DispatchRule) stores() bool {
+	return r == nil || r.Store == nil || *r.Store
+}
+
+// storageFailurePolicy returns the effective OnStorageFailure policy
+func storageFailurePolicy(rule *DispatchRule) string {
+	if rule != nil && rule.OnStorageFailure != "" {
+		return rule.OnStorageFailure
+	}
+	if spool != nil {
+		return StorageFailureSpool
+	}
+	return StorageFailureReject
+}
+
+// setResponseHeaders applies global and rule-specific response headers,
+// rule headers taking precedence
+func setResponseHeaders(w http.ResponseWriter, rule *DispatchRule, config *Config) {
+	for name, value := range config.ResponseHeaders {
+		w.Header().Set(name, value)
+	}
+	if rule != nil {
+		for name, value := range rule.ResponseHeaders {
+			w.Header().Set(name, value)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig allows browser-based senders to post webhooks from other
+// origins
+type CORSConfig struct {
+	// AllowOrigins lists origins like https://app.example.com allowed to
+	// send webhooks, * allows any origin
+	AllowOrigins []string `yaml:"AllowOrigins"`
+	// AllowHeaders lists request headers senders may use in addition to
+	// Content-Type and the verification header of the rule
+	AllowHeaders []string `yaml:"AllowHeaders"`
+	// MaxAge is how long browsers may cache a preflight response, defaults
+	// to 10m
+	MaxAge time.Duration `yaml:"MaxAge"`
+}
+
+// probeMethods are answered by the dispatcher instead of being ingested,
+// unless a rule lists them in its Methods
+var probeMethods = []string{http.MethodHead, http.MethodOptions}
+
+// defaultAllow is the Allow header of rules accepting any method
+const defaultAllow = "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
+
+// prepare validates the origins and sets defaults
+func (c *CORSConfig) prepare() error {
+	if len(c.AllowOrigins) == 0 {
+		return fmt.Errorf("CORS: AllowOrigins is required")
+	}
+	for _, origin := range c.AllowOrigins {
+		if origin != "*" && !strings.Contains(origin, "://") {
+			return fmt.Errorf("CORS: invalid origin %q, expected scheme://host", origin)
+		}
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("CORS: MaxAge must not be negative")
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = 10 * time.Minute
+	}
+	return nil
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for the origin,
+// or false if it is not allowed
+func (c *CORSConfig) allowOrigin(origin string) (string, bool) {
+	if c == nil || origin == "" {
+		return "", false
+	}
+	for _, allowed := range c.AllowOrigins {
+		if allowed == "*" {
+			return "*", true
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// setCORSHeaders allows the origin of the request to read the response
+func setCORSHeaders(w http.ResponseWriter, r *http.Request, config *Config) {
+	w.Header().Add("Vary", "Origin")
+	if allow, ok := config.CORS.allowOrigin(r.Header.Get("Origin")); ok {
+		w.Header().Set("Access-Control-Allow-Origin", allow)
+	}
+}
+
+// isProbe reports whether the request is a HEAD or OPTIONS request the
+// dispatcher answers itself. Rules listing the method in Methods ingest
+// them as webhooks.
+func isProbe(r *http.Request, rule *DispatchRule) bool {
+	if !slices.Contains(probeMethods, r.Method) {
+		return false
+	}
+	return rule == nil || !slices.Contains(rule.Methods, r.Method)
+}
+
+// probeMethod is the method rules are matched with for a probe, the method
+// announced by a CORS preflight or POST
+func probeMethod(r *http.Request) string {
+	if r.Method == http.MethodOptions {
+		if method := r.Header.Get("Access-Control-Request-Method"); method != "" {
+			return strings.ToUpper(method)
+		}
+	}
+	return http.MethodPost
+}
+
+// allowHeader returns the Allow header for the rule
+func allowHeader(rule *DispatchRule) string {
+	if rule == nil || len(rule.Methods) == 0 {
+		return defaultAllow
+	}
+	methods := slices.Clone(rule.Methods)
+	for _, method := range probeMethods {
+		if !slices.Contains(methods, method) {
+			methods = append(methods, method)
+		}
+	}
+	return strings.Join(methods, ", ")
+}
+
+// handleProbe answers HEAD with an empty 200 and OPTIONS with the allowed
+// methods, including CORS preflight requests from allowed origins. Probes
+// are neither stored nor forwarded.
+func handleProbe(w http.ResponseWriter, r *http.Request, rule *DispatchRule, config *Config) {
+	w.Header().Set("Allow", allowHeader(rule))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	allow, ok := config.CORS.allowOrigin(r.Header.Get("Origin"))
+	if method == "" || !ok || (rule != nil && !rule.allowsMethod(probeMethod(r))) {
+		// Not a preflight, or one the browser is to reject
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	headers := []string{"Content-Type"}
+	if rule != nil && rule.Verify.enabled() {
+		headers = append(headers, rule.Verify.Header)
+	}
+	headers = append(headers, config.CORS.AllowHeaders...)
+
+	w.Header().Set("Access-Control-Allow-Origin", allow)
+	w.Header().Set("Access-Control-Allow-Methods", w.Header().Get("Allow"))
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(config.CORS.MaxAge.Seconds())))
+	w.WriteHeader(http.StatusNoContent)
+}
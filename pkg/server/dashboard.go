@@ -0,0 +1,133 @@
+package server
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+)
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta http-equiv="refresh" content="5">
+    <title>Webhook Dispatcher Dashboard</title>
+    <style>
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, "Helvetica Neue", Arial, sans-serif;
+            max-width: 1000px;
+            margin: 50px auto;
+            padding: 20px;
+            line-height: 1.6;
+            color: #333;
+        }
+        h1, h2 {
+            color: #2c3e50;
+        }
+        .cards {
+            display: flex;
+            gap: 16px;
+        }
+        .card {
+            flex: 1;
+            border: 1px solid #ddd;
+            border-radius: 4px;
+            padding: 12px;
+        }
+        .card .value {
+            font-size: 1.8em;
+            font-weight: bold;
+        }
+        table {
+            width: 100%;
+            border-collapse: collapse;
+        }
+        th, td {
+            text-align: left;
+            padding: 6px;
+            border-bottom: 1px solid #eee;
+        }
+        .ok {
+            color: #155724;
+        }
+        .fail {
+            color: #721c24;
+        }
+    </style>
+</head>
+<body>
+    <h1>Webhook Dispatcher Dashboard</h1>
+    <div class="cards">
+        <div class="card"><div>Received</div><div class="value">{{ .Activity.Received }}</div></div>
+        <div class="card"><div>In flight</div><div class="value">{{ .Activity.InFlight }}</div></div>
+        <div class="card"><div>Redis events</div><div class="value">{{ if lt .RedisCount 0 }}-{{ else }}{{ .RedisCount }}{{ end }}</div></div>
+        <div class="card"><div>MongoDB events</div><div class="value">{{ if lt .MongoDBCount 0 }}-{{ else }}{{ .MongoDBCount }}{{ end }}</div></div>
+    </div>
+
+    <h2>Delivery queue</h2>
+    <div class="cards">
+        <div class="card"><div>Waiting</div><div class="value">{{ .Queue.Depth }}</div></div>
+        <div class="card"><div>Oldest waiting</div><div class="value">{{ printf "%.0f" .Queue.OldestAge }} s</div></div>
+        <div class="card"><div>Busy workers</div><div class="value">{{ .Queue.Busy }}{{ if gt .Queue.Workers 0 }} / {{ .Queue.Workers }}{{ end }}</div></div>
+        <div class="card"><div>State</div><div class="value">{{ if .Queue.Draining }}<span class="fail">draining</span>{{ else }}<span class="ok">running</span>{{ end }}</div></div>
+    </div>
+
+    <h2>Targets</h2>
+    <table>
+        <tr><th>URL</th><th>Status</th><th>Successes</th><th>Failures</th><th>Last attempt</th></tr>
+        {{ range .Activity.Targets }}
+        <tr>
+            <td>{{ .URL }}</td>
+            <td>{{ if .Healthy }}<span class="ok">{{ .LastStatus }}</span>{{ else }}<span class="fail">{{ if .LastError }}{{ .LastError }}{{ else }}{{ .LastStatus }}{{ end }}</span>{{ end }}</td>
+            <td>{{ .Successes }}</td>
+            <td>{{ .Failures }}</td>
+            <td>{{ .LastAttempt.Format "2006-01-02 15:04:05" }}</td>
+        </tr>
+        {{ else }}
+        <tr><td colspan="5">No deliveries yet</td></tr>
+        {{ end }}
+    </table>
+
+    <h2>Recent events</h2>
+    <table>
+        <tr><th>Key</th><th>Path</th><th>Size</th><th>Received</th></tr>
+        {{ range .Activity.Recent }}
+        <tr>
+            <td>{{ .Key }}</td>
+            <td>{{ .Path }}</td>
+            <td>{{ .Size }} B</td>
+            <td>{{ .Time.Format "2006-01-02 15:04:05" }}</td>
+        </tr>
+        {{ else }}
+        <tr><td colspan="4">No events yet</td></tr>
+        {{ end }}
+    </table>
+
+    <p>Up since {{ .Since.Format "2006-01-02 15:04:05" }}, refreshes every 5 seconds.</p>
+</body>
+</html>`))
+
+// handleDashboard serves the live pipeline overview page, including the
+// delivery queue
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Activity     ActivitySnapshot
+		Queue        QueueStats
+		RedisCount   int64
+		MongoDBCount int64
+		Since        time.Time
+	}{
+		Activity:     activity.snapshot(),
+		Queue:        deliveries.snapshot(),
+		RedisCount:   redisEventCount.Load(),
+		MongoDBCount: mongodbEventCount.Load(),
+		Since:        startTime,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		log.Printf("Failed to render dashboard: %v", err)
+	}
+}
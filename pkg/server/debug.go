@@ -0,0 +1,129 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxCaptureBody limits captured request and response bodies
+const maxCaptureBody = 64 * 1024
+
+// maxCapturesPerTarget limits captures kept per target
+const maxCapturesPerTarget = 50
+
+// DeliveryCapture is a full record of a single delivery
+type DeliveryCapture struct {
+	Time            time.Time   `json:"time"`
+	Duration        string      `json:"duration"`
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	RequestHeaders  http.Header `json:"request_headers"`
+	RequestBody     string      `json:"request_body"`
+	Status          int         `json:"status,omitempty"`
+	ResponseHeaders http.Header `json:"response_headers,omitempty"`
+	ResponseBody    string      `json:"response_body,omitempty"`
+	Error           string      `json:"error,omitempty"`
+}
+
+// debugCapture records deliveries to targets armed for debugging
+type debugCapture struct {
+	mu       sync.Mutex
+	armed    map[string]int
+	captures map[string][]DeliveryCapture
+}
+
+var debugCaptures = &debugCapture{
+	armed:    map[string]int{},
+	captures: map[string][]DeliveryCapture{},
+}
+
+// arm enables capturing of the next n deliveries to a target
+func (d *debugCapture) arm(target string, n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.armed[target] = n
+}
+
+// take reports whether the next delivery to a target should be captured
+func (d *debugCapture) take(target string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.armed[target] <= 0 {
+		return false
+	}
+	d.armed[target]--
+	return true
+}
+
+// record stores a capture
+func (d *debugCapture) record(target string, c DeliveryCapture) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	captures := append(d.captures[target], c)
+	if len(captures) > maxCapturesPerTarget {
+		captures = captures[len(captures)-maxCapturesPerTarget:]
+	}
+	d.captures[target] = captures
+}
+
+// get returns the captures and remaining armed count for a target
+func (d *debugCapture) get(target string) ([]DeliveryCapture, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]DeliveryCapture{}, d.captures[target]...), d.armed[target]
+}
+
+// clear removes captures of a target and disarms it
+func (d *debugCapture) clear(target string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.captures, target)
+	delete(d.armed, target)
+}
+
+// truncateCapture limits captured bodies to maxCaptureBody
+func truncateCapture(body []byte) string {
+	if len(body) > maxCaptureBody {
+		return string(body[:maxCaptureBody]) + "...(truncated)"
+	}
+	return string(body)
+}
+
+// handleDebugCapture arms (POST), lists (GET) or clears (DELETE) delivery
+// captures of a target
+func handleDebugCapture(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeBadRequest, "Target is required")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		count := 10
+		if v := r.URL.Query().Get("count"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeError(w, http.StatusBadRequest, ErrCodeBadRequest, "Invalid count")
+				return
+			}
+			count = n
+		}
+		debugCaptures.arm(target, count)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"target": target, "remaining": count})
+	case http.MethodGet:
+		captures, remaining := debugCaptures.get(target)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"target":    target,
+			"remaining": remaining,
+			"captures":  captures,
+		})
+	case http.MethodDelete:
+		debugCaptures.clear(target)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+	}
+}
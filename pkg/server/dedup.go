@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sikalabs/webhook-dispatcher/pkg/jsonpath"
+)
+
+var duplicatesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "webhook_dispatcher_duplicates_total",
+	Help: "Number of webhooks acknowledged but not forwarded as duplicates",
+}, []string{"rule"})
+
+func init() {
+	prometheus.MustRegister(duplicatesCounter)
+}
+
+// DedupConfig identifies repeated deliveries of the same webhook, e.g. by
+// the X-GitHub-Delivery header. Webhooks without the key are not
+// deduplicated.
+type DedupConfig struct {
+	// Header holding the dedup key
+	Header string `yaml:"Header"`
+	// Field is a JSONPath expression of the dedup key in the payload, used
+	// when Header is not set
+	Field string `yaml:"Field"`
+	// Window is how long a key is remembered, defaults to 1h
+	Window time.Duration `yaml:"Window"`
+
+	field *jsonpath.Path
+}
+
+// prepare validates the key source and compiles the field path
+func (d *DedupConfig) prepare() error {
+	if (d.Header == "") == (d.Field == "") {
+		return fmt.Errorf("exactly one of Header and Field is required")
+	}
+	if d.Field != "" {
+		path, err := jsonpath.Compile(d.Field)
+		if err != nil {
+			return fmt.Errorf("invalid Field: %w", err)
+		}
+		d.field = path
+	}
+	if d.Window < 0 {
+		return fmt.Errorf("invalid Window %s", d.Window)
+	}
+	if d.Window == 0 {
+		d.Window = time.Hour
+	}
+	return nil
+}
+
+// key returns the dedup key of a webhook, empty if it has none
+func (d *DedupConfig) key(in ruleInput) string {
+	if d.Header != "" {
+		return in.Headers.Get(d.Header)
+	}
+	if !in.HasBody {
+		return ""
+	}
+	value, ok := d.field.Get(in.Body)
+	if !ok || value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// dedupCache remembers dedup keys for instances without Redis
+type dedupCache struct {
+	mu        sync.Mutex
+	expires   map[string]time.Time
+	lastSweep time.Time
+}
+
+var dedupKeys = &dedupCache{expires: map[string]time.Time{}}
+
+// claim records the key for the window, reporting whether it was new
+func (c *dedupCache) claim(key string, window time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) > time.Minute {
+		for k, expires := range c.expires {
+			if now.After(expires) {
+				delete(c.expires, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	if expires, ok := c.expires[key]; ok && now.Before(expires) {
+		return false
+	}
+	c.expires[key] = now.Add(window)
+	return true
+}
+
+// release forgets the key
+func (c *dedupCache) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.expires, key)
+}
+
+// claimDedup claims the dedup key of the webhook for the rule's window,
+// reporting false if the rule has seen it within the window. Keys are
+// shared through Redis when it is connected, so all instances deduplicate
+// together. Keys are kept in memory otherwise. The returned function
+// releases the key, for webhooks rejected after the claim, so the retry of
+// the sender is not ignored as a duplicate.
+func (r *DispatchRule) claimDedup(ctx context.Context, in ruleInput) (func(), bool) {
+	if r == nil || r.Dedup == nil {
+		return func() {}, true
+	}
+	value := r.Dedup.key(in)
+	if value == "" {
+		return func() {}, true
+	}
+	key := "dedup:" + r.label() + ":" + value
+
+	if redis := connectedRedis.Load(); redis != nil {
+		claimCtx, cancel := context.WithTimeout(ctx, storageTimeout)
+		defer cancel()
+		claimed, err := redis.Claim(claimCtx, key, r.Dedup.Window)
+		if err == nil {
+			return func() {
+				ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+				defer cancel()
+				if err := redis.Release(ctx, key); err != nil {
+					log.Printf("Failed to release dedup key %s: %v", key, err)
+				}
+			}, claimed
+		}
+		log.Printf("Failed to check dedup key in Redis, using memory: %v", err)
+	}
+	return func() { dedupKeys.release(key) }, dedupKeys.claim(key, r.Dedup.Window)
+}
+
+// writeDuplicate acknowledges a webhook which is not forwarded again
+func writeDuplicate(w http.ResponseWriter, r *http.Request, rule *DispatchRule) {
+	duplicatesCounter.WithLabelValues(rule.label()).Inc()
+	log.Printf("Duplicate webhook for %s ignored by rule %s", r.URL.Path, rule.label())
+	if rule.Response != nil {
+		writeAccepted(w, rule, responseData{Path: r.URL.Path})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Duplicate webhook ignored")
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/manifest"
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+)
+
+// trackedDeliveries buffers delivery attempts for the tracker, nil when
+// tracking is disabled
+var trackedDeliveries chan storage.Delivery
+
+// setupDeliveryTracking records delivery attempts in the storage backend
+// unless it does not support it or DELIVERY_TRACKING=0. Attempts are
+// written in the background and dropped when the backend falls behind.
+func setupDeliveryTracking(store storage.Storage) {
+	tracker, ok := store.(storage.DeliveryTracker)
+	if !ok || os.Getenv("DELIVERY_TRACKING") == "0" {
+		return
+	}
+	trackedDeliveries = make(chan storage.Delivery, 1024)
+	go func() {
+		for delivery := range trackedDeliveries {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := tracker.RecordDelivery(ctx, delivery); err != nil {
+				log.Printf("Failed to record delivery of %s to %s: %v", delivery.Key, delivery.URL, err)
+			}
+			cancel()
+		}
+	}()
+	log.Printf("Delivery tracking enabled")
+}
+
+// trackDelivery records a delivery attempt of an event started at the
+// time with its signed manifest, if any. Deliveries of payloads which are
+// not events, like notifications, are not tracked.
+func trackDelivery(target Target, body payload, result DeliveryResult, start time.Time, m *manifest.Manifest) {
+	if trackedDeliveries == nil || body.key == "" {
+		return
+	}
+	delivery := storage.Delivery{
+		Key:      body.key,
+		Time:     start,
+		Target:   target.label(),
+		URL:      result.URL,
+		Status:   result.Status,
+		Error:    result.Error,
+		Duration: float64(time.Since(start).Microseconds()) / 1000,
+		Manifest: m,
+	}
+	select {
+	case trackedDeliveries <- delivery:
+	default:
+		log.Printf("Delivery tracking is falling behind, not recording delivery of %s to %s", body.key, result.URL)
+	}
+}
+
+// handleDeliveries returns the delivery attempts of an event, limited to
+// a target by its URL or label with target
+func handleDeliveries(w http.ResponseWriter, r *http.Request, store storage.Storage) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+	tracker, ok := store.(storage.DeliveryTracker)
+	if !ok || trackedDeliveries == nil {
+		writeStorageError(w, storage.ErrNotSupported, "delivery tracking")
+		return
+	}
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeBadRequest, "Missing key parameter")
+		return
+	}
+	target := r.URL.Query().Get("target")
+
+	all, err := tracker.Deliveries(r.Context(), key)
+	if err != nil {
+		writeStorageError(w, err, "listing deliveries")
+		return
+	}
+	deliveries := []storage.Delivery{}
+	delivered := false
+	for _, delivery := range all {
+		if target != "" && delivery.Target != target && delivery.URL != target {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+		delivered = delivered || delivery.OK()
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":        key,
+		"count":      len(deliveries),
+		"delivered":  delivered,
+		"deliveries": deliveries,
+	})
+}
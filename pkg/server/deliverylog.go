@@ -0,0 +1,42 @@
+package server
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/deliverylog"
+)
+
+// deliveryLog records delivery attempts for reports, nil unless
+// DELIVERY_LOG is set
+var deliveryLog *deliverylog.Log
+
+// openDeliveryLog opens the delivery log at DELIVERY_LOG
+func openDeliveryLog() (*deliverylog.Log, error) {
+	path := os.Getenv("DELIVERY_LOG")
+	if path == "" {
+		return nil, nil
+	}
+	return deliverylog.Open(path)
+}
+
+// recordDelivery records a delivery attempt started at the time in the
+// delivery log
+func recordDelivery(target Target, body payload, result DeliveryResult, start time.Time) {
+	if deliveryLog == nil {
+		return
+	}
+	err := deliveryLog.Append(deliverylog.Record{
+		Time:     start,
+		Key:      body.key,
+		Path:     body.path,
+		Target:   target.label(),
+		Status:   result.Status,
+		Error:    result.Error,
+		Duration: float64(time.Since(start).Microseconds()) / 1000,
+	})
+	if err != nil {
+		log.Printf("Failed to record delivery to %s: %v", target.URL, err)
+	}
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+)
+
+// deliveryStreamGroup is the consumer group of all dispatcher instances
+const deliveryStreamGroup = "webhook-dispatcher"
+
+var streamJobsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "webhook_dispatcher_delivery_stream_jobs_total",
+	Help: "Number of delivery stream jobs by event (queued, delivered, claimed, dropped)",
+}, []string{"event"})
+
+func init() {
+	prometheus.MustRegister(streamJobsCounter)
+}
+
+// deliveryStreamQueue delivers through a Redis Stream instead of
+// goroutines, so deliveries survive restarts. Jobs are read by a worker
+// pool and acknowledged once delivered, retried or dead-lettered.
+type deliveryStreamQueue struct {
+	name     string
+	consumer string
+	workers  int
+	// claimIdle is how long a job read by another consumer stays
+	// unacknowledged before it is claimed, it must exceed the longest
+	// delivery including retries
+	claimIdle time.Duration
+
+	mu    sync.Mutex
+	ready bool
+	// inflight holds the IDs of jobs being delivered, so claiming does not
+	// deliver them twice
+	inflight sync.Map
+	jobs     chan storage.DeliveryJob
+}
+
+// deliveryStream is nil unless DELIVERY_STREAM is set
+var deliveryStream *deliveryStreamQueue
+
+// startDeliveryStream starts the delivery stream workers if
+// DELIVERY_STREAM names the stream. DELIVERY_STREAM_WORKERS (default 16)
+// sets the number of workers and DELIVERY_STREAM_CLAIM_IDLE (default 10m)
+// when jobs of stopped instances are taken over. Instances are consumers
+// named after the hostname and deliver their own unacknowledged jobs
+// first when they restart.
+func startDeliveryStream() {
+	name := os.Getenv("DELIVERY_STREAM")
+	if name == "" {
+		return
+	}
+	workers := 16
+	if v := os.Getenv("DELIVERY_STREAM_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Printf("Warning: Invalid DELIVERY_STREAM_WORKERS %q, using %d", v, workers)
+		} else {
+			workers = n
+		}
+	}
+	consumer, _ := os.Hostname()
+	deliveryStream = &deliveryStreamQueue{
+		name:      name,
+		consumer:  consumer,
+		workers:   workers,
+		claimIdle: durationFromEnv("DELIVERY_STREAM_CLAIM_IDLE", 10*time.Minute),
+		jobs:      make(chan storage.DeliveryJob),
+	}
+	for range workers {
+		go deliveryStream.work()
+	}
+	go deliveryStream.run()
+	log.Printf("Queueing deliveries in Redis stream %s (consumer: %s, workers: %d)", name, consumer, workers)
+}
+
+// stream returns the Redis stream, nil while Redis is not connected
+func (q *deliveryStreamQueue) stream() *storage.RedisStream {
+	redis := connectedRedis.Load()
+	if redis == nil {
+		return nil
+	}
+	stream := redis.Stream(q.name, deliveryStreamGroup, q.consumer)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.ready {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := stream.Setup(ctx); err != nil {
+			log.Printf("Failed to set up delivery stream %s: %v", q.name, err)
+			return nil
+		}
+		q.ready = true
+	}
+	return stream
+}
+
+// enqueue adds jobs for the targets to the stream and returns the targets
+// to deliver directly: all of them while Redis is not connected, payloads
+// spilled to disk and targets not belonging to a rule, and those left when
+// adding a job fails
+func (q *deliveryStreamQueue) enqueue(targets []Target, body payload, headers http.Header) []Target {
+	if q == nil || body.spilled() {
+		return targets
+	}
+	stream := q.stream()
+	if stream == nil {
+		return targets
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var direct []Target
+	for i, target := range targets {
+		if target.rule == "" {
+			direct = append(direct, target)
+			continue
+		}
+		job := storage.DeliveryJob{
+			Time:        time.Now(),
+			Key:         body.key,
+			Path:        body.path,
+			Rule:        target.rule,
+			Target:      target.URL,
+			TargetLabel: target.label(),
+			Body:        string(body.data),
+			Headers:     keepHeaders(target, headers),
+		}
+		if err := stream.Add(ctx, job); err != nil {
+			log.Printf("Failed to queue delivery to %s, delivering directly: %v", target.URL, err)
+			return append(direct, targets[i:]...)
+		}
+		streamJobsCounter.WithLabelValues("queued").Inc()
+	}
+	return direct
+}
+
+// run feeds the workers with the unacknowledged jobs of the consumer, new
+// jobs and jobs claimed from stopped consumers until the server drains
+func (q *deliveryStreamQueue) run() {
+	defer close(q.jobs)
+	var stream *storage.RedisStream
+	for stream == nil {
+		if stream = q.stream(); stream == nil {
+			time.Sleep(time.Second)
+		}
+	}
+
+	ctx := context.Background()
+	for after := "0"; ; {
+		jobs, err := stream.Pending(ctx, after, 100)
+		if err != nil {
+			log.Printf("Failed to recover pending deliveries from stream %s (consumer: %s): %v", q.name, q.consumer, err)
+			break
+		}
+		if len(jobs) == 0 {
+			break
+		}
+		log.Printf("Recovering %d pending deliveries from stream %s", len(jobs), q.name)
+		q.feed(jobs)
+		after = jobs[len(jobs)-1].ID
+	}
+
+	lastClaim := time.Now()
+	for !deliveries.draining.Load() {
+		if time.Since(lastClaim) > q.claimIdle/2 {
+			lastClaim = time.Now()
+			jobs, err := stream.Claim(ctx, q.claimIdle, 100)
+			if err != nil {
+				log.Printf("Failed to claim deliveries from stream %s (consumer: %s): %v", q.name, q.consumer, err)
+			}
+			if len(jobs) > 0 {
+				log.Printf("Claimed %d deliveries idle for %s", len(jobs), q.claimIdle)
+				streamJobsCounter.WithLabelValues("claimed").Add(float64(len(jobs)))
+			}
+			q.feed(jobs)
+		}
+
+		jobs, err := stream.Read(ctx, int64(q.workers), 2*time.Second)
+		if err != nil {
+			log.Printf("Failed to read delivery stream %s (consumer: %s): %v", q.name, q.consumer, err)
+			time.Sleep(time.Second)
+			continue
+		}
+		q.feed(jobs)
+	}
+}
+
+// feed hands jobs not already in progress to the workers
+func (q *deliveryStreamQueue) feed(jobs []storage.DeliveryJob) {
+	for _, job := range jobs {
+		if _, busy := q.inflight.LoadOrStore(job.ID, true); !busy {
+			q.jobs <- job
+		}
+	}
+}
+
+// work delivers jobs until the stream stops
+func (q *deliveryStreamQueue) work() {
+	for job := range q.jobs {
+		q.deliver(job)
+	}
+}
+
+// deliver delivers a job to its target and acknowledges it. Jobs for
+// targets no longer configured are dropped.
+func (q *deliveryStreamQueue) deliver(job storage.DeliveryJob) {
+	defer recoverGoroutine("delivery stream job " + job.ID)
+	defer q.inflight.Delete(job.ID)
+
+	target, ok := findTarget(liveConfig.Load(), job.Rule, job.TargetLabel, job.Target)
+	if ok {
+		body := memoryPayload([]byte(job.Body))
+		body.key, body.path = job.Key, job.Path
+		activity.deliveryStarted()
+		deliverQueued(context.Background(), target, body, http.Header(job.Headers))
+		activity.deliveryFinished()
+		streamJobsCounter.WithLabelValues("delivered").Inc()
+	} else {
+		log.Printf("Dropping queued delivery of %s, target %s of rule %s is no longer configured", job.Key, job.TargetLabel, job.Rule)
+		streamJobsCounter.WithLabelValues("dropped").Inc()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if stream := q.stream(); stream == nil {
+		log.Printf("Failed to acknowledge delivery %s: Redis is not connected", job.ID)
+	} else if err := stream.Ack(ctx, job.ID); err != nil {
+		log.Printf("Failed to acknowledge delivery %s: %v", job.ID, err)
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Digest defaults
+const (
+	defaultDigestPeriod     = 24 * time.Hour
+	defaultDigestSampleSize = 3
+	// maxDigestKeys bounds the unique keys remembered per digest, beyond it
+	// the unique count is a lower bound
+	maxDigestKeys = 10000
+)
+
+// DigestConfig turns a target into a digest target, which receives a
+// single summary of the events of each path once per period instead of
+// every event
+type DigestConfig struct {
+	// Period between digests, defaults to 24h. Periods are aligned to
+	// multiples of it since the Unix epoch, so 24h digests are sent at
+	// midnight UTC.
+	Period time.Duration `yaml:"Period"`
+	// SampleSize is the number of payloads included in a digest, defaults
+	// to 3
+	SampleSize *int `yaml:"SampleSize"`
+}
+
+// Digest is the summary sent to a digest target
+type Digest struct {
+	Path  string    `json:"path"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Count int       `json:"count"`
+	// UniqueKeys is the number of distinct event keys
+	UniqueKeys int `json:"unique_keys"`
+	// Sample holds the first payloads of the period
+	Sample []json.RawMessage `json:"sample"`
+}
+
+// prepare validates the digest and applies defaults
+func (d *DigestConfig) prepare() error {
+	if d.Period == 0 {
+		d.Period = defaultDigestPeriod
+	}
+	if d.Period < time.Minute {
+		return fmt.Errorf("invalid digest period %s, must be at least 1m", d.Period)
+	}
+	if d.SampleSize == nil {
+		size := defaultDigestSampleSize
+		d.SampleSize = &size
+	}
+	if *d.SampleSize < 0 {
+		return fmt.Errorf("invalid digest sample size %d", *d.SampleSize)
+	}
+	return nil
+}
+
+// pendingDigest accumulates the events of a path for a digest target
+type pendingDigest struct {
+	target  Target
+	headers http.Header
+	digest  Digest
+	keys    map[string]struct{}
+}
+
+var (
+	digestsMu sync.Mutex
+	digests   = map[string]*pendingDigest{}
+)
+
+// addToDigest adds the event to the pending digest of the target and the
+// event path, scheduling the digest at the end of the period
+func addToDigest(target Target, body payload, headers http.Header) DeliveryResult {
+	id := target.label() + "\x00" + body.path
+
+	digestsMu.Lock()
+	defer digestsMu.Unlock()
+	pending, ok := digests[id]
+	if !ok {
+		now := time.Now()
+		start := now.Truncate(target.Digest.Period)
+		end := start.Add(target.Digest.Period)
+		pending = &pendingDigest{
+			headers: http.Header{"Content-Type": {"application/json"}},
+			digest:  Digest{Path: body.path, Start: start, End: end, Sample: []json.RawMessage{}},
+			keys:    map[string]struct{}{},
+		}
+		// The digest itself is delivered like a regular target
+		pending.target = target
+		pending.target.Digest = nil
+		if v := headers.Get("Traceparent"); v != "" {
+			pending.headers.Set("Traceparent", v)
+		}
+		digests[id] = pending
+		time.AfterFunc(end.Sub(now), func() {
+			activity.deliveryStarted()
+			sendDigest(id)
+		})
+	}
+
+	pending.digest.Count++
+	if _, ok := pending.keys[body.key]; !ok && len(pending.keys) < maxDigestKeys {
+		pending.keys[body.key] = struct{}{}
+	}
+	if len(pending.digest.Sample) < *target.Digest.SampleSize && !body.spilled() {
+		sample := json.RawMessage(body.data)
+		if !json.Valid(body.data) {
+			sample, _ = json.Marshal(string(body.data))
+		}
+		pending.digest.Sample = append(pending.digest.Sample, sample)
+	}
+	return DeliveryResult{URL: target.URL, Status: http.StatusAccepted}
+}
+
+// sendDigest delivers the pending digest, if any. The caller has started
+// the activity of the delivery.
+func sendDigest(id string) {
+	defer activity.deliveryFinished()
+	defer recoverGoroutine("digest")
+
+	digestsMu.Lock()
+	pending, ok := digests[id]
+	delete(digests, id)
+	digestsMu.Unlock()
+	if !ok {
+		return
+	}
+
+	pending.digest.UniqueKeys = len(pending.keys)
+	body, err := json.Marshal(pending.digest)
+	if err != nil {
+		log.Printf("Failed to encode digest for %s: %v", pending.target.URL, err)
+		return
+	}
+	payload := memoryPayload(body)
+	payload.path = pending.digest.Path
+	result := deliverQueued(context.Background(), pending.target, payload, pending.headers)
+	log.Printf("Sent digest of %d events of %s to %s (status: %d)", pending.digest.Count, pending.digest.Path, pending.target.URL, result.Status)
+}
+
+// flushDigests starts sending all pending digests early, on shutdown
+func flushDigests() {
+	digestsMu.Lock()
+	defer digestsMu.Unlock()
+	for id := range digests {
+		activity.deliveryStarted()
+		go sendDigest(id)
+	}
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+)
+
+var deadLettersCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "webhook_dispatcher_dead_letters_total",
+	Help: "Number of deliveries moved to the dead letter queue by rule",
+}, []string{"rule"})
+
+func init() {
+	prometheus.MustRegister(deadLettersCounter)
+}
+
+// deadLetters keeps deliveries which failed after all retries and
+// fallbacks, nil when the storage backend has no dead letter queue or
+// DEAD_LETTER_QUEUE=0
+var deadLetters storage.DeadLetterQueue
+
+// setupDeadLetters enables the dead letter queue if the backend supports it
+func setupDeadLetters(store storage.Storage) {
+	queue, ok := store.(storage.DeadLetterQueue)
+	if !ok || os.Getenv("DEAD_LETTER_QUEUE") == "0" {
+		return
+	}
+	deadLetters = queue
+	log.Printf("Dead letter queue enabled")
+}
+
+// keptHeaders are the forwarded headers kept with dead letters and queued
+// deliveries, besides path parameters
+var keptHeaders = []string{"Content-Type", "Traceparent", "Tracestate", methodHeader}
+
+// keepHeaders returns the headers of a delivery to the target worth
+// keeping for a later delivery
+func keepHeaders(target Target, headers http.Header) map[string][]string {
+	kept := map[string][]string{}
+	for name, values := range headers {
+		if strings.HasPrefix(name, paramHeaderPrefix) {
+			kept[name] = values
+		}
+	}
+	for _, name := range slices.Concat(keptHeaders, target.passHeaders) {
+		if values := headers.Values(name); len(values) > 0 {
+			kept[name] = values
+		}
+	}
+	return kept
+}
+
+// pushDeadLetter moves a failed delivery to the dead letter queue. Shadow
+// targets are not dead-lettered.
+func pushDeadLetter(target Target, body payload, headers http.Header, result DeliveryResult) {
+	if deadLetters == nil || target.shadow {
+		return
+	}
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		log.Printf("Failed to dead-letter delivery to %s: %v", target.URL, err)
+		return
+	}
+
+	letter := storage.DeadLetter{
+		ID:          hex.EncodeToString(id),
+		Time:        time.Now(),
+		Key:         body.key,
+		Path:        body.path,
+		Rule:        target.rule,
+		Target:      target.URL,
+		TargetLabel: target.label(),
+		Status:      result.Status,
+		Error:       result.Error,
+		Headers:     keepHeaders(target, headers),
+	}
+	// Payloads spilled to disk are replayed from the stored event
+	if !body.spilled() {
+		letter.Body = string(body.data)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := deadLetters.PushDeadLetter(ctx, letter); err != nil {
+		log.Printf("Failed to dead-letter delivery of %s to %s: %v", body.key, target.URL, err)
+		return
+	}
+	deadLettersCounter.WithLabelValues(target.rule).Inc()
+	log.Printf("Moved delivery of %s to %s to the dead letter queue (id: %s)", body.key, target.URL, letter.ID)
+	notify(Notification{
+		Event:  NotifyDeadLetter,
+		Key:    body.key,
+		Target: target.URL,
+		Status: result.Status,
+		Error:  result.Error,
+	})
+}
+
+// handleDeadLetters lists the dead letter queue (GET) or discards a dead
+// letter by id (DELETE)
+func handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+	if deadLetters == nil {
+		writeStorageError(w, storage.ErrNotSupported, "a dead letter queue")
+		return
+	}
+	if r.Method == http.MethodDelete {
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			writeError(w, http.StatusBadRequest, ErrCodeBadRequest, "Missing id parameter")
+			return
+		}
+		letter, err := deadLetters.TakeDeadLetter(r.Context(), id)
+		if errors.Is(err, storage.ErrNotFound) {
+			writeError(w, http.StatusNotFound, ErrCodeNotFound, "Dead letter "+id+" not found")
+			return
+		}
+		if err != nil {
+			writeStorageError(w, err, "discarding the dead letter")
+			return
+		}
+		log.Printf("Discarded dead letter %s (key: %s, target: %s)", id, letter.Key, letter.Target)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	limit := int64(50)
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, ErrCodeBadRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+	letters, err := deadLetters.DeadLetters(r.Context(), limit)
+	if err != nil {
+		writeStorageError(w, err, "listing dead letters")
+		return
+	}
+	if r.URL.Query().Get("full") != "1" {
+		for i := range letters {
+			letters[i].Body = previewConfig.render([]byte(letters[i].Body))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"count":        len(letters),
+		"dead_letters": letters,
+	})
+}
+
+// handleReplayDeadLetter takes a dead letter off the queue and delivers it
+// again to its target. A failed replay is moved to the queue again.
+func handleReplayDeadLetter(w http.ResponseWriter, r *http.Request, store storage.Storage) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+	if deadLetters == nil {
+		writeStorageError(w, storage.ErrNotSupported, "a dead letter queue")
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeBadRequest, "Missing id parameter")
+		return
+	}
+
+	// Check the target before taking the letter off the queue
+	letters, err := deadLetters.DeadLetters(r.Context(), 0)
+	if err != nil {
+		writeStorageError(w, err, "listing dead letters")
+		return
+	}
+	var target Target
+	found := false
+	for i := range letters {
+		if letters[i].ID == id {
+			if target, found = findTarget(liveConfig.Load(), letters[i].Rule, letters[i].TargetLabel, letters[i].Target); !found {
+				writeError(w, http.StatusUnprocessableEntity, ErrCodeUnprocessable, "Target "+letters[i].TargetLabel+" of rule "+letters[i].Rule+" is no longer configured")
+				return
+			}
+		}
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "Dead letter "+id+" not found")
+		return
+	}
+
+	letter, err := deadLetters.TakeDeadLetter(r.Context(), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "Dead letter "+id+" not found")
+		return
+	}
+	if err != nil {
+		writeStorageError(w, err, "taking the dead letter")
+		return
+	}
+
+	body := memoryPayload([]byte(letter.Body))
+	if letter.Body == "" {
+		event, err := store.Get(r.Context(), letter.Key)
+		if err != nil {
+			deadLetters.PushDeadLetter(context.Background(), *letter)
+			writeStorageError(w, err, "loading the event")
+			return
+		}
+		body = memoryPayload([]byte(event.Body))
+	}
+	body.key, body.path = letter.Key, letter.Path
+	headers := http.Header(letter.Headers)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+	result := deliverQueued(ctx, target, body, headers)
+	log.Printf("Replayed dead letter %s to %s (status: %d)", id, letter.Target, result.Status)
+	writeJSON(w, http.StatusOK, result)
+}
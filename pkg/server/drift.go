@@ -0,0 +1,186 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// driftDetection enables payload shape inference, set by DRIFT_DETECTION=1
+var driftDetection bool
+
+const (
+	// driftLearningEvents is the number of events per path forming the
+	// baseline shape, changes seen while learning are not reported
+	driftLearningEvents = 10
+	// maxShapeFields caps the fields tracked per path, payloads using
+	// object keys as data would grow the shape without bound
+	maxShapeFields = 1000
+)
+
+var schemaDriftCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "webhook_dispatcher_schema_drift_total",
+	Help: "Number of payload shape changes by kind (new_field, type_changed)",
+}, []string{"path", "kind"})
+
+func init() {
+	prometheus.MustRegister(schemaDriftCounter)
+}
+
+// PayloadShape is the inferred shape of the payloads of a path: the JSON
+// types seen for each field. Array items are merged under [].
+type PayloadShape struct {
+	Events    int64               `json:"events"`
+	Fields    map[string][]string `json:"fields"`
+	Drifts    int64               `json:"drifts"`
+	LastDrift time.Time           `json:"last_drift"`
+
+	full bool
+}
+
+// shapeTracker infers payload shapes per path
+type shapeTracker struct {
+	mu     sync.Mutex
+	shapes map[string]*PayloadShape
+}
+
+var payloadShapes = &shapeTracker{shapes: map[string]*PayloadShape{}}
+
+// driftDetectionFromEnv reads DRIFT_DETECTION
+func driftDetectionFromEnv() bool {
+	return os.Getenv("DRIFT_DETECTION") == "1"
+}
+
+// jsonType returns the JSON type name of a decoded value
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return "unknown"
+}
+
+// flattenShape collects the types of all fields of a decoded payload
+func flattenShape(path string, v interface{}, fields map[string]map[string]bool) {
+	if fields[path] == nil {
+		fields[path] = map[string]bool{}
+	}
+	fields[path][jsonType(v)] = true
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			flattenShape(path+"."+key, value, fields)
+		}
+	case []interface{}:
+		for _, item := range v {
+			flattenShape(path+"[]", item, fields)
+		}
+	}
+}
+
+// observe merges a payload into the shape of the path, reporting new
+// fields and type changes once the baseline is learned. Fields turning
+// null or back are not reported.
+func (t *shapeTracker) observe(path string, body interface{}) {
+	seen := map[string]map[string]bool{}
+	flattenShape("$", body, seen)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	shape, ok := t.shapes[path]
+	if !ok {
+		shape = &PayloadShape{Fields: map[string][]string{}}
+		t.shapes[path] = shape
+	}
+	shape.Events++
+	learning := shape.Events <= driftLearningEvents
+
+	fields := make([]string, 0, len(seen))
+	for field := range seen {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		known, exists := shape.Fields[field]
+		if !exists && len(shape.Fields) >= maxShapeFields {
+			if !shape.full {
+				shape.full = true
+				log.Printf("Payload shape of %s exceeds %d fields, new fields are not tracked", path, maxShapeFields)
+			}
+			continue
+		}
+		for typ := range seen[field] {
+			if slices.Contains(known, typ) {
+				continue
+			}
+			if !learning {
+				switch {
+				case !exists:
+					log.Printf("Schema drift on %s: new field %s (%s)", path, field, typ)
+					shape.drift("new_field", path)
+				case typ != "null" && slices.ContainsFunc(known, func(k string) bool { return k != "null" }):
+					log.Printf("Schema drift on %s: field %s changed type from %v to %s", path, field, known, typ)
+					shape.drift("type_changed", path)
+				}
+			}
+			known = append(known, typ)
+			sort.Strings(known)
+			exists = true
+		}
+		shape.Fields[field] = known
+	}
+}
+
+// drift records a shape change
+func (s *PayloadShape) drift(kind string, path string) {
+	schemaDriftCounter.WithLabelValues(path, kind).Inc()
+	s.Drifts++
+	s.LastDrift = time.Now()
+}
+
+// snapshot returns a copy of the inferred shapes
+func (t *shapeTracker) snapshot() map[string]PayloadShape {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := make(map[string]PayloadShape, len(t.shapes))
+	for path, shape := range t.shapes {
+		cp := *shape
+		cp.Fields = make(map[string][]string, len(shape.Fields))
+		for field, types := range shape.Fields {
+			cp.Fields[field] = append([]string{}, types...)
+		}
+		out[path] = cp
+	}
+	return out
+}
+
+// handleShapes returns the inferred payload shapes per path
+func handleShapes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+	if !driftDetection {
+		writeError(w, http.StatusNotImplemented, ErrCodeNotImplemented, "Drift detection is disabled, set DRIFT_DETECTION=1")
+		return
+	}
+	writeJSON(w, http.StatusOK, payloadShapes.snapshot())
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// Rules can be managed at runtime through /api/rules. They are persisted
+// in the storage backend and merged after the rules of the config file,
+// which is read again whenever they change. Every instance polls the
+// storage every RULES_SYNC_INTERVAL (default 30s) to pick up changes made
+// through other instances. Applying rules resets per-rule state such as
+// rate limits and deduplication caches and puts settings of the config
+// file such as notifications and keys into effect, see
+// applyConfigSettings.
+
+// liveConfig is the config in effect
+var liveConfig atomic.Pointer[Config]
+
+var (
+	dynamicRulesMu sync.Mutex
+	// dynamicRules are the stored rules in effect by name
+	dynamicRules map[string]string
+	// configFile is the config file dynamic rules are merged with
+	configFile string
+)
+
+// maxRuleSize bounds rule documents sent to the API
+const maxRuleSize = 1 << 20
+
+// ruleNameRegexp restricts names of dynamic rules
+var ruleNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// DynamicRule is a rule managed through the admin API, as YAML or JSON
+type DynamicRule struct {
+	Name string `json:"name"`
+	Rule string `json:"rule"`
+}
+
+// startRuleSync applies the rules stored in the backend and keeps them in
+// sync, if the backend can store rules
+func startRuleSync(store storage.Storage, path string) {
+	rules, ok := store.(storage.RuleStore)
+	if !ok {
+		return
+	}
+	configFile = path
+	interval := durationFromEnv("RULES_SYNC_INTERVAL", 30*time.Second)
+	go func() {
+		defer recoverGoroutine("rule sync")
+		// Retry quickly until the storage is connected
+		for synced := false; ; {
+			synced = syncRules(rules) || synced
+			if synced {
+				time.Sleep(interval)
+			} else {
+				time.Sleep(time.Second)
+			}
+		}
+	}()
+}
+
+// syncRules applies the stored rules if they changed, reporting whether
+// they could be loaded
+func syncRules(store storage.RuleStore) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rules, err := store.Rules(ctx)
+	if err != nil {
+		if !errors.Is(err, storage.ErrUnavailable) && !errors.Is(err, storage.ErrNotSupported) {
+			log.Printf("Failed to load stored rules: %v", err)
+		}
+		return false
+	}
+
+	dynamicRulesMu.Lock()
+	defer dynamicRulesMu.Unlock()
+	if maps.Equal(rules, dynamicRules) {
+		return true
+	}
+	if err := applyRules(rules); err != nil {
+		log.Printf("Failed to apply stored rules: %v", err)
+		return true
+	}
+	log.Printf("Applied %d stored dispatch rules", len(rules))
+	return true
+}
+
+// applyRules builds the config with the rules and puts it into effect,
+// dynamicRulesMu must be held
+func applyRules(rules map[string]string) error {
+	config, err := buildConfig(rules)
+	if err != nil {
+		return err
+	}
+	applyConfigSettings(config)
+	liveConfig.Store(config)
+	dynamicRules = rules
+	return nil
+}
+
+// applyConfigSettings puts the settings of the config kept outside of it
+// into effect. Admin API authentication and the queue API lockout are set
+// up once at startup and change only on restart.
+func applyConfigSettings(config *Config) {
+	notifications = config.Notifications
+	registerRuleLabels(config)
+	previewConfig = config.Preview
+	slugConfig = config.Slug
+	// Keep the generator unless the key settings changed, a new snowflake
+	// generator would restart its sequence
+	if config.Keys.generator != nil && !config.Keys.sameSettings(activeKeys) {
+		keyGenerator = config.Keys.generator
+		activeKeys = config.Keys
+	}
+}
+
+// buildConfig reads the config file and adds the stored rules
+func buildConfig(rules map[string]string) (*Config, error) {
+	config, err := readConfig(configFile)
+	if errors.Is(err, os.ErrNotExist) {
+		config = &Config{}
+	} else if err != nil {
+		return nil, err
+	}
+
+	names := slices.Sorted(maps.Keys(rules))
+	for _, name := range names {
+		rule, err := parseDynamicRule(name, rules[name])
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", name, err)
+		}
+		config.Dispatch = append(config.Dispatch, rule)
+	}
+	if err := config.prepare(); err != nil {
+		return nil, err
+	}
+	if driftDetection {
+		config.needsBody = true
+	}
+	return config, nil
+}
+
+// parseDynamicRule parses a stored rule, its Name defaults to the name it
+// is stored under
+func parseDynamicRule(name string, data string) (DispatchRule, error) {
+	var rule DispatchRule
+	dec := yaml.NewDecoder(strings.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&rule); err != nil {
+		return rule, err
+	}
+	if rule.Name == "" {
+		rule.Name = name
+	}
+	if rule.Name != name {
+		return rule, fmt.Errorf("name %q does not match the rule name", rule.Name)
+	}
+	return rule, nil
+}
+
+// handleRules lists (GET), creates or replaces (PUT ?name=) and deletes
+// (DELETE ?name=) dynamic rules, all requiring the admin role as the rules
+// may hold secrets. Changes to the API section of the config file are not
+// applied with them, they require a restart.
+func handleRules(w http.ResponseWriter, r *http.Request, store storage.Storage) {
+	ruleStore, ok := store.(storage.RuleStore)
+	if !ok || configFile == "" {
+		writeStorageError(w, storage.ErrNotSupported, "dispatch rules")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		rules, err := ruleStore.Rules(r.Context())
+		if err != nil {
+			writeStorageError(w, err, "listing dispatch rules")
+			return
+		}
+		list := []DynamicRule{}
+		for _, name := range slices.Sorted(maps.Keys(rules)) {
+			list = append(list, DynamicRule{Name: name, Rule: rules[name]})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"rules": list})
+		return
+	}
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if !ruleNameRegexp.MatchString(name) {
+		writeError(w, http.StatusBadRequest, ErrCodeBadRequest, "Invalid or missing rule name")
+		return
+	}
+	var data []byte
+	if r.Method == http.MethodPut {
+		var err error
+		data, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxRuleSize))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeReadBody, "Failed to read rule")
+			return
+		}
+	}
+
+	dynamicRulesMu.Lock()
+	defer dynamicRulesMu.Unlock()
+	current, err := ruleStore.Rules(r.Context())
+	if err != nil {
+		writeStorageError(w, err, "loading dispatch rules")
+		return
+	}
+	rules := maps.Clone(current)
+	_, exists := rules[name]
+	if r.Method == http.MethodDelete {
+		if !exists {
+			writeError(w, http.StatusNotFound, ErrCodeNotFound, "Rule "+name+" does not exist")
+			return
+		}
+		delete(rules, name)
+	} else {
+		rules[name] = string(data)
+	}
+
+	// Validate the rules before storing them
+	config, err := buildConfig(rules)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+	if r.Method == http.MethodDelete {
+		err = ruleStore.DeleteRule(r.Context(), name)
+	} else {
+		err = ruleStore.PutRule(r.Context(), name, string(data))
+	}
+	if err != nil {
+		writeStorageError(w, err, "storing the dispatch rule")
+		return
+	}
+	applyConfigSettings(config)
+	liveConfig.Store(config)
+	dynamicRules = rules
+
+	switch {
+	case r.Method == http.MethodDelete:
+		log.Printf("Deleted dispatch rule %s", name)
+		w.WriteHeader(http.StatusNoContent)
+	case exists:
+		log.Printf("Updated dispatch rule %s", name)
+		writeJSON(w, http.StatusOK, DynamicRule{Name: name, Rule: string(data)})
+	default:
+		log.Printf("Created dispatch rule %s", name)
+		writeJSON(w, http.StatusCreated, DynamicRule{Name: name, Rule: string(data)})
+	}
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+)
+
+// Stable error codes returned in error responses
+const (
+	ErrCodeBadRequest         = "bad_request"
+	ErrCodeReadBody           = "read_body_failed"
+	ErrCodeInvalidJSON        = "invalid_json"
+	ErrCodeInvalidSignature   = "invalid_signature"
+	ErrCodeUnauthorized       = "unauthorized"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeNotFound           = "not_found"
+	ErrCodeMethodNotAllowed   = "method_not_allowed"
+	ErrCodeUnprocessable      = "unprocessable"
+	ErrCodeSchemaMismatch     = "schema_mismatch"
+	ErrCodePayloadTooLarge    = "payload_too_large"
+	ErrCodeStorageFailed      = "storage_failed"
+	ErrCodeStorageUnavailable = "storage_unavailable"
+	ErrCodeProcessingFailed   = "processing_failed"
+	ErrCodeDeliveryFailed     = "delivery_failed"
+	ErrCodeRateLimited        = "rate_limited"
+	ErrCodeNotImplemented     = "not_implemented"
+	ErrCodeInternal           = "internal_error"
+)
+
+// ErrorResponse is the JSON body of every 4xx/5xx response
+type ErrorResponse struct {
+	Error  string `json:"error"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// writeError writes a machine-readable error response
+func writeError(w http.ResponseWriter, status int, code string, detail string) {
+	writeJSON(w, status, ErrorResponse{Error: code, Detail: detail})
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sikalabs/webhook-dispatcher/pkg/wasm"
+)
+
+// Experiment splits events of a rule between transformation variants,
+// e.g. to roll out a new payload format gradually. Variants run after the
+// rule WASM plugins and processors.
+type Experiment struct {
+	// Name labels the experiment metrics
+	Name     string    `yaml:"Name"`
+	Variants []Variant `yaml:"Variants"`
+
+	totalWeight int
+}
+
+// Variant is a transformation applied to a share of the events
+type Variant struct {
+	Name string `yaml:"Name"`
+	// Weight is the share of events the variant receives, defaults to 1
+	Weight int `yaml:"Weight"`
+	// Wasm plugins and Processors transforming the payload, a variant
+	// without any forwards the payload unchanged
+	Wasm       []string    `yaml:"Wasm"`
+	Processors []Processor `yaml:"Processors"`
+
+	wasmPlugins []*wasm.Plugin
+}
+
+var (
+	experimentEventsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_dispatcher_experiment_events_total",
+		Help: "Number of events transformed by experiment variants by outcome (forwarded, dropped, failed)",
+	}, []string{"path", "experiment", "variant", "outcome"})
+	experimentDeliveriesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_dispatcher_experiment_deliveries_total",
+		Help: "Number of deliveries of events transformed by experiment variants by result (success, failure)",
+	}, []string{"path", "experiment", "variant", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(experimentEventsCounter)
+	prometheus.MustRegister(experimentDeliveriesCounter)
+}
+
+// prepare validates the variants and loads their plugins and processors
+func (e *Experiment) prepare(outbound OutboundConfig) error {
+	if e.Name == "" {
+		return fmt.Errorf("experiment Name is required")
+	}
+	if len(e.Variants) < 2 {
+		return fmt.Errorf("experiment %s: at least two variants are required", e.Name)
+	}
+	names := map[string]bool{}
+	e.totalWeight = 0
+	for i := range e.Variants {
+		v := &e.Variants[i]
+		if v.Name == "" || names[v.Name] {
+			return fmt.Errorf("experiment %s: variant names must be unique and not empty", e.Name)
+		}
+		names[v.Name] = true
+		if v.Weight < 0 {
+			return fmt.Errorf("experiment %s: variant %s: Weight must not be negative", e.Name, v.Name)
+		}
+		if v.Weight == 0 {
+			v.Weight = 1
+		}
+		e.totalWeight += v.Weight
+		if err := prepareProcessors(v.Processors, outbound); err != nil {
+			return fmt.Errorf("experiment %s: variant %s: %w", e.Name, v.Name, err)
+		}
+		for _, path := range v.Wasm {
+			plugin, err := wasm.Load(path)
+			if err != nil {
+				return fmt.Errorf("experiment %s: variant %s: %w", e.Name, v.Name, err)
+			}
+			v.wasmPlugins = append(v.wasmPlugins, plugin)
+		}
+	}
+	return nil
+}
+
+// pick returns a variant at random in proportion to the weights
+func (e *Experiment) pick() *Variant {
+	n := rand.IntN(e.totalWeight)
+	for i := range e.Variants {
+		n -= e.Variants[i].Weight
+		if n < 0 {
+			return &e.Variants[i]
+		}
+	}
+	return &e.Variants[len(e.Variants)-1]
+}
+
+// process transforms the payload with the variant
+func (v *Variant) process(ctx context.Context, body []byte, headers http.Header) ([]byte, bool, error) {
+	out, keep, err := runWasmPlugins(ctx, v.wasmPlugins, body)
+	if err != nil || !keep {
+		return nil, keep, err
+	}
+	out, err = runProcessors(ctx, v.Processors, out, headers)
+	if err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+// observeVariant records the outcome of transforming an event with the
+// variant of the rule experiment
+func observeVariant(rule *DispatchRule, variant *Variant, keep bool, err error) {
+	outcome := "forwarded"
+	switch {
+	case err != nil:
+		outcome = "failed"
+	case !keep:
+		outcome = "dropped"
+	}
+	experimentEventsCounter.WithLabelValues(rule.label(), rule.Experiment.Name, variant.Name, outcome).Inc()
+}
+
+// observeVariantDeliveries records delivery results of an event
+// transformed with the variant of the rule experiment
+func observeVariantDeliveries(rule *DispatchRule, variant *Variant, results []DeliveryResult) {
+	for _, result := range results {
+		status := "success"
+		if !result.OK() {
+			status = "failure"
+		}
+		experimentDeliveriesCounter.WithLabelValues(rule.label(), rule.Experiment.Name, variant.Name, status).Inc()
+	}
+}
+
+// transforms reports whether the rule transforms payloads, which requires
+// them in memory
+func (r *DispatchRule) transforms() bool {
+	return len(r.Processors) > 0 || len(r.wasmPlugins) > 0 || r.Experiment != nil
+}
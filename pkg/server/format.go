@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/convert"
+)
+
+// maxDescriptorSet limits the size of fetched Protobuf descriptor sets
+const maxDescriptorSet = 16 << 20
+
+// ProtobufConfig encodes payloads forwarded to a target as a Protobuf
+// message, for targets with the protobuf Format. Payloads are mapped to
+// the message as in the Protobuf JSON mapping, fields not in the message
+// are dropped.
+type ProtobufConfig struct {
+	// Message is the full name of the message, e.g.
+	// acme.orders.v1.OrderCreated
+	Message string `yaml:"Message"`
+	// DescriptorFile is a binary FileDescriptorSet including imports, as
+	// written by protoc --descriptor_set_out --include_imports or buf
+	// build, or use DescriptorURL
+	DescriptorFile string `yaml:"DescriptorFile"`
+	// DescriptorURL is fetched on config load, e.g. from a schema registry
+	// or artifact store serving binary descriptor sets
+	DescriptorURL string `yaml:"DescriptorURL"`
+
+	message *convert.ProtoMessage
+}
+
+// prepare loads the descriptor set and finds the message
+func (p *ProtobufConfig) prepare() error {
+	if p.Message == "" {
+		return fmt.Errorf("protobuf: Message is required")
+	}
+	if (p.DescriptorFile == "") == (p.DescriptorURL == "") {
+		return fmt.Errorf("protobuf: one of DescriptorFile and DescriptorURL is required")
+	}
+	var data []byte
+	var err error
+	if p.DescriptorFile != "" {
+		data, err = os.ReadFile(p.DescriptorFile)
+	} else {
+		data, err = fetchDescriptorSet(p.DescriptorURL)
+	}
+	if err != nil {
+		return fmt.Errorf("protobuf: %w", err)
+	}
+	if p.message, err = convert.NewProtoMessage(data, p.Message); err != nil {
+		return fmt.Errorf("protobuf: %w", err)
+	}
+	return nil
+}
+
+// fetchDescriptorSet downloads a descriptor set
+func fetchDescriptorSet(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: status %d", url, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDescriptorSet+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", url, err)
+	}
+	if len(data) > maxDescriptorSet {
+		return nil, fmt.Errorf("fetching %s: descriptor set over %d bytes", url, maxDescriptorSet)
+	}
+	return data, nil
+}
+
+// prepareFormat validates the Format of a target and loads its Protobuf
+// message
+func (t *Target) prepareFormat() error {
+	if _, ok := convert.ContentType(t.Format); t.Format != "" && !ok {
+		return fmt.Errorf("unknown Format %q, expected one of %s", t.Format, strings.Join(convert.Formats, ", "))
+	}
+	if t.Protobuf == nil {
+		if t.Format == convert.Protobuf {
+			return fmt.Errorf("the protobuf Format requires Protobuf")
+		}
+		return nil
+	}
+	if t.Format == "" {
+		t.Format = convert.Protobuf
+	}
+	if t.Format != convert.Protobuf {
+		return fmt.Errorf("Protobuf requires the protobuf Format")
+	}
+	return t.Protobuf.prepare()
+}
+
+// convertPayload converts the payload to the Format of the target and
+// returns it with its content type. Payloads already in the format are
+// sent as they came.
+func (t *Target) convertPayload(body payload, contentType string) (payload, string, error) {
+	from, ok := convert.FormatOf(contentType)
+	if t.Format == "" || (ok && from == t.Format) {
+		return body, contentType, nil
+	}
+	if body.spilled() {
+		return body, contentType, fmt.Errorf("conversion of payloads over %d bytes is not supported", streamThreshold)
+	}
+	var data []byte
+	var err error
+	if t.Protobuf != nil {
+		var v interface{}
+		if !ok {
+			err = fmt.Errorf("cannot convert %s payloads", contentType)
+		} else if v, err = convert.Decode(body.data, from); err == nil {
+			data, err = t.Protobuf.message.Encode(v)
+		}
+	} else {
+		data, err = convert.Convert(body.data, contentType, t.Format)
+	}
+	if err != nil {
+		return body, contentType, fmt.Errorf("converting to %s: %w", t.Format, err)
+	}
+	converted, _ := convert.ContentType(t.Format)
+	if t.Protobuf != nil {
+		converted += "; messageType=" + t.Protobuf.message.Name()
+	}
+	return body.withData(data), converted, nil
+}
@@ -0,0 +1,370 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/wasm"
+)
+
+// DeliveryResult is the outcome of a single delivery to a target
+type DeliveryResult struct {
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Duration of the delivery including retries and fallbacks, set for
+	// synchronous deliveries
+	Duration float64 `json:"duration_ms,omitempty"`
+}
+
+// OK reports whether the target accepted the delivery
+func (d DeliveryResult) OK() bool {
+	return d.Error == "" && d.Status >= 200 && d.Status < 300
+}
+
+// dispatch runs the rule plugins, processors and experiment and forwards
+// the result to the targets rendered for the rule
+func dispatch(rule *DispatchRule, targets []Target, body payload, headers http.Header) {
+	if !rule.transforms() {
+		forwardToTargets(targets, body, headers)
+		return
+	}
+
+	go func() {
+		defer recoverGoroutine("dispatch for " + rule.label())
+
+		ctx, cancel := context.WithTimeout(context.Background(), processingTimeout)
+		defer cancel()
+
+		out, variant, keep, err := processPayload(ctx, rule, body.data, headers)
+		if err != nil {
+			log.Printf("Failed to process webhook for %s, not forwarding: %v", rule.label(), err)
+			return
+		}
+		if !keep {
+			log.Printf("Webhook for %s dropped by WASM filter", rule.label())
+			return
+		}
+		if variant == nil {
+			forwardToTargets(targets, body.withData(out), headers)
+			return
+		}
+		// Wait for the deliveries to record their results for the variant
+		results := forwardToTargetsSync(context.Background(), targets, body.withData(out), headers)
+		observeVariantDeliveries(rule, variant, results)
+	}()
+}
+
+// dispatchSync processes the payload and forwards it to the targets,
+// waiting for all deliveries to finish
+func dispatchSync(ctx context.Context, rule *DispatchRule, targets []Target, body payload, headers http.Header) ([]DeliveryResult, error) {
+	procCtx, cancel := context.WithTimeout(ctx, processingTimeout)
+	defer cancel()
+
+	out, variant, keep, err := processPayload(procCtx, rule, body.data, headers)
+	if err != nil {
+		return nil, err
+	}
+	if !keep {
+		log.Printf("Webhook for %s dropped by WASM filter", rule.label())
+		return nil, nil
+	}
+	results := forwardToTargetsSync(ctx, targets, body.withData(out), headers)
+	if variant != nil {
+		observeVariantDeliveries(rule, variant, results)
+	}
+	return results, nil
+}
+
+// processPayload runs the rule WASM plugins, processors and experiment
+// variant, returning the payload to forward, the variant applied and
+// whether the event should be forwarded at all
+func processPayload(ctx context.Context, rule *DispatchRule, body []byte, headers http.Header) ([]byte, *Variant, bool, error) {
+	out, keep, err := runWasmPlugins(ctx, rule.wasmPlugins, body)
+	if err != nil || !keep {
+		return nil, nil, keep, err
+	}
+	out, err = runProcessors(ctx, rule.Processors, out, headers)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if rule.Experiment == nil {
+		return out, nil, true, nil
+	}
+
+	variant := rule.Experiment.pick()
+	out, keep, err = variant.process(ctx, out, headers)
+	observeVariant(rule, variant, keep, err)
+	if err != nil {
+		return nil, variant, false, fmt.Errorf("experiment %s variant %s: %w", rule.Experiment.Name, variant.Name, err)
+	}
+	return out, variant, keep, nil
+}
+
+// runWasmPlugins passes the payload through WASM plugins in order,
+// stopping when a filter drops the event
+func runWasmPlugins(ctx context.Context, plugins []*wasm.Plugin, body []byte) ([]byte, bool, error) {
+	for _, plugin := range plugins {
+		out, keep, err := plugin.Run(ctx, body)
+		if err != nil {
+			return nil, false, fmt.Errorf("plugin %s: %w", plugin.Path(), err)
+		}
+		if !keep {
+			return nil, false, nil
+		}
+		body = out
+	}
+	return body, true, nil
+}
+
+// forwardToTargets forwards the webhook to all target URLs, through the
+// delivery stream when enabled, removing a spilled payload once all
+// deliveries finished
+func forwardToTargets(targets []Target, body payload, headers http.Header) {
+	targets = deliveryStream.enqueue(targets, body, headers)
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		activity.deliveryStarted()
+		wg.Add(1)
+		go func(target Target) {
+			defer wg.Done()
+			defer activity.deliveryFinished()
+			deliverQueued(context.Background(), target, body, headers)
+		}(target)
+	}
+	if body.spilled() {
+		go func() {
+			wg.Wait()
+			body.remove()
+		}()
+	}
+}
+
+// forwardToTargetsSync forwards the webhook to all target URLs in parallel
+// and returns the results in target order. Shadow targets are left out of
+// the results and not waited for, unless the payload is spilled to disk
+// and must outlive their deliveries.
+func forwardToTargetsSync(ctx context.Context, targets []Target, body payload, headers http.Header) []DeliveryResult {
+	var shadow []Target
+	targets, shadow = splitShadow(targets)
+	if !body.spilled() {
+		forwardToTargets(shadow, body, headers)
+		shadow = nil
+	}
+
+	results := make([]DeliveryResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		activity.deliveryStarted()
+		wg.Add(1)
+		go func(i int, target Target) {
+			defer wg.Done()
+			defer activity.deliveryFinished()
+			start := time.Now()
+			results[i] = deliverQueued(ctx, target, body, headers)
+			results[i].Duration = float64(time.Since(start).Microseconds()) / 1000
+		}(i, target)
+	}
+	for _, target := range shadow {
+		activity.deliveryStarted()
+		wg.Add(1)
+		go func(target Target) {
+			defer wg.Done()
+			defer activity.deliveryFinished()
+			deliverQueued(context.Background(), target, body, headers)
+		}(target)
+	}
+	wg.Wait()
+	return results
+}
+
+// splitShadow separates shadow targets from the others
+func splitShadow(targets []Target) ([]Target, []Target) {
+	var regular, shadow []Target
+	for _, target := range targets {
+		if target.shadow {
+			shadow = append(shadow, target)
+		} else {
+			regular = append(regular, target)
+		}
+	}
+	return regular, shadow
+}
+
+// deliverQueued waits for the target maintenance to end and a free
+// delivery worker and delivers to the target. Events for digest targets
+// are added to their digest instead.
+func deliverQueued(ctx context.Context, target Target, body payload, headers http.Header) DeliveryResult {
+	if target.Digest != nil {
+		return addToDigest(target, body, headers)
+	}
+	defer backlog.add()()
+	if err := target.awaitMaintenance(ctx); err != nil {
+		log.Printf("Delivery to %s not started: %v", target.URL, err)
+		return DeliveryResult{URL: target.URL, Error: err.Error()}
+	}
+	release, err := deliveries.acquire(ctx)
+	if err != nil {
+		log.Printf("Delivery to %s not started: %v", target.URL, err)
+		return DeliveryResult{URL: target.URL, Error: err.Error()}
+	}
+	defer release()
+	result := deliverWithFallback(ctx, target, body, headers)
+	if !result.OK() {
+		notifyDeliveryFailed(target, result)
+		pushDeadLetter(target, body, headers, result)
+	}
+	return result
+}
+
+// deliverWithFallback delivers to the target and, if that fails, to its
+// fallback targets in order until one succeeds
+func deliverWithFallback(ctx context.Context, target Target, body payload, headers http.Header) DeliveryResult {
+	result := deliverWithRetry(ctx, target, body, headers)
+	for _, fallback := range target.Fallback {
+		if result.OK() {
+			break
+		}
+		log.Printf("Delivery to %s failed, trying fallback %s", result.URL, fallback.URL)
+		result = deliverWithFallback(ctx, fallback, body, headers)
+	}
+	return result
+}
+
+// deliver sends the webhook to a single target
+func deliver(ctx context.Context, target Target, body payload, headers http.Header) (result DeliveryResult) {
+	url := target.URL
+	start := time.Now()
+	// body is replaced by the converted or encrypted payload below
+	original := body
+	defer func() {
+		m := recordManifest(url, original, result)
+		recordDelivery(target, original, result, start)
+		trackDelivery(target, original, result, start, m)
+	}()
+	if target.pull != "" {
+		return enqueuePull(ctx, target, body, headers)
+	}
+	policy := target.policy
+	if policy == nil {
+		policy = defaultPolicy
+	}
+	client := &http.Client{
+		Transport: target.httpTransport(),
+		Timeout:   target.timeout(),
+	}
+
+	contentType := headers.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	body, contentType, err := target.convertPayload(body, contentType)
+	if err != nil {
+		log.Printf("Failed to convert webhook for %s: %v", url, err)
+		return DeliveryResult{URL: url, Error: err.Error()}
+	}
+	if target.JWE != nil {
+		if body.spilled() {
+			err := fmt.Errorf("JWE encryption of payloads over %d bytes is not supported", streamThreshold)
+			log.Printf("Failed to encrypt webhook for %s: %v", url, err)
+			return DeliveryResult{URL: url, Error: err.Error()}
+		}
+		encrypted, err := target.JWE.encrypt(body.data, contentType)
+		if err != nil {
+			log.Printf("Failed to encrypt webhook for %s: %v", url, err)
+			return DeliveryResult{URL: url, Error: err.Error()}
+		}
+		body = body.withData(encrypted)
+		contentType = "application/jose"
+	}
+
+	reader, err := body.open()
+	if err != nil {
+		log.Printf("Failed to open payload for %s: %v", url, err)
+		return DeliveryResult{URL: url, Error: err.Error()}
+	}
+	req, err := http.NewRequestWithContext(ctx, target.requestMethod(headers), url, reader)
+	if err != nil {
+		reader.Close()
+		log.Printf("Failed to create request for %s: %v", url, err)
+		return DeliveryResult{URL: url, Error: err.Error()}
+	}
+	req.ContentLength = body.size
+	req.GetBody = func() (io.ReadCloser, error) {
+		return body.open()
+	}
+
+	// Resolve the host once and pin the delivery to the validated addresses
+	pinnedCtx, err := policy.pin(ctx, req.URL.Hostname(), target.timeout())
+	if err != nil {
+		reader.Close()
+		activity.recordDelivery(target.label(), 0, err)
+		log.Printf("Failed to forward webhook to %s: %v", url, err)
+		return DeliveryResult{URL: url, Error: err.Error()}
+	}
+	req = req.WithContext(pinnedCtx)
+
+	// Incoming headers passed through, configured headers take precedence
+	target.copyPassHeaders(req.Header, headers)
+
+	// Identification headers
+	for name, values := range target.headers {
+		req.Header[name] = values
+	}
+	if target.Auth != nil {
+		target.Auth.apply(req)
+	}
+
+	// Copy relevant headers
+	copyTraceContext(req.Header, headers)
+	req.Header.Set("Content-Type", contentType)
+	if target.Signing != nil {
+		if err := target.Signing.sign(req.Header, body); err != nil {
+			reader.Close()
+			log.Printf("Failed to sign webhook for %s: %v", url, err)
+			return DeliveryResult{URL: url, Error: err.Error()}
+		}
+	}
+
+	var capture *DeliveryCapture
+	if debugCaptures.take(target.label()) {
+		capture = &DeliveryCapture{
+			Time:           time.Now(),
+			Method:         req.Method,
+			URL:            url,
+			RequestHeaders: redactCredentials(req.Header),
+			RequestBody:    truncateCapture(body.head(maxCaptureBody + 1)),
+		}
+		defer func() {
+			capture.Duration = time.Since(capture.Time).String()
+			debugCaptures.record(target.label(), *capture)
+		}()
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		activity.recordDelivery(target.label(), 0, err)
+		log.Printf("Failed to forward webhook to %s: %v", url, err)
+		if capture != nil {
+			capture.Error = err.Error()
+		}
+		return DeliveryResult{URL: url, Error: err.Error()}
+	}
+	defer resp.Body.Close()
+	activity.recordDelivery(target.label(), resp.StatusCode, nil)
+
+	if capture != nil {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxCaptureBody+1))
+		capture.Status = resp.StatusCode
+		capture.ResponseHeaders = resp.Header.Clone()
+		capture.ResponseBody = truncateCapture(respBody)
+	}
+
+	log.Printf("Forwarded webhook to %s (status: %d)", url, resp.StatusCode)
+	return DeliveryResult{URL: url, Status: resp.StatusCode}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+)
+
+// geoDB looks up sender location and network, nil when not configured
+var geoDB *geoIPDB
+
+// geoIPDB holds the MaxMind databases used for enrichment
+type geoIPDB struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+// openGeoIP opens the databases given by GEOIP_DB (GeoLite2 City or Country)
+// and GEOIP_ASN_DB (GeoLite2 ASN), returns nil if neither is set
+func openGeoIP() (*geoIPDB, error) {
+	cityPath := os.Getenv("GEOIP_DB")
+	asnPath := os.Getenv("GEOIP_ASN_DB")
+	if cityPath == "" && asnPath == "" {
+		return nil, nil
+	}
+
+	db := &geoIPDB{}
+	var err error
+	if cityPath != "" {
+		if db.city, err = maxminddb.Open(cityPath); err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", cityPath, err)
+		}
+	}
+	if asnPath != "" {
+		if db.asn, err = maxminddb.Open(asnPath); err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", asnPath, err)
+		}
+	}
+	return db, nil
+}
+
+// lookup returns geo and network information of an IP address
+func (db *geoIPDB) lookup(ip net.IP) *storage.GeoInfo {
+	if db == nil || ip == nil {
+		return nil
+	}
+
+	info := &storage.GeoInfo{}
+	if db.city != nil {
+		var record struct {
+			Country struct {
+				ISOCode string `maxminddb:"iso_code"`
+			} `maxminddb:"country"`
+			City struct {
+				Names map[string]string `maxminddb:"names"`
+			} `maxminddb:"city"`
+		}
+		if err := db.city.Lookup(ip, &record); err != nil {
+			log.Printf("GeoIP lookup of %s failed: %v", ip, err)
+		}
+		info.Country = record.Country.ISOCode
+		info.City = record.City.Names["en"]
+	}
+	if db.asn != nil {
+		var record struct {
+			Number       uint   `maxminddb:"autonomous_system_number"`
+			Organization string `maxminddb:"autonomous_system_organization"`
+		}
+		if err := db.asn.Lookup(ip, &record); err != nil {
+			log.Printf("ASN lookup of %s failed: %v", ip, err)
+		}
+		info.ASN = record.Number
+		info.ASOrg = record.Organization
+	}
+
+	if *info == (storage.GeoInfo{}) {
+		return nil
+	}
+	return info
+}
+
+// clientIP returns the sender address, taken from X-Forwarded-For when
+// TRUST_PROXY=1
+func clientIP(r *http.Request) net.IP {
+	if trustProxy {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			first, _, _ := strings.Cut(xff, ",")
+			if ip := net.ParseIP(strings.TrimSpace(first)); ip != nil {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+var homepageTemplate = template.Must(template.New("homepage").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Webhook Dispatcher</title>
+    <style>
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, "Helvetica Neue", Arial, sans-serif;
+            max-width: 800px;
+            margin: 50px auto;
+            padding: 20px;
+            line-height: 1.6;
+            color: #333;
+        }
+        h1 {
+            color: #2c3e50;
+        }
+        .description {
+            font-size: 1.1em;
+            margin: 20px 0;
+        }
+        .status {
+            background-color: #d4edda;
+            border: 1px solid #c3e6cb;
+            color: #155724;
+            padding: 12px;
+            border-radius: 4px;
+            margin-top: 20px;
+        }
+        .degraded {
+            background-color: #f8d7da;
+            border-color: #f5c6cb;
+            color: #721c24;
+        }
+        table {
+            margin-top: 20px;
+            border-collapse: collapse;
+        }
+        th, td {
+            text-align: left;
+            padding: 4px 16px 4px 0;
+        }
+        .ok {
+            color: #155724;
+        }
+        .fail {
+            color: #721c24;
+        }
+        .right {
+            position: fixed;
+            bottom: 0px;
+            right: 20px;
+            font-size: 1.2em;
+        }
+    </style>
+</head>
+<body>
+    <h1>Webhook Dispatcher</h1>
+    <div class="description">
+        <p>A simple webhook receiver that stores webhook payloads and can forward them to configured targets.</p>
+    </div>
+    {{ if .Healthy }}
+    <div class="status">
+        <strong>Status:</strong> Service is running and ready to receive webhooks
+    </div>
+    {{ else }}
+    <div class="status degraded">
+        <strong>Status:</strong> Service is running, but some storage backends are unavailable
+    </div>
+    {{ end }}
+    <table>
+        <tr><th>Uptime</th><td>{{ .Uptime }}</td></tr>
+        <tr><th>Events received today</th><td>{{ .Today }}</td></tr>
+        <tr><th>Dispatch rules</th><td>{{ .Rules }}</td></tr>
+        {{ range .Backends }}
+        <tr><th>{{ .Name }}</th><td class="{{ if .OK }}ok{{ else }}fail{{ end }}">{{ .Status }}</td></tr>
+        {{ end }}
+    </table>
+    <p class="right">
+        <a href="https://github.com/sikalabs/webhook-dispatcher" target="_blank" style="color:black">webhook-dispatcher</a> by <a href="https://sikalabs.com" target="_blank" style="color:black">sikalabs</a>
+    </p>
+</body>
+</html>`))
+
+// backendStatus is the health of a single storage backend
+type backendStatus struct {
+	Name   string
+	Status string
+	OK     bool
+}
+
+// handleHomepage serves the homepage with live service figures
+func handleHomepage(w http.ResponseWriter, r *http.Request, config *Config) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	var backends []backendStatus
+	if redisStore := connectedRedis.Load(); redisStore != nil {
+		backends = append(backends, pingBackend(ctx, "Redis", redisStore))
+	} else {
+		backends = append(backends, backendStatus{Name: "Redis", Status: "connecting"})
+	}
+	if mongoStore := connectedMongoDB.Load(); mongoStore != nil {
+		backends = append(backends, pingBackend(ctx, "MongoDB", mongoStore))
+	} else if os.Getenv("MONGODB_URI") != "" {
+		backends = append(backends, backendStatus{Name: "MongoDB", Status: "connecting"})
+	}
+
+	healthy := true
+	for _, b := range backends {
+		healthy = healthy && b.OK
+	}
+
+	data := struct {
+		Healthy  bool
+		Uptime   time.Duration
+		Today    int64
+		Rules    int
+		Backends []backendStatus
+	}{
+		Healthy:  healthy,
+		Uptime:   time.Since(startTime).Round(time.Second),
+		Today:    activity.snapshot().Today,
+		Rules:    len(config.Dispatch),
+		Backends: backends,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := homepageTemplate.Execute(w, data); err != nil {
+		log.Printf("Failed to render homepage: %v", err)
+	}
+}
+
+// pingBackend checks a storage backend connection. The page is public, so
+// errors are only logged.
+func pingBackend(ctx context.Context, name string, backend interface{ Ping(context.Context) error }) backendStatus {
+	if err := backend.Ping(ctx); err != nil {
+		log.Printf("Failed to ping %s for the homepage: %v", name, err)
+		return backendStatus{Name: name, Status: "unavailable"}
+	}
+	return backendStatus{Name: name, Status: "connected", OK: true}
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3Server returns an HTTP/3 server for the UDP port of the address
+// and a handler advertising it to HTTP/1.1 and HTTP/2 clients with an
+// Alt-Svc header. HTTP/3 is experimental and enabled with HTTP3=1, it
+// needs TLS.
+func newHTTP3Server(addr string, handler http.Handler) (*http3.Server, http.Handler) {
+	h3 := &http3.Server{Addr: addr, Handler: handler}
+	advertised := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := h3.SetQUICHeaders(w.Header()); err != nil {
+			log.Printf("Failed to advertise HTTP/3: %v", err)
+		}
+		handler.ServeHTTP(w, r)
+	})
+	return h3, advertised
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// includedConfig is the part of the config an included file may hold
+type includedConfig struct {
+	Include      []string                   `yaml:"Include"`
+	TargetGroups map[string][]Target        `yaml:"TargetGroups"`
+	Schemas      map[string][]SchemaVersion `yaml:"Schemas"`
+	Dispatch     []DispatchRule             `yaml:"Dispatch"`
+}
+
+// include merges the files included by the config loaded from path, in
+// order. Rules are appended after the rules of the including file, target
+// groups and schemas may not be defined twice.
+func (c *Config) include(path string, seen map[string]bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	seen[abs] = true
+	return c.includeFiles(filepath.Dir(abs), c.Include, seen)
+}
+
+// includeFiles merges the files matching the patterns, relative to dir
+func (c *Config) includeFiles(dir string, patterns []string, seen map[string]bool) error {
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("include %s: %w", pattern, err)
+		}
+		// A glob may match nothing, e.g. an empty rules.d directory
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return fmt.Errorf("include %s: no such file", pattern)
+		}
+		for _, file := range matches {
+			if seen[file] {
+				return fmt.Errorf("include %s: included more than once", file)
+			}
+			seen[file] = true
+			if err := c.includeFile(file, seen); err != nil {
+				return fmt.Errorf("include %s: %w", file, err)
+			}
+		}
+	}
+	return nil
+}
+
+// includeFile merges a single included file and the files it includes
+func (c *Config) includeFile(file string, seen map[string]bool) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var included includedConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&included); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	for name, group := range included.TargetGroups {
+		if _, ok := c.TargetGroups[name]; ok {
+			return fmt.Errorf("target group %s is already defined", name)
+		}
+		if c.TargetGroups == nil {
+			c.TargetGroups = map[string][]Target{}
+		}
+		c.TargetGroups[name] = group
+	}
+	for name, versions := range included.Schemas {
+		if _, ok := c.Schemas[name]; ok {
+			return fmt.Errorf("schema %s is already defined", name)
+		}
+		if c.Schemas == nil {
+			c.Schemas = map[string][]SchemaVersion{}
+		}
+		c.Schemas[name] = versions
+	}
+	c.Dispatch = append(c.Dispatch, included.Dispatch...)
+	return c.includeFiles(filepath.Dir(file), included.Include, seen)
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// JWEConfig encrypts payloads forwarded to a target as a compact JWE
+type JWEConfig struct {
+	// PublicKey is a PEM encoded RSA or EC public key, or use PublicKeyFile
+	PublicKey     string `yaml:"PublicKey"`
+	PublicKeyFile string `yaml:"PublicKeyFile"`
+	// KeyAlgorithm defaults to RSA-OAEP-256 for RSA and ECDH-ES+A256KW for
+	// EC keys
+	KeyAlgorithm string `yaml:"KeyAlgorithm"`
+	// ContentEncryption defaults to A256GCM
+	ContentEncryption string `yaml:"ContentEncryption"`
+
+	key interface{}
+}
+
+// prepare parses the public key and fills in default algorithms
+func (j *JWEConfig) prepare() error {
+	data := []byte(j.PublicKey)
+	if j.PublicKeyFile != "" {
+		var err error
+		data, err = os.ReadFile(j.PublicKeyFile)
+		if err != nil {
+			return fmt.Errorf("jwe: %w", err)
+		}
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("jwe: no PEM encoded public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("jwe: %w", err)
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if j.KeyAlgorithm == "" {
+			j.KeyAlgorithm = string(jose.RSA_OAEP_256)
+		}
+	case *ecdsa.PublicKey:
+		if j.KeyAlgorithm == "" {
+			j.KeyAlgorithm = string(jose.ECDH_ES_A256KW)
+		}
+	default:
+		return fmt.Errorf("jwe: unsupported key type %T", key)
+	}
+	if j.ContentEncryption == "" {
+		j.ContentEncryption = string(jose.A256GCM)
+	}
+	j.key = key
+
+	// Fail on unsupported algorithms at load time rather than on delivery
+	_, err = j.encrypter("application/json")
+	return err
+}
+
+func (j *JWEConfig) encrypter(contentType string) (jose.Encrypter, error) {
+	opts := (&jose.EncrypterOptions{}).WithContentType(jose.ContentType(contentType))
+	enc, err := jose.NewEncrypter(
+		jose.ContentEncryption(j.ContentEncryption),
+		jose.Recipient{Algorithm: jose.KeyAlgorithm(j.KeyAlgorithm), Key: j.key},
+		opts,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("jwe: %w", err)
+	}
+	return enc, nil
+}
+
+// encrypt wraps the payload in a compact JWE, the original content type is
+// kept in the cty header
+func (j *JWEConfig) encrypt(body []byte, contentType string) ([]byte, error) {
+	enc, err := j.encrypter(contentType)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := enc.Encrypt(body)
+	if err != nil {
+		return nil, fmt.Errorf("jwe: %w", err)
+	}
+	compact, err := obj.CompactSerialize()
+	if err != nil {
+		return nil, fmt.Errorf("jwe: %w", err)
+	}
+	return []byte(compact), nil
+}
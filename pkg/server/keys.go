@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/keygen"
+)
+
+// KeyConfig selects how event keys are generated. Downstream systems
+// deduplicate by the keys, so pick the format they expect.
+type KeyConfig struct {
+	// Strategy is timestamp (default, webhook-<slug>-<unix seconds>),
+	// ulid, uuidv7, snowflake, template or one registered with
+	// keygen.Register
+	Strategy string `yaml:"Strategy"`
+	// Template renders keys of the template strategy, see
+	// keygen.TemplateData
+	Template string `yaml:"Template"`
+	// NodeID distinguishes instances generating snowflake IDs, 0-1023.
+	// KEY_NODE_ID overrides it so replicas can share the config.
+	NodeID int64 `yaml:"NodeID"`
+
+	generator keygen.Generator
+}
+
+// keyGenerator generates the keys of events
+var keyGenerator = keygen.Default
+
+// activeKeys are the settings keyGenerator was created from
+var activeKeys KeyConfig
+
+// sameSettings reports whether the configs select the same generator
+func (c *KeyConfig) sameSettings(other KeyConfig) bool {
+	return other.generator != nil && c.Strategy == other.Strategy &&
+		c.Template == other.Template && c.NodeID == other.NodeID
+}
+
+// prepare creates the generator of the strategy
+func (c *KeyConfig) prepare() error {
+	nodeID := c.NodeID
+	if v := os.Getenv("KEY_NODE_ID"); v != "" {
+		var err error
+		if nodeID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return fmt.Errorf("keys: invalid KEY_NODE_ID %q", v)
+		}
+	}
+	generator, err := keygen.New(c.Strategy, keygen.Options{Template: c.Template, NodeID: nodeID})
+	if err != nil {
+		return fmt.Errorf("keys: %w", err)
+	}
+	c.generator = generator
+	return nil
+}
+
+// eventKey generates the key of an event with the configured strategy,
+// falling back to webhook-<slugified-path>-<unix-timestamp> if it fails
+func eventKey(path string, rule *DispatchRule) string {
+	return eventKeyAt(path, rule, time.Now())
+}
+
+// eventKeyAt generates the key of an event of the time, e.g. a backfilled
+// event
+func eventKeyAt(path string, rule *DispatchRule, now time.Time) string {
+	key, err := keyGenerator.Generate(keygen.Event{
+		Path: path,
+		Slug: slugify(path),
+		Rule: slugify(rule.label()),
+		Time: now,
+	})
+	if err != nil {
+		log.Printf("Failed to generate key for %s, using the default: %v", path, err)
+		return fmt.Sprintf("webhook-%s-%d", slugify(path), now.Unix())
+	}
+	return key
+}
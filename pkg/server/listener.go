@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// listenFdsStart is the first file descriptor passed by a supervisor
+// using the systemd socket activation protocol
+const listenFdsStart = 3
+
+// listenAddrs returns the addresses to listen on: the comma separated
+// LISTEN addresses (e.g. [::]:8000,127.0.0.1:9000) if set, otherwise all
+// interfaces on PORT (default 8000). An address without a host like :8000
+// or [::]:8000 is dual-stack, 0.0.0.0:8000 is IPv4 only.
+func listenAddrs() ([]string, error) {
+	var addrs []string
+	for _, addr := range strings.Split(os.Getenv("LISTEN"), ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid LISTEN address %q: %w", addr, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) > 0 {
+		return addrs, nil
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8000"
+	}
+	return []string{fmt.Sprintf(":%s", port)}, nil
+}
+
+// listen returns the server listeners. Sockets passed by a supervisor
+// (LISTEN_FDS) are used if present, so the listening sockets survive binary
+// restarts. Otherwise a new socket is bound for each address, with
+// SO_REUSEPORT when REUSE_PORT=1 so a new process can bind while the old
+// one drains.
+func listen(addrs []string) ([]net.Listener, error) {
+	if lns, err := inheritedListeners(); lns != nil || err != nil {
+		return lns, err
+	}
+
+	lc := net.ListenConfig{}
+	if os.Getenv("REUSE_PORT") == "1" {
+		lc.Control = reusePortControl
+	}
+	lns := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		if lc.Control != nil {
+			log.Printf("Binding %s with SO_REUSEPORT", addr)
+		}
+		ln, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			closeListeners(lns)
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// inheritedListeners returns the listeners passed via LISTEN_FDS, or nil
+func inheritedListeners() ([]net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+
+	lns := make([]net.Listener, 0, n)
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "listener")
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			closeListeners(lns)
+			return nil, fmt.Errorf("failed to use inherited listener %d: %w", fd, err)
+		}
+		log.Printf("Using inherited listener on %s", ln.Addr())
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// closeListeners closes listeners bound before a later bind failed
+func closeListeners(lns []net.Listener) {
+	for _, ln := range lns {
+		ln.Close()
+	}
+}
+
+// serverProtocols returns the protocols of the listener: HTTP/1.1 and,
+// unless HTTP2=0, HTTP/2 over TLS. H2C=1 also accepts HTTP/2 without TLS
+// from clients with prior knowledge, for use behind proxies and within
+// clusters.
+func serverProtocols() *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(os.Getenv("HTTP2") != "0")
+	if os.Getenv("H2C") == "1" {
+		protocols.SetUnencryptedHTTP2(true)
+		log.Printf("Accepting HTTP/2 without TLS (h2c)")
+	}
+	return protocols
+}
+
+// serve serves HTTP on the listeners until SIGINT or SIGTERM, then stops
+// accepting connections and waits for running requests and deliveries.
+// TLS is served with the certificate and key at TLS_CERT_FILE and
+// TLS_KEY_FILE if set, and with HTTP3=1 also HTTP/3 on the UDP port of
+// each listener.
+func serve(lns []net.Listener, handler http.Handler, shutdownTimeout time.Duration) error {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if (certFile == "") != (keyFile == "") {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	http3Enabled := os.Getenv("HTTP3") == "1"
+	if http3Enabled && certFile == "" {
+		return fmt.Errorf("HTTP3 requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	if certFile != "" {
+		log.Printf("Serving TLS with certificate %s", certFile)
+	}
+
+	protocols := serverProtocols()
+	errCh := make(chan error, 2*len(lns))
+	var srvs []*http.Server
+	var h3s []*http3.Server
+	for _, ln := range lns {
+		srv := &http.Server{Handler: handler, Protocols: protocols}
+		srv.RegisterOnShutdown(closeStreams)
+		srvs = append(srvs, srv)
+		if http3Enabled {
+			var h3 *http3.Server
+			h3, srv.Handler = newHTTP3Server(ln.Addr().String(), handler)
+			h3s = append(h3s, h3)
+			go func() {
+				log.Printf("Serving HTTP/3 on udp %s (experimental)", ln.Addr())
+				errCh <- h3.ListenAndServeTLS(certFile, keyFile)
+			}()
+		}
+		go func() {
+			if certFile != "" {
+				errCh <- srv.ServeTLS(ln, certFile, keyFile)
+				return
+			}
+			errCh <- srv.Serve(ln)
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-errCh:
+		return err
+	case sig := <-signals:
+		log.Printf("Received %s, shutting down", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for _, srv := range srvs {
+		if err := srv.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	}
+	for _, h3 := range h3s {
+		if err := h3.Shutdown(ctx); err != nil {
+			log.Printf("Failed to shut down HTTP/3: %v", err)
+		}
+	}
+
+	// Send digests now rather than lose the events accumulated
+	flushDigests()
+
+	// Wait for deliveries still in progress or queued
+	deliveries.draining.Store(true)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for activity.inFlight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Printf("Shutdown timeout, %d deliveries still in progress", activity.inFlight.Load())
+			return nil
+		case <-ticker.C:
+		}
+	}
+
+	log.Printf("Server stopped")
+	return nil
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rateWindowSeconds is the window the ingestion rate is averaged over
+const rateWindowSeconds = 60
+
+// rateWindow counts events per second over the last minute
+type rateWindow struct {
+	mu      sync.Mutex
+	counts  [rateWindowSeconds]int64
+	seconds [rateWindowSeconds]int64
+}
+
+var ingestRate = &rateWindow{}
+
+// add counts an event in the current second
+func (w *rateWindow) add() {
+	now := time.Now().Unix()
+	i := now % rateWindowSeconds
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.seconds[i] != now {
+		w.seconds[i] = now
+		w.counts[i] = 0
+	}
+	w.counts[i]++
+}
+
+// total returns the number of events counted in the window
+func (w *rateWindow) total() int64 {
+	now := time.Now().Unix()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var total int64
+	for i, second := range w.seconds {
+		if now-second < rateWindowSeconds {
+			total += w.counts[i]
+		}
+	}
+	return total
+}
+
+// LoadReport summarizes ingestion rate and delivery backlog for
+// autoscalers, e.g. the KEDA metrics-api scaler
+type LoadReport struct {
+	// IngestionRate is the average number of events received per second
+	// over the last minute
+	IngestionRate float64 `json:"ingestion_rate"`
+	// ReceivedLastMinute is the number of events received in the last minute
+	ReceivedLastMinute int64 `json:"received_last_minute"`
+	// Backlog is the number of deliveries queued or in progress
+	Backlog int64 `json:"backlog"`
+	QueueStats
+}
+
+// currentLoad returns the current load report
+func currentLoad() LoadReport {
+	received := ingestRate.total()
+	return LoadReport{
+		IngestionRate:      float64(received) / rateWindowSeconds,
+		ReceivedLastMinute: received,
+		Backlog:            activity.inFlight.Load(),
+		QueueStats:         deliveries.snapshot(),
+	}
+}
+
+// handleLoad returns the load report
+func handleLoad(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, currentLoad())
+}
+
+func init() {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "webhook_dispatcher_ingestion_rate",
+		Help: "Events received per second averaged over the last minute",
+	}, func() float64 {
+		return float64(ingestRate.total()) / rateWindowSeconds
+	}))
+}
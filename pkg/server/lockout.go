@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+var (
+	authFailuresCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "webhook_dispatcher_admin_auth_failures_total",
+		Help: "Number of failed admin API authentication attempts",
+	})
+	authLockoutsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "webhook_dispatcher_admin_lockouts_total",
+		Help: "Number of clients locked out of the admin API",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(authFailuresCounter, authLockoutsCounter)
+}
+
+// LockoutConfig limits admin API requests per client IP and locks out
+// clients failing authentication repeatedly
+type LockoutConfig struct {
+	// Rate is the number of admin API requests per second allowed per
+	// client IP, defaults to 10
+	Rate float64 `yaml:"Rate"`
+	// Burst is the number of requests allowed at once, defaults to 2*Rate
+	Burst int `yaml:"Burst"`
+	// MaxFailures is the number of failed authentications within Window
+	// locking a client out, defaults to 10, -1 disables the lockout
+	MaxFailures int `yaml:"MaxFailures"`
+	// Window defaults to 5m
+	Window time.Duration `yaml:"Window"`
+	// Duration of a lockout, defaults to 15m
+	Duration time.Duration `yaml:"Duration"`
+}
+
+// prepare validates the lockout settings and sets defaults
+func (l *LockoutConfig) prepare() error {
+	if l.Rate < 0 || l.Burst < 0 || l.Window < 0 || l.Duration < 0 || l.MaxFailures < -1 {
+		return fmt.Errorf("lockout: settings must not be negative")
+	}
+	if l.Rate == 0 {
+		l.Rate = 10
+	}
+	if l.Burst == 0 {
+		l.Burst = max(1, int(2*l.Rate))
+	}
+	if l.MaxFailures == 0 {
+		l.MaxFailures = 10
+	}
+	if l.Window == 0 {
+		l.Window = 5 * time.Minute
+	}
+	if l.Duration == 0 {
+		l.Duration = 15 * time.Minute
+	}
+	return nil
+}
+
+// authClient is the request and failure history of a client IP
+type authClient struct {
+	limiter     *rate.Limiter
+	failures    []time.Time
+	lockedUntil time.Time
+	seen        time.Time
+}
+
+// authLimiter tracks admin API clients by IP
+type authLimiter struct {
+	config  LockoutConfig
+	mu      sync.Mutex
+	clients map[string]*authClient
+}
+
+func newAuthLimiter(config LockoutConfig) *authLimiter {
+	return &authLimiter{config: config, clients: map[string]*authClient{}}
+}
+
+// client returns the state of the IP, dropping clients idle for longer
+// than the window and lockout when the map grows
+func (l *authLimiter) client(ip string, now time.Time) *authClient {
+	if len(l.clients) >= 1024 {
+		idle := max(l.config.Window, l.config.Duration)
+		for key, c := range l.clients {
+			if now.Sub(c.seen) > idle && now.After(c.lockedUntil) {
+				delete(l.clients, key)
+			}
+		}
+	}
+	c, ok := l.clients[ip]
+	if !ok {
+		c = &authClient{limiter: rate.NewLimiter(rate.Limit(l.config.Rate), l.config.Burst)}
+		l.clients[ip] = c
+	}
+	c.seen = now
+	return c
+}
+
+// allow reports whether the client may attempt a request, or how long it
+// has to wait
+func (l *authLimiter) allow(ip string) (time.Duration, bool) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := l.client(ip, now)
+	if now.Before(c.lockedUntil) {
+		return c.lockedUntil.Sub(now), false
+	}
+	if !c.limiter.AllowN(now, 1) {
+		return time.Second, false
+	}
+	return 0, true
+}
+
+// fail records a failed authentication, reporting whether it locked the
+// client out
+func (l *authLimiter) fail(ip string) bool {
+	authFailuresCounter.Inc()
+	if l.config.MaxFailures < 0 {
+		return false
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := l.client(ip, now)
+	recent := c.failures[:0]
+	for _, t := range c.failures {
+		if now.Sub(t) < l.config.Window {
+			recent = append(recent, t)
+		}
+	}
+	c.failures = append(recent, now)
+	if len(c.failures) < l.config.MaxFailures {
+		return false
+	}
+	c.failures = nil
+	c.lockedUntil = now.Add(l.config.Duration)
+	return true
+}
+
+// lockOut records the lockout of the client in the audit log and notifies
+// about it
+func (l *authLimiter) lockOut(r *http.Request, ip string) {
+	authLockoutsCounter.Inc()
+	log.Printf("Audit: locked out %s from the admin API for %s after %d failed authentications (last: %s %s)",
+		ip, l.config.Duration, l.config.MaxFailures, r.Method, r.URL.Path)
+	notify(Notification{
+		Event: NotifyAdminLockout,
+		IP:    ip,
+		Error: fmt.Sprintf("%d failed authentications", l.config.MaxFailures),
+	})
+}
+
+// writeLockedOut responds 429 to clients over the limit or locked out
+func writeLockedOut(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second).Seconds())))
+	writeError(w, http.StatusTooManyRequests, ErrCodeRateLimited, "Too many admin API requests, try again later")
+}
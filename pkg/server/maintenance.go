@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sikalabs/webhook-dispatcher/pkg/cron"
+)
+
+// maxMaintenanceDuration bounds a maintenance window, deliveries are held
+// in memory meanwhile
+const maxMaintenanceDuration = 24 * time.Hour
+
+var maintenanceHeldGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "webhook_dispatcher_maintenance_held",
+	Help: "Number of deliveries held until the maintenance window of their target ends",
+})
+
+func init() {
+	prometheus.MustRegister(maintenanceHeldGauge)
+}
+
+// MaintenanceWindow is a recurring period a target is unavailable in,
+// deliveries to it are held until the window ends instead of failing
+type MaintenanceWindow struct {
+	// Schedule is a cron expression of the window starts, e.g. "0 2 * * *"
+	// for 02:00 every day
+	Schedule string `yaml:"Schedule"`
+	// Duration of the window, at most 24h
+	Duration time.Duration `yaml:"Duration"`
+	// TimeZone is an IANA time zone name, defaults to the local time zone
+	TimeZone string `yaml:"TimeZone"`
+
+	schedule *cron.Schedule
+	location *time.Location
+}
+
+// prepare parses the schedule
+func (m *MaintenanceWindow) prepare() error {
+	schedule, err := cron.Parse(m.Schedule)
+	if err != nil {
+		return fmt.Errorf("invalid Schedule: %w", err)
+	}
+	m.schedule = schedule
+	if m.Duration <= 0 || m.Duration > maxMaintenanceDuration {
+		return fmt.Errorf("invalid Duration %s, must be positive and at most %s", m.Duration, maxMaintenanceDuration)
+	}
+	m.location = time.Local
+	if m.TimeZone != "" {
+		if m.location, err = time.LoadLocation(m.TimeZone); err != nil {
+			return fmt.Errorf("invalid TimeZone: %w", err)
+		}
+	}
+	return nil
+}
+
+// end returns the end of the window the time falls into, if any
+func (m *MaintenanceWindow) end(t time.Time) (time.Time, bool) {
+	start, ok := m.schedule.LastWithin(t.In(m.location), m.Duration)
+	if !ok {
+		return time.Time{}, false
+	}
+	return start.Add(m.Duration), true
+}
+
+// maintenanceEnd returns when the target leaves maintenance, following
+// overlapping and adjacent windows, or false if it is available at the time
+func (t *Target) maintenanceEnd(now time.Time) (time.Time, bool) {
+	end := now
+	for {
+		extended := false
+		for i := range t.Maintenance {
+			if e, ok := t.Maintenance[i].end(end); ok && e.After(end) {
+				end, extended = e, true
+			}
+		}
+		if !extended || end.Sub(now) > maxMaintenanceDuration {
+			return end, !end.Equal(now)
+		}
+	}
+}
+
+// awaitMaintenance waits until the target is out of maintenance
+func (t *Target) awaitMaintenance(ctx context.Context) error {
+	if len(t.Maintenance) == 0 {
+		return nil
+	}
+	end, ok := t.maintenanceEnd(time.Now())
+	if !ok {
+		return nil
+	}
+	log.Printf("Target %s is in maintenance, holding delivery until %s", t.URL, end.Format(time.RFC3339))
+	maintenanceHeldGauge.Inc()
+	defer maintenanceHeldGauge.Dec()
+
+	timer := time.NewTimer(time.Until(end))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
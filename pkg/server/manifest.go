@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/manifest"
+)
+
+// deliveryManifests records signed manifests of delivery attempts, nil
+// unless MANIFEST_SIGNING_KEY is set. Manifests are chained in the log
+// file and stored with the delivery records when deliveries are tracked.
+var deliveryManifests *manifest.Log
+
+// openManifestLog opens the manifest log at MANIFEST_LOG (default
+// delivery-manifests.jsonl) signed with the Ed25519 key at
+// MANIFEST_SIGNING_KEY
+func openManifestLog() (*manifest.Log, error) {
+	keyPath := os.Getenv("MANIFEST_SIGNING_KEY")
+	if keyPath == "" {
+		return nil, nil
+	}
+	key, err := manifest.LoadPrivateKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	path := os.Getenv("MANIFEST_LOG")
+	if path == "" {
+		path = "delivery-manifests.jsonl"
+	}
+	return manifest.Open(path, key)
+}
+
+// recordManifest records the manifest of a delivery attempt of the payload
+// and returns it, nil if manifests are disabled or recording failed. The
+// digest is taken of the payload as stored, before conversion and
+// encryption for the target, so it can be checked against the event.
+func recordManifest(target string, body payload, result DeliveryResult) *manifest.Manifest {
+	if deliveryManifests == nil {
+		return nil
+	}
+	var deliveryErr error
+	if result.Error != "" {
+		deliveryErr = errors.New(result.Error)
+	}
+	m, err := deliveryManifests.Record(target, body.sha256(), result.Status, deliveryErr)
+	if err != nil {
+		log.Printf("Failed to record delivery manifest for %s: %v", target, err)
+		return nil
+	}
+	return &m
+}
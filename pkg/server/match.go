@@ -0,0 +1,108 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// isGlob reports whether a rule path contains glob wildcards
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// matchPath matches a request path against a rule path. Rule paths may use
+// glob wildcards: "*" matches within one path segment, "**" matches any
+// number of segments, e.g. /github/* or /hooks/**.
+func matchPath(pattern string, p string) bool {
+	if !isGlob(pattern) {
+		return pattern == p
+	}
+	return matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(strings.Trim(p, "/"), "/"))
+}
+
+func matchSegments(pattern []string, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try to match the rest of the pattern at every position
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		segments = segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// paramNameRegexp restricts path parameter names to valid regexp group
+// names
+var paramNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// hasPathParams reports whether a rule path has :name parameters
+func hasPathParams(pattern string) bool {
+	for _, segment := range strings.Split(pattern, "/") {
+		if strings.HasPrefix(segment, ":") {
+			return true
+		}
+	}
+	return false
+}
+
+// compilePathParams compiles a rule path with :name parameters, each
+// matching one path segment, into a regular expression with named groups.
+// Other segments are literal, * matches one segment and a trailing **
+// any number of them.
+func compilePathParams(pattern string) (*regexp.Regexp, error) {
+	segments := strings.Split(strings.Trim(pattern, "/"), "/")
+	var expr strings.Builder
+	expr.WriteString("^")
+	seen := map[string]bool{}
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			name := segment[1:]
+			if !paramNameRegexp.MatchString(name) {
+				return nil, fmt.Errorf("invalid path parameter %q", segment)
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("duplicate path parameter %q", segment)
+			}
+			seen[name] = true
+			fmt.Fprintf(&expr, "/(?P<%s>[^/]+)", name)
+		case segment == "**" && i == len(segments)-1:
+			expr.WriteString("(?:/.*)?")
+		case segment == "*":
+			expr.WriteString("/[^/]*")
+		case isGlob(segment):
+			return nil, fmt.Errorf("wildcard segment %q cannot be combined with path parameters", segment)
+		default:
+			expr.WriteString("/" + regexp.QuoteMeta(segment))
+		}
+	}
+	expr.WriteString("/?$")
+	return regexp.Compile(expr.String())
+}
+
+// validateGlob checks the glob syntax of a rule path
+func validateGlob(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		if segment == "**" {
+			continue
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
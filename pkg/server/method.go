@@ -0,0 +1,44 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// ForwardMethodOriginal forwards with the method of the incoming request
+const ForwardMethodOriginal = "original"
+
+// methodHeader passes the method of the incoming request to deliveries
+const methodHeader = "X-Webhook-Method"
+
+// methodRegexp matches HTTP method tokens
+var methodRegexp = regexp.MustCompile(`^[A-Z]+$`)
+
+// prepareForwardMethod validates a ForwardMethod, returning it normalized
+func prepareForwardMethod(method string) (string, error) {
+	if method == "" || strings.EqualFold(method, ForwardMethodOriginal) {
+		return strings.ToLower(method), nil
+	}
+	method = strings.ToUpper(method)
+	if !methodRegexp.MatchString(method) {
+		return "", fmt.Errorf("invalid ForwardMethod %q", method)
+	}
+	return method, nil
+}
+
+// requestMethod returns the method of requests sent to the target, POST
+// unless configured otherwise
+func (t *Target) requestMethod(headers http.Header) string {
+	switch t.ForwardMethod {
+	case "":
+		return http.MethodPost
+	case ForwardMethodOriginal:
+		if method := headers.Get(methodHeader); method != "" {
+			return method
+		}
+		return http.MethodPost
+	}
+	return t.ForwardMethod
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"log"
+	"mime"
+	"regexp"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+)
+
+var (
+	redisEventsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_dispatcher_redis_events_total",
+		Help: "Total number of events stored in Redis",
+	})
+	mongodbEventsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_dispatcher_mongodb_events_total",
+		Help: "Total number of events stored in MongoDB",
+	})
+	forwardsInFlightGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_dispatcher_forwards_in_flight",
+		Help: "Number of deliveries to targets currently in progress",
+	})
+	payloadSizeHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "webhook_dispatcher_payload_size_bytes",
+		Help:    "Size of received webhook payloads in bytes",
+		Buckets: prometheus.ExponentialBuckets(128, 4, 8),
+	}, []string{"path"})
+	contentTypeCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_dispatcher_content_type_total",
+		Help: "Number of received webhooks by content type",
+	}, []string{"path", "content_type"})
+	droppedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_dispatcher_dropped_total",
+		Help: "Number of webhooks discarded by rules with Drop",
+	}, []string{"path"})
+	signatureVerificationsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_dispatcher_signature_verifications_total",
+		Help: "Number of signature verifications by matching secret, secret is empty on failure",
+	}, []string{"path", "secret"})
+)
+
+// Last known event counts, -1 when the backend is not configured
+var redisEventCount, mongodbEventCount atomic.Int64
+
+func init() {
+	prometheus.MustRegister(redisEventsGauge)
+	prometheus.MustRegister(mongodbEventsGauge)
+	prometheus.MustRegister(forwardsInFlightGauge)
+	prometheus.MustRegister(payloadSizeHistogram)
+	prometheus.MustRegister(contentTypeCounter)
+	prometheus.MustRegister(signatureVerificationsCounter)
+	prometheus.MustRegister(droppedCounter)
+}
+
+// unmatchedPathLabel is used as the path label for webhooks that match
+// no dispatch rule, to keep metric cardinality bounded
+const unmatchedPathLabel = "_unmatched"
+
+// knownContentTypes are the media types used as content type labels as
+// they are, see contentTypeLabel
+var knownContentTypes = map[string]bool{
+	"none":                              true,
+	"invalid":                           true,
+	"application/json":                  true,
+	"application/cloudevents+json":      true,
+	"application/x-www-form-urlencoded": true,
+	"multipart/form-data":               true,
+	"application/xml":                   true,
+	"text/xml":                          true,
+	"text/plain":                        true,
+	"application/octet-stream":          true,
+	"application/msgpack":               true,
+	"application/x-protobuf":            true,
+}
+
+// otherContentTypeLabel is the content type label of payloads with any
+// other media type
+const otherContentTypeLabel = "other"
+
+// contentTypeLabel returns the content type label of a payload for the
+// rule: the media type if it is known or listed in the rule's
+// MatchContentType, the matching type/* entry, or other. The client
+// controls the header, so arbitrary values are not used as labels.
+func contentTypeLabel(rule *DispatchRule, contentType string) string {
+	mediaType := normalizeContentType(contentType)
+	if knownContentTypes[mediaType] {
+		return mediaType
+	}
+	if rule != nil {
+		if want, ok := rule.matchingContentType(mediaType); ok {
+			return want
+		}
+	}
+	return otherContentTypeLabel
+}
+
+// observePayload records payload size and content type of a received webhook
+func observePayload(rule *DispatchRule, pathLabel string, contentType string, size int) {
+	contentType = contentTypeLabel(rule, contentType)
+	payloadSizeHistogram.WithLabelValues(pathLabel).Observe(float64(size))
+	contentTypeCounter.WithLabelValues(pathLabel, contentType).Inc()
+	payloadStats.observe(pathLabel, contentType, size)
+}
+
+// invalidLabelChars are replaced in rule label names to form metric label
+// names
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// ruleLabelsGauge is the registered rule labels metric, replaced when the
+// rules change
+var ruleLabelsGauge *prometheus.GaugeVec
+
+// registerRuleLabels exports the labels of the rules as the constant
+// webhook_dispatcher_rule_labels metric, with a label_<name> label for
+// each label name used by any rule
+func registerRuleLabels(config *Config) {
+	if ruleLabelsGauge != nil {
+		prometheus.Unregister(ruleLabelsGauge)
+		ruleLabelsGauge = nil
+	}
+	rules := append([]*DispatchRule{}, config.Default)
+	for i := range config.Dispatch {
+		rules = append(rules, &config.Dispatch[i])
+	}
+
+	seen := map[string]bool{}
+	var names []string
+	for _, rule := range rules {
+		if rule == nil {
+			continue
+		}
+		for name := range rule.Labels {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+
+	labelNames := []string{"rule"}
+	for _, name := range names {
+		labelNames = append(labelNames, "label_"+invalidLabelChars.ReplaceAllString(name, "_"))
+	}
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webhook_dispatcher_rule_labels",
+		Help: "Labels of dispatch rules, always 1",
+	}, labelNames)
+	for _, rule := range rules {
+		if rule == nil {
+			continue
+		}
+		values := []string{rule.label()}
+		for _, name := range names {
+			values = append(values, rule.Labels[name])
+		}
+		gauge.WithLabelValues(values...).Set(1)
+	}
+	if err := prometheus.Register(gauge); err != nil {
+		log.Printf("Failed to register rule labels metric: %v", err)
+		return
+	}
+	ruleLabelsGauge = gauge
+}
+
+// normalizeContentType strips parameters (like charset) from a content type
+func normalizeContentType(contentType string) string {
+	if contentType == "" {
+		return "none"
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "invalid"
+	}
+	return mediaType
+}
+
+// updateMetrics periodically updates Prometheus metrics
+func updateMetrics() {
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	// Update immediately on start
+	updateMetricsOnce(connectedRedis.Load(), connectedMongoDB.Load())
+
+	for range ticker.C {
+		updateMetricsOnce(connectedRedis.Load(), connectedMongoDB.Load())
+	}
+}
+
+func updateMetricsOnce(redisStore *storage.RedisStorage, mongoStore *storage.MongoDBStorage) {
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+
+	if redisStore != nil {
+		count, err := redisStore.Count(ctx)
+		if err != nil {
+			log.Printf("Failed to get Redis event count: %v", err)
+		} else {
+			redisEventsGauge.Set(float64(count))
+			redisEventCount.Store(count)
+		}
+	} else {
+		redisEventsGauge.Set(-1)
+		redisEventCount.Store(-1)
+	}
+
+	if mongoStore != nil {
+		count, err := mongoStore.Count(ctx)
+		if err != nil {
+			log.Printf("Failed to get MongoDB event count: %v", err)
+		} else {
+			mongodbEventsGauge.Set(float64(count))
+			mongodbEventCount.Store(count)
+		}
+	} else {
+		mongodbEventsGauge.Set(-1)
+		mongodbEventCount.Store(-1)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TargetTLSConfig configures TLS of connections to a target, for internal
+// services requiring mutual TLS or signed by a private CA
+type TargetTLSConfig struct {
+	// CertFile and KeyFile are the PEM encoded client certificate and key
+	// presented to the target
+	CertFile string `yaml:"CertFile"`
+	KeyFile  string `yaml:"KeyFile"`
+	// CAFile is a PEM bundle of the CAs trusted to sign the target
+	// certificate, defaults to the system roots
+	CAFile string `yaml:"CAFile"`
+
+	config *tls.Config
+}
+
+// prepare loads the client certificate and the CA bundle
+func (c *TargetTLSConfig) prepare() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("tls: CertFile and KeyFile must be set together")
+	}
+	c.config = &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+		c.config.Certificates = []tls.Certificate{cert}
+	}
+	if c.CAFile != "" {
+		data, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("tls: no certificates found in %s", c.CAFile)
+		}
+		c.config.RootCAs = pool
+	}
+	return nil
+}
+
+// cacheKey identifies transports sharing the TLS config
+func (c *TargetTLSConfig) cacheKey() string {
+	if c == nil {
+		return ""
+	}
+	return c.CertFile + "\x00" + c.KeyFile + "\x00" + c.CAFile
+}
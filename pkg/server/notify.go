@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Notification kinds
+const (
+	// NotifyDeliveryFailed is sent when a delivery failed on the target and
+	// all its fallbacks
+	NotifyDeliveryFailed = "delivery_failed"
+	// NotifyDeadLetter is sent when an event is moved to the dead letter
+	// queue
+	NotifyDeadLetter = "dlq"
+	// NotifyAdminLockout is sent when a client is locked out of the admin
+	// API after failed authentications
+	NotifyAdminLockout = "admin_lockout"
+)
+
+// NotificationConfig configures meta-notifications about failures of the
+// webhook pipeline, sent as JSON to a callback target
+type NotificationConfig struct {
+	// Target receives the notifications, it has no fallbacks and failed
+	// notifications are only logged
+	Target Target `yaml:"Target"`
+	// Events limits notifications to the listed kinds (delivery_failed,
+	// dlq, admin_lockout), defaults to all
+	Events []string `yaml:"Events"`
+}
+
+// Notification is the payload sent to the notification target
+type Notification struct {
+	Event  string    `json:"event"`
+	Time   time.Time `json:"time"`
+	Key    string    `json:"key,omitempty"`
+	Target string    `json:"target,omitempty"`
+	Status int       `json:"status,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	// IP is the client of admin_lockout notifications
+	IP string `json:"ip,omitempty"`
+}
+
+// notifications is the notification config in effect, nil when disabled
+var notifications *NotificationConfig
+
+// prepare validates the notification kinds and prepares the target
+func (n *NotificationConfig) prepare(outbound OutboundConfig) error {
+	for _, event := range n.Events {
+		if event != NotifyDeliveryFailed && event != NotifyDeadLetter && event != NotifyAdminLockout {
+			return fmt.Errorf("notifications: unknown event %q", event)
+		}
+	}
+	if n.Target.URL == "" {
+		return fmt.Errorf("notifications: Target is required")
+	}
+	if len(n.Target.Fallback) > 0 {
+		return fmt.Errorf("notifications: Target may not have fallbacks")
+	}
+	return n.Target.prepare(outbound)
+}
+
+// wants reports whether notifications of the kind are enabled
+func (n *NotificationConfig) wants(event string) bool {
+	return n != nil && (len(n.Events) == 0 || slices.Contains(n.Events, event))
+}
+
+// notify sends a notification in the background if its kind is enabled
+func notify(n Notification) {
+	if !notifications.wants(n.Event) {
+		return
+	}
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		log.Printf("Failed to encode %s notification: %v", n.Event, err)
+		return
+	}
+
+	target := notifications.Target
+	go func() {
+		defer recoverGoroutine("notification")
+		ctx, cancel := context.WithTimeout(context.Background(), target.timeout())
+		defer cancel()
+		headers := http.Header{"Content-Type": {"application/json"}}
+		if result := deliver(ctx, target, memoryPayload(body), headers); !result.OK() {
+			log.Printf("Failed to send %s notification to %s", n.Event, target.URL)
+		}
+	}()
+}
+
+// notifyDeliveryFailed notifies about a delivery failed on a target and its
+// fallbacks. Shadow targets do not notify.
+func notifyDeliveryFailed(target Target, result DeliveryResult) {
+	if target.shadow {
+		return
+	}
+	notify(Notification{
+		Event:  NotifyDeliveryFailed,
+		Target: target.URL,
+		Status: result.Status,
+		Error:  result.Error,
+	})
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	sessionCookie  = "wd_session"
+	stateCookie    = "wd_oidc_state"
+	sessionMaxAge  = 8 * time.Hour
+	oidcLoginPath  = "/auth/login"
+	oidcCallback   = "/auth/callback"
+	oidcLogoutPath = "/auth/logout"
+)
+
+// OIDCConfig configures single sign-on for the dashboard and admin API
+type OIDCConfig struct {
+	Issuer       string `yaml:"Issuer"`
+	ClientID     string `yaml:"ClientID"`
+	ClientSecret Secret `yaml:"ClientSecret"`
+	// RedirectURL is the external URL of /auth/callback
+	RedirectURL string `yaml:"RedirectURL"`
+	// Scopes requested in addition to openid, profile and email, some
+	// providers need e.g. groups to include the groups claim
+	Scopes []string `yaml:"Scopes"`
+	// GroupsClaim is the ID token claim listing the user groups, defaults
+	// to groups
+	GroupsClaim string `yaml:"GroupsClaim"`
+	// Groups maps allowed groups to roles, users in none of them are denied
+	Groups map[string]string `yaml:"Groups"`
+	// SessionKey signs session cookies, random per process when empty so
+	// sessions do not survive restarts or span replicas
+	SessionKey Secret `yaml:"SessionKey"`
+}
+
+// enabled reports whether OIDC login is configured
+func (o *OIDCConfig) enabled() bool {
+	return o.Issuer != ""
+}
+
+// prepare validates the configuration and resolves the secrets
+func (o *OIDCConfig) prepare() error {
+	if !o.enabled() {
+		return nil
+	}
+	if o.ClientID == "" || o.RedirectURL == "" {
+		return fmt.Errorf("oidc: ClientID and RedirectURL are required")
+	}
+	if len(o.Groups) == 0 {
+		return fmt.Errorf("oidc: Groups must allow at least one group")
+	}
+	for group, role := range o.Groups {
+		if _, ok := roleLevels[role]; !ok {
+			return fmt.Errorf("oidc: group %s: unknown role %q", group, role)
+		}
+	}
+	if o.GroupsClaim == "" {
+		o.GroupsClaim = "groups"
+	}
+	if err := o.ClientSecret.load(); err != nil {
+		return fmt.Errorf("oidc: client secret: %w", err)
+	}
+	if err := o.SessionKey.load(); err != nil {
+		return fmt.Errorf("oidc: session key: %w", err)
+	}
+	return nil
+}
+
+// oidcLogin implements the authorization code flow and session cookies
+type oidcLogin struct {
+	config   *OIDCConfig
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	key      []byte
+	secure   bool
+}
+
+// session is the signed content of the session cookie
+type session struct {
+	Name    string `json:"name"`
+	Role    string `json:"role"`
+	Expires int64  `json:"exp"`
+}
+
+// newOIDCLogin discovers the issuer and returns the login handler
+func newOIDCLogin(ctx context.Context, config *OIDCConfig) (*oidcLogin, error) {
+	provider, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+
+	key := []byte(config.SessionKey.Get())
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return &oidcLogin{
+		config: config,
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret.Get(),
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       append([]string{oidc.ScopeOpenID, "profile", "email"}, config.Scopes...),
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+		key:      key,
+		secure:   strings.HasPrefix(config.RedirectURL, "https://"),
+	}, nil
+}
+
+// register registers the login, callback and logout handlers
+func (o *oidcLogin) register(mux *http.ServeMux) {
+	mux.HandleFunc(oidcLoginPath, o.handleLogin)
+	mux.HandleFunc(oidcCallback, o.handleCallback)
+	mux.HandleFunc(oidcLogoutPath, o.handleLogout)
+}
+
+// handleLogin redirects to the identity provider, remembering the page to
+// return to in the state cookie
+func (o *oidcLogin) handleLogin(w http.ResponseWriter, r *http.Request) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, "Failed to start login")
+		return
+	}
+	state := base64.RawURLEncoding.EncodeToString(nonce)
+
+	redirect := r.URL.Query().Get("redirect")
+	if !localRedirect(redirect) {
+		redirect = "/dashboard"
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state + "|" + url.QueryEscape(redirect),
+		Path:     "/auth/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   o.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, o.oauth2.AuthCodeURL(state), http.StatusFound)
+}
+
+// localRedirect reports whether the redirect after login is a path on this
+// server. Browsers treat \ as /, so /\evil.example would leave the site.
+func localRedirect(redirect string) bool {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.Contains(redirect, `\`) {
+		return false
+	}
+	u, err := url.Parse(redirect)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
+
+// handleCallback exchanges the authorization code, checks the user groups
+// and starts a session
+func (o *oidcLogin) handleCallback(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeBadRequest, "Missing login state")
+		return
+	}
+	state, redirect, _ := strings.Cut(cookie.Value, "|")
+	if state == "" || r.URL.Query().Get("state") != state {
+		writeError(w, http.StatusBadRequest, ErrCodeBadRequest, "Invalid login state")
+		return
+	}
+	redirect, _ = url.QueryUnescape(redirect)
+
+	token, err := o.oauth2.Exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Failed to exchange authorization code")
+		log.Printf("OIDC code exchange failed: %v", err)
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Missing ID token")
+		return
+	}
+	idToken, err := o.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Invalid ID token")
+		log.Printf("OIDC ID token verification failed: %v", err)
+		return
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Invalid ID token claims")
+		return
+	}
+	name, _ := claims["email"].(string)
+	if name == "" {
+		name = idToken.Subject
+	}
+	role := o.role(claims)
+	if role == "" {
+		writeError(w, http.StatusForbidden, ErrCodeForbidden, "You are not a member of an allowed group")
+		log.Printf("OIDC login of %s denied, not in an allowed group", name)
+		return
+	}
+
+	o.setSession(w, session{Name: name, Role: role, Expires: time.Now().Add(sessionMaxAge).Unix()})
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/auth/", MaxAge: -1})
+	log.Printf("OIDC login of %s with role %s", name, role)
+	if !localRedirect(redirect) {
+		redirect = "/dashboard"
+	}
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// handleLogout ends the session
+func (o *oidcLogin) handleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	fmt.Fprintln(w, "Logged out")
+}
+
+// role returns the highest role granted by the user groups
+func (o *oidcLogin) role(claims map[string]interface{}) string {
+	var groups []string
+	switch v := claims[o.config.GroupsClaim].(type) {
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	case string:
+		groups = []string{v}
+	}
+
+	role := ""
+	for _, group := range groups {
+		if r, ok := o.config.Groups[group]; ok && roleLevels[r] > roleLevels[role] {
+			role = r
+		}
+	}
+	return role
+}
+
+// setSession sets the signed session cookie
+func (o *oidcLogin) setSession(w http.ResponseWriter, s session) {
+	data, _ := json.Marshal(s)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    payload + "." + o.sign(payload),
+		Path:     "/",
+		MaxAge:   int(sessionMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   o.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// session returns the valid session of the request, if any
+func (o *oidcLogin) session(r *http.Request) (session, bool) {
+	var s session
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return s, false
+	}
+	payload, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(o.sign(payload))) {
+		return s, false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || json.Unmarshal(data, &s) != nil {
+		return s, false
+	}
+	if time.Now().Unix() > s.Expires {
+		return s, false
+	}
+	return s, true
+}
+
+func (o *oidcLogin) sign(payload string) string {
+	mac := hmac.New(sha256.New, o.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sikalabs/webhook-dispatcher/version"
+)
+
+// OTLP/HTTP JSON encoding of metrics, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+// otlpCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE
+const otlpCumulative = 2
+
+type otlpKeyValue struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+type otlpDataPoint struct {
+	Attributes     []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnix  string         `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano   string         `json:"timeUnixNano"`
+	AsDouble       *float64       `json:"asDouble,omitempty"`
+	Count          string         `json:"count,omitempty"`
+	Sum            *float64       `json:"sum,omitempty"`
+	BucketCounts   []string       `json:"bucketCounts,omitempty"`
+	ExplicitBounds []float64      `json:"explicitBounds,omitempty"`
+}
+
+type otlpData struct {
+	AggregationTemporality int             `json:"aggregationTemporality,omitempty"`
+	IsMonotonic            bool            `json:"isMonotonic,omitempty"`
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpMetric struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Gauge       *otlpData `json:"gauge,omitempty"`
+	Sum         *otlpData `json:"sum,omitempty"`
+	Histogram   *otlpData `json:"histogram,omitempty"`
+}
+
+// pushOTLP sends the current metrics to an OTLP/HTTP endpoint
+func pushOTLP(endpoint string, instance string) error {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	start := strconv.FormatInt(startTime.UnixNano(), 10)
+	metrics := []otlpMetric{}
+	for _, family := range families {
+		if m, ok := convertFamily(family, start, now); ok {
+			metrics = append(metrics, m)
+		}
+	}
+
+	payload := map[string]interface{}{
+		"resourceMetrics": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpKeyValue{
+						otlpString("service.name", "webhook-dispatcher"),
+						otlpString("service.version", version.Version),
+						otlpString("service.instance.id", instance),
+					},
+				},
+				"scopeMetrics": []interface{}{
+					map[string]interface{}{
+						"scope":   map[string]string{"name": "webhook-dispatcher"},
+						"metrics": metrics,
+					},
+				},
+			},
+		},
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func otlpString(key string, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: map[string]string{"stringValue": value}}
+}
+
+// convertFamily converts a Prometheus metric family to an OTLP metric
+func convertFamily(family *dto.MetricFamily, start string, now string) (otlpMetric, bool) {
+	m := otlpMetric{Name: family.GetName(), Description: family.GetHelp()}
+	data := &otlpData{}
+
+	for _, metric := range family.GetMetric() {
+		point := otlpDataPoint{TimeUnixNano: now}
+		for _, label := range metric.GetLabel() {
+			point.Attributes = append(point.Attributes, otlpString(label.GetName(), label.GetValue()))
+		}
+
+		switch family.GetType() {
+		case dto.MetricType_GAUGE:
+			point.AsDouble = float64Ptr(metric.GetGauge().GetValue())
+		case dto.MetricType_COUNTER:
+			point.StartTimeUnix = start
+			point.AsDouble = float64Ptr(metric.GetCounter().GetValue())
+		case dto.MetricType_HISTOGRAM:
+			h := metric.GetHistogram()
+			point.StartTimeUnix = start
+			point.Count = strconv.FormatUint(h.GetSampleCount(), 10)
+			point.Sum = float64Ptr(h.GetSampleSum())
+			// Prometheus buckets are cumulative, OTLP buckets are not
+			var prev uint64
+			for _, b := range h.GetBucket() {
+				if math.IsInf(b.GetUpperBound(), 1) {
+					continue
+				}
+				point.ExplicitBounds = append(point.ExplicitBounds, b.GetUpperBound())
+				point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-prev, 10))
+				prev = b.GetCumulativeCount()
+			}
+			point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(h.GetSampleCount()-prev, 10))
+		default:
+			return m, false
+		}
+		data.DataPoints = append(data.DataPoints, point)
+	}
+
+	switch family.GetType() {
+	case dto.MetricType_GAUGE:
+		m.Gauge = data
+	case dto.MetricType_COUNTER:
+		data.AggregationTemporality = otlpCumulative
+		data.IsMonotonic = true
+		m.Sum = data
+	case dto.MetricType_HISTOGRAM:
+		data.AggregationTemporality = otlpCumulative
+		m.Histogram = data
+	}
+	return m, true
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
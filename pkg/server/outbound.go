@@ -0,0 +1,306 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// blockedByDefault are link-local and cloud metadata addresses targets may
+// not connect to unless AllowLinkLocal is set
+var blockedByDefault = mustParseCIDRs(
+	"169.254.0.0/16",
+	"fe80::/10",
+	"fd00:ec2::254/128",
+	"100.100.100.200/32",
+	"168.63.129.16/32",
+)
+
+// hostPolicy decides which destinations targets may connect to. Entries are
+// CIDRs, IP addresses or host names, where *.example.com matches subdomains.
+type hostPolicy struct {
+	allowHosts     []string
+	allowNets      []*net.IPNet
+	denyHosts      []string
+	denyNets       []*net.IPNet
+	allowLinkLocal bool
+	// transport is used with the HTTP/2 mode of the outbound config,
+	// targets with another mode or their own TLS config use transports,
+	// created on demand
+	transport  *http.Transport
+	http2      string
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+// HTTP/2 modes of requests sent to targets
+const (
+	// HTTP2Auto uses HTTP/2 with TLS targets negotiating it, HTTP/1.1
+	// otherwise
+	HTTP2Auto = "auto"
+	// HTTP2H2C uses HTTP/2 only, without TLS (h2c with prior knowledge)
+	// for http:// targets
+	HTTP2H2C = "h2c"
+	// HTTP2Off uses HTTP/1.1 only
+	HTTP2Off = "off"
+)
+
+// http2Protocols returns the protocols of an HTTP/2 mode
+func http2Protocols(mode string) (*http.Protocols, error) {
+	protocols := new(http.Protocols)
+	switch mode {
+	case "", HTTP2Auto:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	case HTTP2H2C:
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	case HTTP2Off:
+		protocols.SetHTTP1(true)
+	default:
+		return nil, fmt.Errorf("unknown HTTP2 mode %q, expected auto, h2c or off", mode)
+	}
+	return protocols, nil
+}
+
+// transportFor returns the transport for targets using the HTTP/2 mode,
+// "" being the mode of the outbound config, and the TLS config if not nil
+func (p *hostPolicy) transportFor(mode string, tlsConfig *TargetTLSConfig) (*http.Transport, error) {
+	if mode == "" {
+		mode = p.http2
+	}
+	if mode == p.http2 && tlsConfig == nil {
+		return p.transport, nil
+	}
+	protocols, err := http2Protocols(mode)
+	if err != nil {
+		return nil, err
+	}
+	key := mode + "\x00" + tlsConfig.cacheKey()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t, ok := p.transports[key]; ok {
+		return t, nil
+	}
+	t := p.transport.Clone()
+	t.Protocols = protocols
+	if tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig.config.Clone()
+	}
+	if p.transports == nil {
+		p.transports = map[string]*http.Transport{}
+	}
+	p.transports[key] = t
+	return t, nil
+}
+
+// newHostPolicy parses the allow and deny lists of the outbound config
+func newHostPolicy(o OutboundConfig) (*hostPolicy, error) {
+	p := &hostPolicy{allowLinkLocal: o.AllowLinkLocal}
+	var err error
+	if p.allowHosts, p.allowNets, err = parseHostEntries(o.Allow); err != nil {
+		return nil, fmt.Errorf("outbound Allow: %w", err)
+	}
+	if p.denyHosts, p.denyNets, err = parseHostEntries(o.Deny); err != nil {
+		return nil, fmt.Errorf("outbound Deny: %w", err)
+	}
+	p.transport = newPolicyTransport(p)
+	protocols, err := http2Protocols(o.HTTP2)
+	if err != nil {
+		return nil, fmt.Errorf("outbound: %w", err)
+	}
+	p.transport.Protocols = protocols
+	p.http2 = o.HTTP2
+	if p.http2 == "" {
+		p.http2 = HTTP2Auto
+	}
+	return p, nil
+}
+
+func parseHostEntries(entries []string) ([]string, []*net.IPNet, error) {
+	var hosts []string
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		if strings.Contains(entry, "/") {
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, nil, err
+			}
+			nets = append(nets, ipNet)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		if _, err := path.Match(entry, ""); err != nil {
+			return nil, nil, fmt.Errorf("invalid host pattern %q", entry)
+		}
+		hosts = append(hosts, strings.ToLower(entry))
+	}
+	return hosts, nets, nil
+}
+
+// allowed reports whether a host name resolved to ip may be connected to
+func (p *hostPolicy) allowed(host string, ip net.IP) bool {
+	host = strings.ToLower(host)
+	if matchHosts(p.denyHosts, host) || containsIP(p.denyNets, ip) {
+		return false
+	}
+	if !p.allowLinkLocal && containsIP(blockedByDefault, ip) {
+		return false
+	}
+	if len(p.allowHosts) == 0 && len(p.allowNets) == 0 {
+		return true
+	}
+	return matchHosts(p.allowHosts, host) || containsIP(p.allowNets, ip)
+}
+
+// pinnedHostKey is the context key of addresses a host was pinned to
+type pinnedHostKey struct{}
+
+// pinnedHost is a host resolved once for a delivery
+type pinnedHost struct {
+	host string
+	ips  []net.IP
+}
+
+// resolve looks up the addresses of a host the policy allows
+func (p *hostPolicy) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if p.allowed(host, addr.IP) {
+			ips = append(ips, addr.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("destination %s is not allowed by the outbound policy", host)
+	}
+	return ips, nil
+}
+
+// pin resolves the host once and returns a context making connections of
+// the delivery use the validated addresses, so a DNS answer changing
+// between validation and connection cannot redirect it
+func (p *hostPolicy) pin(ctx context.Context, host string, timeout time.Duration) (context.Context, error) {
+	lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ips, err := p.resolve(lookupCtx, host)
+	if err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, pinnedHostKey{}, pinnedHost{host: host, ips: ips}), nil
+}
+
+// dialContext connects to the first allowed address of the host, using the
+// addresses pinned for the delivery when the host matches, e.g. not after
+// a redirect to another host
+func (p *hostPolicy) dialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	if pinned, ok := ctx.Value(pinnedHostKey{}).(pinnedHost); ok && pinned.host == host {
+		ips = pinned.ips
+	} else if ips, err = p.resolve(ctx, host); err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	var lastErr error
+	for _, ip := range ips {
+		// Re-check in case the policy is stricter than at pinning time
+		if !p.allowed(host, ip) {
+			lastErr = fmt.Errorf("destination %s (%s) is not allowed by the outbound policy", host, ip)
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// newPolicyTransport returns an HTTP transport enforcing the policy.
+// Requests sent through a proxy from HTTP_PROXY/HTTPS_PROXY are checked
+// against both the proxy address and the target host, which the proxy
+// resolves again. Target hosts have to resolve here as well.
+func newPolicyTransport(p *hostPolicy) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = p.dialContext
+	t.Proxy = p.proxy
+	return t
+}
+
+// proxy returns the proxy of the request from the environment after
+// checking its target host against the policy, as the connection to the
+// proxy is only checked for the proxy address
+func (p *hostPolicy) proxy(req *http.Request) (*url.URL, error) {
+	proxy, err := http.ProxyFromEnvironment(req)
+	if err != nil || proxy == nil {
+		return proxy, err
+	}
+	host := req.URL.Hostname()
+	if pinned, ok := req.Context().Value(pinnedHostKey{}).(pinnedHost); ok && pinned.host == host {
+		return proxy, nil
+	}
+	if _, err := p.resolve(req.Context(), host); err != nil {
+		return nil, err
+	}
+	return proxy, nil
+}
+
+// defaultPolicy applies to targets not loaded from the config
+var defaultPolicy = func() *hostPolicy {
+	p := &hostPolicy{http2: HTTP2Auto}
+	p.transport = newPolicyTransport(p)
+	return p
+}()
+
+func matchHosts(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
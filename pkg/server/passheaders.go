@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// unpassableHeaders are managed by the HTTP client or the dispatcher and
+// cannot be passed through to targets
+var unpassableHeaders = []string{
+	"Connection", "Content-Length", "Content-Type", "Host", "Keep-Alive",
+	"Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// passHeaderNames returns the canonical names of the global and rule pass
+// through headers, without duplicates
+func passHeaderNames(global []string, rule []string) ([]string, error) {
+	var names []string
+	for _, name := range append(slices.Clone(global), rule...) {
+		name = http.CanonicalHeaderKey(name)
+		if slices.Contains(unpassableHeaders, name) {
+			return nil, fmt.Errorf("header %s cannot be passed through", name)
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// setPassHeaders sets the headers passed through to the target and its
+// fallbacks
+func (t *Target) setPassHeaders(names []string) {
+	t.passHeaders = names
+	for i := range t.Fallback {
+		t.Fallback[i].setPassHeaders(names)
+	}
+}
+
+// copyPassHeaders copies the incoming headers passed through to the target
+func (t *Target) copyPassHeaders(dst http.Header, src http.Header) {
+	for _, name := range t.passHeaders {
+		if values := src.Values(name); len(values) > 0 {
+			dst[name] = slices.Clone(values)
+		}
+	}
+}
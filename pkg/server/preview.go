@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// PreviewConfig controls how payloads appear in request logs and the admin
+// API, stored payloads are not affected
+type PreviewConfig struct {
+	// MaxBytes truncates previews, 0 means unlimited
+	MaxBytes int `yaml:"MaxBytes"`
+	// MaxArrayItems collapses JSON arrays to their first items, 0 keeps all
+	MaxArrayItems int `yaml:"MaxArrayItems"`
+	// Pretty indents JSON payloads
+	Pretty bool `yaml:"Pretty"`
+}
+
+// previewConfig is the preview policy in effect
+var previewConfig PreviewConfig
+
+// prepare validates the limits
+func (p PreviewConfig) prepare() error {
+	if p.MaxBytes < 0 || p.MaxArrayItems < 0 {
+		return fmt.Errorf("preview: limits must not be negative")
+	}
+	return nil
+}
+
+// render returns the preview of a payload. JSON payloads are collapsed and
+// indented as configured, other payloads are only truncated.
+func (p PreviewConfig) render(body []byte) string {
+	if p.MaxArrayItems > 0 || p.Pretty {
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err == nil {
+			if p.MaxArrayItems > 0 {
+				doc = collapseArrays(doc, p.MaxArrayItems)
+			}
+			var buf bytes.Buffer
+			enc := json.NewEncoder(&buf)
+			enc.SetEscapeHTML(false)
+			if p.Pretty {
+				enc.SetIndent("", "  ")
+			}
+			if err := enc.Encode(doc); err == nil {
+				body = bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+			}
+		}
+	}
+	if p.MaxBytes > 0 && len(body) > p.MaxBytes {
+		// Do not cut a multi-byte character in half
+		n := p.MaxBytes
+		for n > 0 && !utf8.RuneStart(body[n]) {
+			n--
+		}
+		return fmt.Sprintf("%s...(truncated %d bytes)", body[:n], len(body)-n)
+	}
+	return string(body)
+}
+
+// collapseArrays keeps the first items of arrays, replacing the rest with
+// a marker counting them
+func collapseArrays(v interface{}, maxItems int) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = collapseArrays(value, maxItems)
+		}
+	case []interface{}:
+		n := min(len(v), maxItems)
+		out := make([]interface{}, 0, n+1)
+		for _, item := range v[:n] {
+			out = append(out, collapseArrays(item, maxItems))
+		}
+		if len(v) > n {
+			out = append(out, fmt.Sprintf("...(%d more items)", len(v)-n))
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// VerifyTargets probes all configured targets on startup and exits if any
+// is unreachable, set by the --verify-targets flag or VERIFY_TARGETS=1
+var VerifyTargets bool
+
+// probeTarget sends a HEAD request to the target with its headers. Any
+// response except 404 and gateway errors counts as reachable, webhook
+// endpoints often reject HEAD with 405 or 501.
+func probeTarget(target Target) error {
+	policy := target.policy
+	if policy == nil {
+		policy = defaultPolicy
+	}
+	client := &http.Client{
+		Transport: target.httpTransport(),
+		Timeout:   target.timeout(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), target.timeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target.URL, nil)
+	if err != nil {
+		return err
+	}
+	ctx, err = policy.pin(ctx, req.URL.Hostname(), target.timeout())
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for name, values := range target.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("X-Webhook-Dispatcher-Probe", "1")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// probeTargets probes every target of the config, including fallbacks,
+// and returns the number of unreachable targets. Targets with URL
+// templates are skipped as they are only known per request.
+func probeTargets(config *Config) int {
+	rules := make([]*DispatchRule, 0, len(config.Dispatch)+1)
+	for i := range config.Dispatch {
+		rules = append(rules, &config.Dispatch[i])
+	}
+	if config.Default != nil {
+		rules = append(rules, config.Default)
+	}
+
+	targets := map[string]Target{}
+	var collect func(list []Target)
+	collect = func(list []Target) {
+		for _, t := range list {
+			if t.urlTemplate != nil {
+				log.Printf("Target %s: skipped, URL is a template", t.URL)
+			} else {
+				targets[t.URL] = t
+			}
+			collect(t.Fallback)
+		}
+	}
+	for _, rule := range rules {
+		collect(rule.Targets)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := 0
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target Target) {
+			defer wg.Done()
+			if err := probeTarget(target); err != nil {
+				log.Printf("Target %s: unreachable: %v", target.URL, err)
+				mu.Lock()
+				failed++
+				mu.Unlock()
+				return
+			}
+			log.Printf("Target %s: reachable", target.URL)
+		}(target)
+	}
+	wg.Wait()
+	return failed
+}
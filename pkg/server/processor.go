@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/version"
+	"gopkg.in/yaml.v3"
+)
+
+// unixScheme prefixes processor addresses listening on a unix socket,
+// e.g. unix:///run/processor.sock
+const unixScheme = "unix://"
+
+// defaultProcessorTimeout applies to processors without a Timeout
+const defaultProcessorTimeout = 10 * time.Second
+
+// Processor transforms payloads before they are sent to targets. In the
+// config it is either a plain URL string or an object with the URL and
+// options.
+type Processor struct {
+	// URL receives the payload in a POST request and responds with the
+	// transformed payload, unix:///path/to.sock for unix sockets
+	URL string `yaml:"URL"`
+	// Timeout of a processor call, defaults to 10s
+	Timeout time.Duration `yaml:"Timeout"`
+
+	client *http.Client
+	// endpoint is the request URL, the URL except for unix sockets
+	endpoint string
+	// policy restricts the hosts of processors not on unix sockets
+	policy *hostPolicy
+}
+
+// UnmarshalYAML accepts both the plain URL and the object form
+func (p *Processor) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		p.URL = value.Value
+		return nil
+	}
+
+	type plain Processor
+	if err := value.Decode((*plain)(p)); err != nil {
+		return err
+	}
+	if p.URL == "" {
+		return fmt.Errorf("line %d: processor URL is required", value.Line)
+	}
+	return nil
+}
+
+// prepare creates the HTTP client of the processor, processors not on
+// unix sockets are subject to the outbound host policy like targets
+func (p *Processor) prepare(outbound OutboundConfig) error {
+	if p.Timeout < 0 {
+		return fmt.Errorf("processor %s: Timeout must not be negative", p.URL)
+	}
+	if p.Timeout == 0 {
+		p.Timeout = defaultProcessorTimeout
+	}
+	p.client = &http.Client{Timeout: p.Timeout}
+	p.endpoint = p.URL
+
+	if socket, ok := strings.CutPrefix(p.URL, unixScheme); ok {
+		p.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		p.endpoint = "http://processor/"
+		return nil
+	}
+
+	p.policy = outbound.policy
+	if p.policy == nil {
+		p.policy = defaultPolicy
+	}
+	transport, err := p.policy.transportFor("", nil)
+	if err != nil {
+		return fmt.Errorf("processor %s: %w", p.URL, err)
+	}
+	p.client.Transport = transport
+	return nil
+}
+
+// prepareProcessors prepares the processors of a rule or variant
+func prepareProcessors(processors []Processor, outbound OutboundConfig) error {
+	for i := range processors {
+		if err := processors[i].prepare(outbound); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runProcessors passes the payload through the processors in order, each
+// processor receiving the output of the previous one
+func runProcessors(ctx context.Context, processors []Processor, body []byte, headers http.Header) ([]byte, error) {
+	for i := range processors {
+		out, err := processors[i].run(ctx, body, headers)
+		if err != nil {
+			return nil, fmt.Errorf("processor %s: %w", processors[i].URL, err)
+		}
+		body = out
+	}
+	return body, nil
+}
+
+// run posts the payload to the processor and returns its response, which
+// is limited to the in-memory payload size
+func (p *Processor) run(ctx context.Context, body []byte, headers http.Header) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "webhook-dispatcher/"+version.Version)
+	copyTraceContext(req.Header, headers)
+	for name, values := range headers {
+		if strings.HasPrefix(name, paramHeaderPrefix) {
+			req.Header[name] = values
+		}
+	}
+	req.Header.Set("Content-Type", headers.Get("Content-Type"))
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// Resolve the host once and pin the call to the validated addresses
+	if p.policy != nil {
+		pinnedCtx, err := p.policy.pin(ctx, req.URL.Hostname(), p.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(pinnedCtx)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	out, err := io.ReadAll(io.LimitReader(resp.Body, streamThreshold+1))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if int64(len(out)) > streamThreshold {
+		return nil, fmt.Errorf("response over %d bytes", streamThreshold)
+	}
+	return out, nil
+}
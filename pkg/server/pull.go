@@ -0,0 +1,357 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+)
+
+const (
+	// pullScheme prefixes the URLs of pull targets, pull://<consumer>
+	pullScheme = "pull://"
+	// pullStreamGroup is the consumer group of pull queues
+	pullStreamGroup = "pull"
+	// defaultVisibilityTimeout applies to consumers without one
+	defaultVisibilityTimeout = 5 * time.Minute
+	// maxPullEvents limits the events returned by a poll
+	maxPullEvents = 100
+	// maxPullWait limits how long a poll waits for events
+	maxPullWait = 30 * time.Second
+)
+
+var pullEventsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "webhook_dispatcher_pull_events_total",
+	Help: "Number of pull queue events by consumer and event (queued, polled, acked, nacked)",
+}, []string{"consumer", "event"})
+
+func init() {
+	prometheus.MustRegister(pullEventsCounter)
+}
+
+// PullConsumer polls its events from /api/queue/<name>, or streams them
+// as server-sent events from /api/queue/<name>/stream, instead of
+// receiving pushes, for targets behind strict firewalls, browsers and
+// CLIs. Targets with the URL pull://<name> queue events for it in a Redis
+// stream, HTTP options of those targets do not apply.
+type PullConsumer struct {
+	Name string `yaml:"Name"`
+	// Token authenticates the consumer, which can only access its own
+	// queue. API tokens with the operator role can access all queues.
+	Token Secret `yaml:"Token"`
+	// VisibilityTimeout is how long a polled event is hidden from other
+	// polls, it is handed out again unless acknowledged in time. Defaults
+	// to 5m.
+	VisibilityTimeout time.Duration `yaml:"VisibilityTimeout"`
+}
+
+// prepare resolves the token and applies defaults
+func (c *PullConsumer) prepare() error {
+	if c.Name == "" || strings.ContainsAny(c.Name, "/?#") {
+		return fmt.Errorf("consumer: invalid Name %q", c.Name)
+	}
+	if err := c.Token.load(); err != nil {
+		return fmt.Errorf("consumer %s: Token: %w", c.Name, err)
+	}
+	if c.Token.Get() == "" {
+		return fmt.Errorf("consumer %s: empty Token", c.Name)
+	}
+	if c.VisibilityTimeout == 0 {
+		c.VisibilityTimeout = defaultVisibilityTimeout
+	}
+	return nil
+}
+
+// consumer returns the pull consumer with the name
+func (c *Config) consumer(name string) (*PullConsumer, bool) {
+	for i := range c.Consumers {
+		if c.Consumers[i].Name == name {
+			return &c.Consumers[i], true
+		}
+	}
+	return nil, false
+}
+
+// checkPullTargets verifies that the consumers of pull targets exist
+func (c *Config) checkPullTargets(targets []Target) error {
+	for i := range targets {
+		if targets[i].pull != "" {
+			if _, ok := c.consumer(targets[i].pull); !ok {
+				return fmt.Errorf("target %s: unknown consumer %q", targets[i].URL, targets[i].pull)
+			}
+		}
+		if err := c.checkPullTargets(targets[i].Fallback); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pullReady records the queues set up
+var pullReady sync.Map
+
+// pullQueue returns the queue of a consumer
+func pullQueue(ctx context.Context, consumer string) (*storage.RedisStream, error) {
+	redis := connectedRedis.Load()
+	if redis == nil {
+		if connectedMongoDB.Load() != nil {
+			return nil, storage.ErrNotSupported
+		}
+		return nil, storage.ErrUnavailable
+	}
+	stream := redis.Stream("pull:"+consumer, pullStreamGroup, consumer)
+	if _, ok := pullReady.Load(consumer); !ok {
+		if err := stream.Setup(ctx); err != nil {
+			return nil, err
+		}
+		pullReady.Store(consumer, true)
+	}
+	return stream, nil
+}
+
+// enqueuePull queues a delivery to a pull target for its consumer
+func enqueuePull(ctx context.Context, target Target, body payload, headers http.Header) DeliveryResult {
+	if body.spilled() {
+		err := fmt.Errorf("payloads over %d bytes cannot be pulled", streamThreshold)
+		log.Printf("Failed to queue webhook for %s: %v", target.URL, err)
+		return DeliveryResult{URL: target.URL, Error: err.Error()}
+	}
+	stream, err := pullQueue(ctx, target.pull)
+	if err == nil {
+		err = stream.Add(ctx, storage.DeliveryJob{
+			Time:        time.Now(),
+			Key:         body.key,
+			Path:        body.path,
+			Rule:        target.rule,
+			Target:      target.URL,
+			TargetLabel: target.label(),
+			Body:        string(body.data),
+			Headers:     keepHeaders(target, headers),
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to queue webhook for %s: %v", target.URL, err)
+		return DeliveryResult{URL: target.URL, Error: err.Error()}
+	}
+	pullEventsCounter.WithLabelValues(target.pull, "queued").Inc()
+	log.Printf("Queued webhook for %s", target.URL)
+	return DeliveryResult{URL: target.URL, Status: http.StatusAccepted}
+}
+
+// pullEvent is an event handed out to a pull consumer
+type pullEvent struct {
+	// ID acknowledges the event
+	ID      string              `json:"id"`
+	Time    time.Time           `json:"time"`
+	Key     string              `json:"key,omitempty"`
+	Path    string              `json:"path,omitempty"`
+	Rule    string              `json:"rule,omitempty"`
+	Body    string              `json:"body"`
+	Headers map[string][]string `json:"headers,omitempty"`
+}
+
+// newPullEvent returns the event of a queued job
+func newPullEvent(job storage.DeliveryJob) pullEvent {
+	return pullEvent{
+		ID:      job.ID,
+		Time:    job.Time,
+		Key:     job.Key,
+		Path:    job.Path,
+		Rule:    job.Rule,
+		Body:    job.Body,
+		Headers: job.Headers,
+	}
+}
+
+// registerQueueAPI registers the pull consumer API, available to consumer
+// tokens and API tokens with the operator role even when the rest of the
+// admin API is disabled
+func registerQueueAPI(mux *http.ServeMux, config *Config, auth *apiAuth) {
+	var limiter *authLimiter
+	if auth != nil {
+		limiter = auth.limiter
+	} else {
+		// Validated by newAPIAuth, prepare only sets defaults
+		lockout := config.API.Lockout
+		lockout.prepare()
+		limiter = newAuthLimiter(lockout)
+	}
+	mux.HandleFunc("/api/queue/{consumer}", requireConsumer(auth, limiter, handlePull))
+	mux.HandleFunc("/api/queue/{consumer}/ack", requireConsumer(auth, limiter, handleAck))
+	mux.HandleFunc("/api/queue/{consumer}/nack", requireConsumer(auth, limiter, handleNack))
+	mux.HandleFunc("/api/queue/{consumer}/stream", tokenFromQuery(requireConsumer(auth, limiter, handleStream)))
+	if len(config.Consumers) > 0 {
+		log.Printf("Pull consumer API enabled on /api/queue/ for %d consumers", len(config.Consumers))
+	}
+}
+
+// requireConsumer authenticates requests for the queue of a consumer with
+// the consumer token or an API token with the operator role
+func requireConsumer(auth *apiAuth, limiter *authLimiter, next func(http.ResponseWriter, *http.Request, *PullConsumer)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r).String()
+		if wait, ok := limiter.allow(ip); !ok {
+			writeLockedOut(w, wait)
+			return
+		}
+
+		name := r.PathValue("consumer")
+		consumer, found := liveConfig.Load().consumer(name)
+		provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		authorized := found && provided != "" &&
+			subtle.ConstantTimeCompare([]byte(provided), []byte(consumer.Token.Get())) == 1
+		if !authorized && auth != nil {
+			if tokenName, role, ok := auth.authenticate(r); ok {
+				if roleLevels[role] < roleLevels[RoleOperator] {
+					writeError(w, http.StatusForbidden, ErrCodeForbidden, r.URL.Path+" requires the operator role")
+					return
+				}
+				authorized = true
+				log.Printf("Admin API: %s %s by %s", r.Method, r.URL.Path, tokenName)
+			}
+		}
+		if !authorized {
+			if limiter.fail(ip) {
+				limiter.lockOut(r, ip)
+			}
+			writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Missing or invalid consumer token")
+			return
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, ErrCodeNotFound, "Consumer "+name+" not found")
+			return
+		}
+		next(w, r, consumer)
+	}
+}
+
+// handlePull hands out up to max (default 10) events of the consumer,
+// first those not acknowledged within the visibility timeout. With wait,
+// e.g. wait=20s, the poll waits for new events when there are none.
+func handlePull(w http.ResponseWriter, r *http.Request, consumer *PullConsumer) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+	max := int64(10)
+	if v := r.URL.Query().Get("max"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || n > maxPullEvents {
+			writeError(w, http.StatusBadRequest, ErrCodeBadRequest, fmt.Sprintf("max must be 1-%d", maxPullEvents))
+			return
+		}
+		max = n
+	}
+	wait := time.Duration(-1)
+	if v := r.URL.Query().Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > maxPullWait {
+			writeError(w, http.StatusBadRequest, ErrCodeBadRequest, fmt.Sprintf("wait must be a duration up to %s", maxPullWait))
+			return
+		}
+		if d > 0 {
+			wait = d
+		}
+	}
+
+	stream, err := pullQueue(r.Context(), consumer.Name)
+	if err != nil {
+		writeStorageError(w, err, "pull queues")
+		return
+	}
+	jobs, err := stream.Claim(r.Context(), consumer.VisibilityTimeout, max)
+	if err == nil && int64(len(jobs)) < max {
+		if len(jobs) > 0 {
+			wait = -1
+		}
+		var fresh []storage.DeliveryJob
+		fresh, err = stream.Read(r.Context(), max-int64(len(jobs)), wait)
+		jobs = append(jobs, fresh...)
+	}
+	if err != nil {
+		writeStorageError(w, err, "polling the queue")
+		return
+	}
+
+	events := make([]pullEvent, len(jobs))
+	for i, job := range jobs {
+		events[i] = newPullEvent(job)
+	}
+	pullEventsCounter.WithLabelValues(consumer.Name, "polled").Add(float64(len(events)))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"consumer": consumer.Name,
+		"count":    len(events),
+		"events":   events,
+	})
+}
+
+// handleAck acknowledges processed events of the consumer by id, removing
+// them from the queue
+func handleAck(w http.ResponseWriter, r *http.Request, consumer *PullConsumer) {
+	ids, stream, ok := queueRequest(w, r, consumer)
+	if !ok {
+		return
+	}
+	for _, id := range ids {
+		if err := stream.Ack(r.Context(), id); err != nil {
+			writeStorageError(w, err, "acknowledging events")
+			return
+		}
+	}
+	pullEventsCounter.WithLabelValues(consumer.Name, "acked").Add(float64(len(ids)))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"consumer": consumer.Name, "acked": len(ids)})
+}
+
+// handleNack returns events of the consumer by id to the queue, to be
+// handed out again right away
+func handleNack(w http.ResponseWriter, r *http.Request, consumer *PullConsumer) {
+	ids, stream, ok := queueRequest(w, r, consumer)
+	if !ok {
+		return
+	}
+	requeued := 0
+	var missing []string
+	for _, id := range ids {
+		err := stream.Requeue(r.Context(), id)
+		if errors.Is(err, storage.ErrNotFound) {
+			missing = append(missing, id)
+			continue
+		}
+		if err != nil {
+			writeStorageError(w, err, "returning events")
+			return
+		}
+		requeued++
+	}
+	pullEventsCounter.WithLabelValues(consumer.Name, "nacked").Add(float64(requeued))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"consumer": consumer.Name, "requeued": requeued, "not_found": missing})
+}
+
+// queueRequest returns the event ids and queue of an ack or nack request,
+// or writes the error response
+func queueRequest(w http.ResponseWriter, r *http.Request, consumer *PullConsumer) ([]string, *storage.RedisStream, bool) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+		return nil, nil, false
+	}
+	ids := r.URL.Query()["id"]
+	if len(ids) == 0 || len(ids) > maxPullEvents {
+		writeError(w, http.StatusBadRequest, ErrCodeBadRequest, fmt.Sprintf("1-%d id parameters are required", maxPullEvents))
+		return nil, nil, false
+	}
+	stream, err := pullQueue(r.Context(), consumer.Name)
+	if err != nil {
+		writeStorageError(w, err, "pull queues")
+		return nil, nil, false
+	}
+	return ids, stream, true
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// streamHeartbeat is how often an idle event stream sends a comment, it
+// also bounds how long a closed stream holds a Redis read
+const streamHeartbeat = 15 * time.Second
+
+var pullStreamsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "webhook_dispatcher_pull_streams",
+	Help: "Number of connected event streams by consumer",
+}, []string{"consumer"})
+
+func init() {
+	prometheus.MustRegister(pullStreamsGauge)
+}
+
+var (
+	// streamsClosing is closed on shutdown to end the event streams, which
+	// would otherwise hold the shutdown until its timeout
+	streamsClosing = make(chan struct{})
+	closeOnce      sync.Once
+)
+
+// closeStreams ends the event streams, it is registered with
+// http.Server.RegisterOnShutdown
+func closeStreams() {
+	closeOnce.Do(func() { close(streamsClosing) })
+}
+
+// tokenFromQuery accepts the consumer token in the token parameter, as
+// browser EventSource clients cannot set headers. The parameter is removed
+// so it does not end up in logs.
+func tokenFromQuery(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if token := query.Get("token"); token != "" {
+			if r.Header.Get("Authorization") == "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+			query.Del("token")
+			r.URL.RawQuery = query.Encode()
+		}
+		next(w, r)
+	}
+}
+
+// handleStream streams the events of the consumer as server-sent events
+// (webhook events with the queue id as event id), bridging pushed webhooks
+// to browser and CLI consumers. With ack=auto (default) events are
+// acknowledged once written, with ack=manual they must be acknowledged on
+// /ack within the visibility timeout like polled events. Events not
+// acknowledged in time are streamed again.
+func handleStream(w http.ResponseWriter, r *http.Request, consumer *PullConsumer) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+	autoAck := true
+	switch r.URL.Query().Get("ack") {
+	case "", "auto":
+	case "manual":
+		autoAck = false
+	default:
+		writeError(w, http.StatusBadRequest, ErrCodeBadRequest, "ack must be auto or manual")
+		return
+	}
+	stream, err := pullQueue(r.Context(), consumer.Name)
+	if err != nil {
+		writeStorageError(w, err, "pull queues")
+		return
+	}
+
+	setCORSHeaders(w, r, liveConfig.Load())
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	send := func(format string, args ...interface{}) error {
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	if err := send(": streaming %s\n\n", consumer.Name); err != nil {
+		return
+	}
+
+	pullStreamsGauge.WithLabelValues(consumer.Name).Inc()
+	defer pullStreamsGauge.WithLabelValues(consumer.Name).Dec()
+	log.Printf("Event stream of consumer %s opened from %s", consumer.Name, clientIP(r))
+	defer log.Printf("Event stream of consumer %s closed", consumer.Name)
+
+	// Reads are bounded by the visibility timeout to stream expired events
+	// in time
+	block := min(streamHeartbeat, consumer.VisibilityTimeout)
+	ctx := r.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-streamsClosing:
+			return
+		default:
+		}
+
+		jobs, err := stream.Claim(ctx, consumer.VisibilityTimeout, maxPullEvents)
+		if err == nil && len(jobs) == 0 {
+			jobs, err = stream.Read(ctx, maxPullEvents, block)
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Failed to read queue of consumer %s: %v", consumer.Name, err)
+				send("event: error\ndata: %s\n\n", "failed to read the queue")
+			}
+			return
+		}
+		if len(jobs) == 0 {
+			if send(": keepalive\n\n") != nil {
+				return
+			}
+			continue
+		}
+
+		for _, job := range jobs {
+			data, err := json.Marshal(newPullEvent(job))
+			if err != nil {
+				log.Printf("Failed to encode event %s of consumer %s: %v", job.ID, consumer.Name, err)
+				continue
+			}
+			if send("id: %s\nevent: webhook\ndata: %s\n\n", job.ID, data) != nil {
+				// Unacknowledged events are streamed again after the
+				// visibility timeout
+				return
+			}
+			pullEventsCounter.WithLabelValues(consumer.Name, "polled").Inc()
+			if !autoAck {
+				continue
+			}
+			if err := stream.Ack(ctx, job.ID); err != nil {
+				log.Printf("Failed to acknowledge event %s of consumer %s: %v", job.ID, consumer.Name, err)
+				continue
+			}
+			pullEventsCounter.WithLabelValues(consumer.Name, "acked").Inc()
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// startMetricsPush pushes metrics periodically for environments without a
+// scraping Prometheus. PUSHGATEWAY_URL pushes to a Prometheus Pushgateway
+// (job PUSHGATEWAY_JOB, default webhook-dispatcher), OTLP_METRICS_ENDPOINT
+// to an OTLP/HTTP metrics endpoint, e.g. http://collector:4318/v1/metrics.
+// METRICS_PUSH_INTERVAL defaults to 15s.
+func startMetricsPush() {
+	pushgatewayURL := os.Getenv("PUSHGATEWAY_URL")
+	otlpEndpoint := os.Getenv("OTLP_METRICS_ENDPOINT")
+	if pushgatewayURL == "" && otlpEndpoint == "" {
+		return
+	}
+
+	interval := durationFromEnv("METRICS_PUSH_INTERVAL", 15*time.Second)
+	instance, _ := os.Hostname()
+
+	var pusher *push.Pusher
+	if pushgatewayURL != "" {
+		job := os.Getenv("PUSHGATEWAY_JOB")
+		if job == "" {
+			job = "webhook-dispatcher"
+		}
+		pusher = push.New(pushgatewayURL, job).
+			Gatherer(prometheus.DefaultGatherer).
+			Grouping("instance", instance)
+		log.Printf("Pushing metrics to Pushgateway %s every %s", pushgatewayURL, interval)
+	}
+	if otlpEndpoint != "" {
+		log.Printf("Pushing metrics to OTLP endpoint %s every %s", otlpEndpoint, interval)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if pusher != nil {
+				if err := pusher.Push(); err != nil {
+					log.Printf("Failed to push metrics to Pushgateway: %v", err)
+				}
+			}
+			if otlpEndpoint != "" {
+				if err := pushOTLP(otlpEndpoint, instance); err != nil {
+					log.Printf("Failed to push metrics to OTLP endpoint: %v", err)
+				}
+			}
+		}
+	}()
+}
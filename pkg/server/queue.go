@@ -0,0 +1,145 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// deliveryQueue limits the number of concurrent deliveries, deliveries
+// over the limit wait in the queue for a free worker
+type deliveryQueue struct {
+	// slots holds a token per busy worker, nil when unlimited
+	slots    chan struct{}
+	busy     atomic.Int64
+	draining atomic.Bool
+
+	mu      sync.Mutex
+	waiting *list.List
+}
+
+// deliveries is the queue all deliveries go through, unlimited unless
+// DELIVERY_WORKERS is set
+var deliveries = newDeliveryQueue(0)
+
+// newDeliveryQueue returns a queue with the number of workers, 0 means
+// unlimited
+func newDeliveryQueue(workers int) *deliveryQueue {
+	q := &deliveryQueue{waiting: list.New()}
+	if workers > 0 {
+		q.slots = make(chan struct{}, workers)
+	}
+	return q
+}
+
+// deliveryWorkersFromEnv reads DELIVERY_WORKERS
+func deliveryWorkersFromEnv() int {
+	v := os.Getenv("DELIVERY_WORKERS")
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("Warning: Invalid DELIVERY_WORKERS %q, deliveries are not limited", v)
+		return 0
+	}
+	return n
+}
+
+// acquire waits for a free worker, the returned function releases it
+func (q *deliveryQueue) acquire(ctx context.Context) (func(), error) {
+	if q.slots == nil {
+		q.busy.Add(1)
+		return func() { q.busy.Add(-1) }, nil
+	}
+
+	q.mu.Lock()
+	e := q.waiting.PushBack(time.Now())
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		q.waiting.Remove(e)
+		q.mu.Unlock()
+	}()
+
+	select {
+	case q.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	q.busy.Add(1)
+	return func() {
+		q.busy.Add(-1)
+		<-q.slots
+	}, nil
+}
+
+// QueueStats describes the delivery backlog
+type QueueStats struct {
+	// Depth is the number of deliveries waiting for a worker
+	Depth int `json:"depth"`
+	// OldestAge is how long the oldest waiting delivery has been queued
+	OldestAge float64 `json:"oldest_age_seconds"`
+	// Workers is the worker limit, 0 when unlimited
+	Workers int `json:"workers"`
+	// Busy is the number of deliveries in progress
+	Busy int64 `json:"busy"`
+	// Utilization is the fraction of busy workers, 0 when unlimited
+	Utilization float64 `json:"utilization"`
+	// Draining is set while the server shuts down
+	Draining bool `json:"draining"`
+}
+
+// snapshot returns the current queue figures
+func (q *deliveryQueue) snapshot() QueueStats {
+	s := QueueStats{
+		Workers:  cap(q.slots),
+		Busy:     q.busy.Load(),
+		Draining: q.draining.Load(),
+	}
+	q.mu.Lock()
+	s.Depth = q.waiting.Len()
+	if front := q.waiting.Front(); front != nil {
+		s.OldestAge = time.Since(front.Value.(time.Time)).Seconds()
+	}
+	q.mu.Unlock()
+	if s.Workers > 0 {
+		s.Utilization = float64(s.Busy) / float64(s.Workers)
+	}
+	return s
+}
+
+func init() {
+	gauge := func(name string, help string, value func(QueueStats) float64) {
+		prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: name,
+			Help: help,
+		}, func() float64 {
+			return value(deliveries.snapshot())
+		}))
+	}
+	gauge("webhook_dispatcher_queue_depth", "Number of deliveries waiting for a worker",
+		func(s QueueStats) float64 { return float64(s.Depth) })
+	gauge("webhook_dispatcher_queue_oldest_age_seconds", "Age of the oldest delivery waiting for a worker",
+		func(s QueueStats) float64 { return s.OldestAge })
+	gauge("webhook_dispatcher_workers", "Delivery worker limit, 0 when unlimited",
+		func(s QueueStats) float64 { return float64(s.Workers) })
+	gauge("webhook_dispatcher_workers_busy", "Number of delivery workers busy",
+		func(s QueueStats) float64 { return float64(s.Busy) })
+	gauge("webhook_dispatcher_worker_utilization", "Fraction of delivery workers busy, 0 when unlimited",
+		func(s QueueStats) float64 { return s.Utilization })
+	gauge("webhook_dispatcher_draining", "1 while the server drains deliveries on shutdown",
+		func(s QueueStats) float64 {
+			if s.Draining {
+				return 1
+			}
+			return 0
+		})
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// Rate limit policies
+const (
+	// RateLimitDelay holds excess events until the limit allows forwarding
+	RateLimitDelay = "delay"
+	// RateLimitReject responds 429 to excess events, which are stored but
+	// not forwarded
+	RateLimitReject = "reject"
+)
+
+// defaultMaxRateLimitDelay bounds how long an event is held by the delay
+// policy, events exceeding it are rejected
+const defaultMaxRateLimitDelay = time.Minute
+
+var rateLimitedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "webhook_dispatcher_rate_limited_total",
+	Help: "Number of events over the rule rate limit by outcome (delayed, rejected)",
+}, []string{"rule", "outcome"})
+
+func init() {
+	prometheus.MustRegister(rateLimitedCounter)
+}
+
+// RateLimit limits how fast the events of a rule are forwarded, events are
+// stored regardless of the limit
+type RateLimit struct {
+	// PerSecond is the sustained number of events forwarded per second
+	PerSecond float64 `yaml:"PerSecond"`
+	// Burst is the number of events forwarded at once over the rate,
+	// defaults to PerSecond rounded up
+	Burst int `yaml:"Burst"`
+	// OnExceeded is delay (default, forwarding waits for the limit) or
+	// reject (respond 429)
+	OnExceeded string `yaml:"OnExceeded"`
+	// MaxDelay bounds the delay, events which would wait longer are
+	// rejected. Defaults to 1m. Delayed events are held in memory.
+	MaxDelay time.Duration `yaml:"MaxDelay"`
+
+	limiter *rate.Limiter
+}
+
+// prepare validates the limit and creates the limiter
+func (l *RateLimit) prepare() error {
+	if l.PerSecond <= 0 {
+		return fmt.Errorf("invalid PerSecond %v, must be positive", l.PerSecond)
+	}
+	if l.Burst < 0 {
+		return fmt.Errorf("invalid Burst %d, must not be negative", l.Burst)
+	}
+	if l.Burst == 0 {
+		l.Burst = int(math.Ceil(l.PerSecond))
+	}
+	switch l.OnExceeded {
+	case "":
+		l.OnExceeded = RateLimitDelay
+	case RateLimitDelay, RateLimitReject:
+	default:
+		return fmt.Errorf("unknown OnExceeded %q", l.OnExceeded)
+	}
+	if l.MaxDelay == 0 {
+		l.MaxDelay = defaultMaxRateLimitDelay
+	}
+	l.limiter = rate.NewLimiter(rate.Limit(l.PerSecond), l.Burst)
+	return nil
+}
+
+// throttle reserves forwarding of an event under the rule rate limit. It
+// returns the delay before the event may be forwarded, or false when the
+// event must not be forwarded.
+func (r *DispatchRule) throttle() (time.Duration, bool) {
+	if r == nil || r.RateLimit == nil {
+		return 0, true
+	}
+	l := r.RateLimit
+	now := time.Now()
+	reservation := l.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return 0, true
+	}
+	if l.OnExceeded == RateLimitReject || delay > l.MaxDelay {
+		reservation.CancelAt(now)
+		rateLimitedCounter.WithLabelValues(r.label(), "rejected").Inc()
+		return 0, false
+	}
+	rateLimitedCounter.WithLabelValues(r.label(), "delayed").Inc()
+	return delay, true
+}
+
+// writeRateLimited responds to an event stored but not forwarded because
+// of the rule rate limit
+func writeRateLimited(w http.ResponseWriter, r *http.Request, rule *DispatchRule) {
+	retryAfter := max(1, int(math.Ceil(1/rule.RateLimit.PerSecond)))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	writeError(w, http.StatusTooManyRequests, ErrCodeRateLimited,
+		fmt.Sprintf("Rate limit of rule %s exceeded, the webhook was not forwarded", rule.label()))
+	log.Printf("Rate limit of rule %s exceeded, not forwarding %s", rule.label(), r.URL.Path)
+}
+
+// sleepContext waits for the duration unless the context is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// dispatchAfter dispatches the event once the delay has passed
+func dispatchAfter(delay time.Duration, rule *DispatchRule, targets []Target, body payload, headers http.Header) {
+	if delay <= 0 {
+		dispatch(rule, targets, body, headers)
+		return
+	}
+	time.AfterFunc(delay, func() {
+		defer recoverGoroutine("delayed dispatch for " + rule.label())
+		dispatch(rule, targets, body, headers)
+	})
+}
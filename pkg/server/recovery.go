@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+)
+
+// crashKeyPrefix is the key namespace for payloads of requests that panicked
+const crashKeyPrefix = "crash"
+
+// maxCrashDump limits the body saved with a crash dump
+const maxCrashDump = 1 << 20
+
+// capturingReader keeps a copy of the first maxCrashDump bytes read from
+// the request body
+type capturingReader struct {
+	io.ReadCloser
+	captured bytes.Buffer
+	read     int
+}
+
+func (c *capturingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if room := maxCrashDump - c.captured.Len(); room > 0 {
+		c.captured.Write(p[:min(n, room)])
+	}
+	c.read += n
+	return n, err
+}
+
+// crashDump returns the body for a crash dump, at most maxCrashDump bytes
+// including what the handler did not consume yet
+func (c *capturingReader) crashDump() ([]byte, error) {
+	if c.read > c.captured.Len() {
+		return c.captured.Bytes(), nil
+	}
+	rest := io.LimitReader(c.ReadCloser, int64(maxCrashDump-c.captured.Len()))
+	return io.ReadAll(io.MultiReader(bytes.NewReader(c.captured.Bytes()), rest))
+}
+
+// withRecovery recovers from panics in the handler, responds with 500 and
+// saves the raw request body to the crash dump namespace
+func withRecovery(store storage.Storage, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := &capturingReader{ReadCloser: r.Body}
+		r.Body = body
+
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+
+			log.Printf("Panic while handling %s %s from %s: %v\n%s", r.Method, r.URL.Path, r.RemoteAddr, rec, debug.Stack())
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, "Internal server error")
+
+			raw, err := body.crashDump()
+			if err != nil {
+				log.Printf("Failed to read body of crashed request: %v", err)
+			}
+			if len(raw) == 0 {
+				return
+			}
+
+			key := fmt.Sprintf("%s-%s-%d", crashKeyPrefix, slugify(r.URL.Path), time.Now().UnixNano())
+			ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+			defer cancel()
+			event := &storage.Event{Key: key, Path: r.URL.Path, Body: string(raw), Timestamp: time.Now()}
+			if err := store.Store(ctx, event); err != nil {
+				log.Printf("Failed to save crash dump %s: %v", key, err)
+				return
+			}
+			log.Printf("Saved crash dump: %s (size: %d bytes)", key, len(raw))
+		}()
+
+		next(w, r)
+	}
+}
+
+// recoverGoroutine logs panics in background goroutines instead of
+// crashing the process, use with defer
+func recoverGoroutine(what string) {
+	if rec := recover(); rec != nil {
+		log.Printf("Panic in %s: %v\n%s", what, rec, debug.Stack())
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+)
+
+// ReplayConfig configures how stored events of a rule are replayed
+type ReplayConfig struct {
+	// RewriteTimestamps lists body fields set to the replay time, so targets
+	// validating a replay window accept old events
+	RewriteTimestamps []TimestampRewrite `yaml:"RewriteTimestamps"`
+}
+
+// TimestampRewrite describes a single timestamp field in the payload
+type TimestampRewrite struct {
+	// Field is a dot separated path to the field, e.g. "data.created"
+	Field string `yaml:"Field"`
+	// Format is one of rfc3339 (default), unix or unix_ms
+	Format string `yaml:"Format"`
+}
+
+// handleReplayEvent forwards a stored event to the targets of its rule again
+func handleReplayEvent(w http.ResponseWriter, r *http.Request, store storage.Storage, config *Config) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeBadRequest, "Event key is required")
+		return
+	}
+
+	event, err := store.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeStorageFailed, "Failed to get event")
+		log.Printf("Failed to get event %s: %v", key, err)
+		return
+	}
+
+	// Redis does not keep the original path, allow passing it explicitly
+	path := event.Path
+	if p := r.URL.Query().Get("path"); p != "" {
+		path = p
+	}
+	if path == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeBadRequest, "Event path is unknown, pass it using the path parameter")
+		return
+	}
+
+	body := []byte(event.Body)
+	in := ruleInput{Path: path, Method: http.MethodPost, Headers: http.Header{}, Key: key, SchemaVersion: event.SchemaVersion}
+	if err := json.Unmarshal(body, &in.Body); err == nil {
+		in.HasBody = true
+	}
+	// Replay through the rule the event was stored under, the method,
+	// headers and sender of the request are not kept to match it again
+	var rule *DispatchRule
+	if event.Rule != "" {
+		if rule = ruleByLabel(config, event.Rule); rule == nil {
+			writeError(w, http.StatusUnprocessableEntity, ErrCodeUnprocessable, "Rule "+event.Rule+" of the event is no longer configured")
+			return
+		}
+	} else {
+		rule = findRule(in, config)
+	}
+	if rule == nil || len(rule.Targets) == 0 {
+		writeError(w, http.StatusUnprocessableEntity, ErrCodeUnprocessable, "No targets configured for "+path)
+		return
+	}
+	targets, err := rule.renderTargets(in)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, ErrCodeUnprocessable, err.Error())
+		return
+	}
+	additional, err := renderAdditional(in, config, rule, targets)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, ErrCodeUnprocessable, err.Error())
+		return
+	}
+
+	if r.URL.Query().Get("rewrite_timestamps") == "true" {
+		body, err = rewriteTimestamps(body, rule.Replay.RewriteTimestamps, time.Now())
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, ErrCodeUnprocessable, "Failed to rewrite timestamps: "+err.Error())
+			return
+		}
+	}
+
+	headers := http.Header{}
+	headers.Set("Content-Type", "application/json")
+	setTraceContext(headers, event.TraceParent, event.TraceState)
+	replayed := memoryPayload(body)
+	replayed.key, replayed.path = key, path
+	in.Headers = headers
+	dispatch(rule, targets, replayed, rule.forwardHeaders(in))
+	for _, rt := range additional {
+		dispatch(rt.rule, rt.targets, replayed, rt.rule.forwardHeaders(in))
+		targets = append(targets, rt.targets...)
+	}
+
+	log.Printf("Replayed webhook: %s (path: %s, targets: %d)", key, path, len(targets))
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"key":     key,
+		"path":    path,
+		"targets": targetURLs(targets),
+	})
+}
+
+// rewriteTimestamps sets the configured timestamp fields of a JSON body to now
+func rewriteTimestamps(body []byte, rewrites []TimestampRewrite, now time.Time) ([]byte, error) {
+	if len(rewrites) == 0 {
+		return body, nil
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+
+	for _, rw := range rewrites {
+		var value interface{}
+		switch rw.Format {
+		case "", "rfc3339":
+			value = now.UTC().Format(time.RFC3339)
+		case "unix":
+			value = now.Unix()
+		case "unix_ms":
+			value = now.UnixMilli()
+		default:
+			return nil, fmt.Errorf("unknown timestamp format %q", rw.Format)
+		}
+		if !setField(data, rw.Field, value) {
+			log.Printf("Timestamp field %s not found, skipping", rw.Field)
+		}
+	}
+
+	return json.Marshal(data)
+}
+
+// setField sets an existing field addressed by a dot separated path
+func setField(data interface{}, path string, value interface{}) bool {
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		obj, ok := data.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if _, ok := obj[part]; !ok {
+			return false
+		}
+		if i == len(parts)-1 {
+			obj[part] = value
+			return true
+		}
+		data = obj[part]
+	}
+	return false
+}
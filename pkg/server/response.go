@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// ResponseConfig customizes the response sent to the webhook sender after
+// the webhook was accepted
+type ResponseConfig struct {
+	// Status defaults to 200
+	Status int `yaml:"Status"`
+	// ContentType of the body, defaults to text/plain unless set by
+	// ResponseHeaders
+	ContentType string `yaml:"ContentType"`
+	// Body is a template rendered with responseData, e.g. "" for an empty
+	// body or "{{ .Payload }}" to echo the payload
+	Body string `yaml:"Body"`
+
+	body *template.Template
+}
+
+// responseData is available to response body templates
+type responseData struct {
+	// Key of the event
+	Key string
+	// Stored reports whether the event was stored
+	Stored bool
+	// Path of the request
+	Path string
+	// Payload is the raw request body, empty for payloads streamed from disk
+	Payload string
+}
+
+// prepare parses the body template
+func (c *ResponseConfig) prepare() error {
+	if c.Status != 0 && (c.Status < 200 || c.Status > 299) {
+		return fmt.Errorf("invalid Response Status %d, must be 2xx", c.Status)
+	}
+	tmpl, err := template.New("response").Option("missingkey=error").Parse(c.Body)
+	if err != nil {
+		return fmt.Errorf("invalid Response Body: %w", err)
+	}
+	c.body = tmpl
+	return nil
+}
+
+// writeAccepted writes the response to an accepted webhook, customized by
+// the rule Response if set
+func writeAccepted(w http.ResponseWriter, rule *DispatchRule, data responseData) {
+	if rule == nil || rule.Response == nil {
+		w.WriteHeader(http.StatusOK)
+		if data.Stored {
+			fmt.Fprintf(w, "Webhook received and stored: %s\n", data.Key)
+		} else {
+			fmt.Fprintf(w, "Webhook received: %s\n", data.Key)
+		}
+		return
+	}
+
+	resp := rule.Response
+	var body strings.Builder
+	if err := resp.body.Execute(&body, data); err != nil {
+		log.Printf("Failed to render response for %s: %v", data.Path, err)
+	}
+	if resp.ContentType != "" {
+		w.Header().Set("Content-Type", resp.ContentType)
+	} else if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	fmt.Fprint(w, body.String())
+}
+
+// writeDropped responds to a webhook discarded by a Drop rule
+func writeDropped(w http.ResponseWriter, r *http.Request, rule *DispatchRule) {
+	droppedCounter.WithLabelValues(rule.label()).Inc()
+	if enableLogging {
+		log.Printf("Dropped webhook for %s by rule %s", r.URL.Path, rule.label())
+	}
+	if rule.Response != nil {
+		writeAccepted(w, rule, responseData{Path: r.URL.Path})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Webhook dropped")
+}
+
+// deliveryResults is the response of rules reporting their delivery results
+type deliveryResults struct {
+	Key    string `json:"key"`
+	Stored bool   `json:"stored"`
+	// Outcome is delivered, partial or failed
+	Outcome string           `json:"outcome"`
+	Results []DeliveryResult `json:"results"`
+}
+
+// writeDeliveryResults responds with the result of each delivery, with 200
+// when all succeeded, 207 when some failed and 502 when all failed
+func writeDeliveryResults(w http.ResponseWriter, key string, stored bool, results []DeliveryResult) {
+	failed := 0
+	for _, result := range results {
+		if !result.OK() {
+			failed++
+		}
+	}
+	resp := deliveryResults{Key: key, Stored: stored, Outcome: "delivered", Results: results}
+	status := http.StatusOK
+	switch {
+	case failed > 0 && failed == len(results):
+		resp.Outcome, status = "failed", http.StatusBadGateway
+	case failed > 0:
+		resp.Outcome, status = "partial", http.StatusMultiStatus
+	}
+	if resp.Results == nil {
+		resp.Results = []DeliveryResult{}
+	}
+	writeJSON(w, status, resp)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Retry defaults
+const (
+	defaultRetryInitialDelay = time.Second
+	defaultRetryFactor       = 2
+	defaultRetryMaxDelay     = time.Minute
+)
+
+var retriesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "webhook_dispatcher_delivery_retries_total",
+	Help: "Number of delivery retries by target",
+}, []string{"target"})
+
+func init() {
+	prometheus.MustRegister(retriesCounter)
+}
+
+// RetryConfig retries failed deliveries to a target with exponential
+// backoff. Network errors, 408, 429 and 5xx responses are retried, other
+// responses are final. Fallback targets are only tried after the retries.
+type RetryConfig struct {
+	// MaxAttempts is the number of attempts including the first one
+	MaxAttempts int `yaml:"MaxAttempts"`
+	// InitialDelay before the first retry, defaults to 1s
+	InitialDelay time.Duration `yaml:"InitialDelay"`
+	// Factor multiplies the delay after each retry, defaults to 2
+	Factor float64 `yaml:"Factor"`
+	// MaxDelay caps the delay between attempts, defaults to 1m
+	MaxDelay time.Duration `yaml:"MaxDelay"`
+	// Jitter randomizes each delay by up to the fraction, e.g. 0.2 for
+	// +-20%
+	Jitter float64 `yaml:"Jitter"`
+}
+
+// prepare validates the retry policy and applies defaults
+func (c *RetryConfig) prepare() error {
+	if c.MaxAttempts < 1 {
+		return fmt.Errorf("invalid retry MaxAttempts %d, must be at least 1", c.MaxAttempts)
+	}
+	if c.InitialDelay == 0 {
+		c.InitialDelay = defaultRetryInitialDelay
+	}
+	if c.Factor == 0 {
+		c.Factor = defaultRetryFactor
+	}
+	if c.MaxDelay == 0 {
+		c.MaxDelay = defaultRetryMaxDelay
+	}
+	if c.InitialDelay < 0 || c.Factor < 1 || c.MaxDelay < 0 {
+		return fmt.Errorf("invalid retry delays")
+	}
+	if c.Jitter < 0 || c.Jitter > 1 {
+		return fmt.Errorf("invalid retry Jitter %g, must be between 0 and 1", c.Jitter)
+	}
+	return nil
+}
+
+// delay returns the backoff before the retry following the attempt,
+// counted from 1
+func (c *RetryConfig) delay(attempt int) time.Duration {
+	d := float64(c.InitialDelay)
+	for i := 1; i < attempt; i++ {
+		d *= c.Factor
+		if d >= float64(c.MaxDelay) {
+			break
+		}
+	}
+	d = min(d, float64(c.MaxDelay))
+	if c.Jitter > 0 {
+		d *= 1 + c.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d)
+}
+
+// retryable reports whether a failed delivery may succeed when retried.
+// Deliveries failed by an open circuit breaker are not retried.
+func retryable(result DeliveryResult) bool {
+	switch {
+	case result.OK(), result.Error == errCircuitOpen.Error():
+		return false
+	case result.Status == 0:
+		return true
+	case result.Status == http.StatusRequestTimeout, result.Status == http.StatusTooManyRequests:
+		return true
+	}
+	return result.Status >= 500
+}
+
+// deliverWithRetry delivers to the target, retrying transient failures as
+// configured. Shadow targets are never retried.
+func deliverWithRetry(ctx context.Context, target Target, body payload, headers http.Header) DeliveryResult {
+	result := deliverThroughCircuit(ctx, target, body, headers)
+	if target.Retry == nil || target.shadow {
+		return result
+	}
+	for attempt := 1; attempt < target.Retry.MaxAttempts && retryable(result); attempt++ {
+		delay := target.Retry.delay(attempt)
+		log.Printf("Delivery to %s failed, retrying in %s (attempt %d of %d)", target.URL, delay.Round(time.Millisecond), attempt+1, target.Retry.MaxAttempts)
+		if err := sleepContext(ctx, delay); err != nil {
+			return result
+		}
+		retriesCounter.WithLabelValues(target.label()).Inc()
+		result = deliverThroughCircuit(ctx, target, body, headers)
+	}
+	return result
+}
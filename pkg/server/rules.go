@@ -0,0 +1,406 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/jsonpath"
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+)
+
+// ruleInput holds the request attributes rules are matched against
+type ruleInput struct {
+	Path   string
+	Method string
+	// Query is the raw query string of the request
+	Query    string
+	Headers  http.Header
+	RemoteIP net.IP
+	Geo      *storage.GeoInfo
+	// Body is the decoded JSON payload, set once the body has been parsed.
+	// Until then body conditions are assumed to match.
+	Body    interface{}
+	HasBody bool
+	// Key is the event key, set once assigned
+	Key string
+	// SchemaVersion is the version of the rule schema the payload matched
+	SchemaVersion int
+}
+
+// newRuleInput returns the rule input of an incoming request
+func newRuleInput(r *http.Request) ruleInput {
+	ip := clientIP(r)
+	return ruleInput{
+		Path:     r.URL.Path,
+		Method:   r.Method,
+		Query:    r.URL.RawQuery,
+		Headers:  r.Header,
+		RemoteIP: ip,
+		Geo:      geoDB.lookup(ip),
+	}
+}
+
+// label identifies the rule in logs and metrics
+func (r *DispatchRule) label() string {
+	if r == nil {
+		return unmatchedPathLabel
+	}
+	if r.Name != "" {
+		return r.Name
+	}
+	if r.isDefault {
+		return "default"
+	}
+	if r.PathRegex != "" {
+		return r.PathRegex
+	}
+	return r.Path
+}
+
+// matchPath matches a request path against the rule, returning regex
+// captures by group name and number
+func (r *DispatchRule) matchPath(path string) (map[string]string, bool) {
+	if r.pathRegexp == nil {
+		return nil, matchPath(r.Path, path)
+	}
+
+	m := r.pathRegexp.FindStringSubmatch(path)
+	if m == nil {
+		return nil, false
+	}
+	captures := map[string]string{}
+	for i, name := range r.pathRegexp.SubexpNames() {
+		captures[strconv.Itoa(i)] = m[i]
+		if name != "" {
+			captures[name] = m[i]
+		}
+	}
+	return captures, true
+}
+
+// pathParams returns the path parameters and named PathRegex groups of a
+// request path the rule matched
+func (r *DispatchRule) pathParams(path string) map[string]string {
+	if r == nil || r.pathRegexp == nil {
+		return nil
+	}
+	m := r.pathRegexp.FindStringSubmatch(path)
+	if m == nil {
+		return nil
+	}
+	params := map[string]string{}
+	for i, name := range r.pathRegexp.SubexpNames() {
+		if name != "" {
+			params[name] = m[i]
+		}
+	}
+	return params
+}
+
+// paramHeaderPrefix prefixes headers passing path parameters to processors
+const paramHeaderPrefix = "X-Webhook-Param-"
+
+// forwardHeaders returns the request headers with the path parameters of
+// the rule added for processors and the request method for deliveries.
+// Parameter and method headers sent by the client are removed.
+func (r *DispatchRule) forwardHeaders(in ruleInput) http.Header {
+	headers := in.Headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	for name := range headers {
+		if strings.HasPrefix(name, paramHeaderPrefix) {
+			delete(headers, name)
+		}
+	}
+	for name, value := range r.pathParams(in.Path) {
+		headers.Set(paramHeaderPrefix+name, value)
+	}
+	headers.Del(methodHeader)
+	if in.Method != "" {
+		headers.Set(methodHeader, in.Method)
+	}
+	return headers
+}
+
+// allowsMethod reports whether the rule accepts the HTTP method
+func (r *DispatchRule) allowsMethod(method string) bool {
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether the rule applies to the request. Rules with
+// OnMethodMismatch reject match regardless of the method, so the caller
+// can reject the request.
+func (r *DispatchRule) match(in ruleInput) bool {
+	return r.matchSource(in.RemoteIP) && r.matchRequest(in)
+}
+
+// matchSource reports whether the sender address satisfies MatchSourceIP
+func (r *DispatchRule) matchSource(ip net.IP) bool {
+	return len(r.sourceNets) == 0 || ip != nil && containsIP(r.sourceNets, ip)
+}
+
+// matchRequest matches the request against all conditions except the
+// sender address
+func (r *DispatchRule) matchRequest(in ruleInput) bool {
+	if _, ok := r.matchPath(in.Path); !ok {
+		return false
+	}
+	if r.notPathRegexp != nil && r.notPathRegexp.MatchString(in.Path) {
+		return false
+	}
+	if !r.allowsMethod(in.Method) && r.OnMethodMismatch != MethodMismatchReject {
+		return false
+	}
+	if !r.matchContentType(in.Headers.Get("Content-Type")) {
+		return false
+	}
+	if !r.matchHeaders(in.Headers) {
+		return false
+	}
+	for name, value := range r.NotHeaders {
+		if hasHeader(in.Headers, name, value) {
+			return false
+		}
+	}
+	if r.MatchGeo != nil && !r.MatchGeo.match(in.Geo) {
+		return false
+	}
+	if r.MatchBody != nil && in.HasBody && !r.MatchBody.match(in.Body) {
+		return false
+	}
+	if r.NotBody != nil && in.HasBody && r.NotBody.match(in.Body) {
+		return false
+	}
+	if r.when != nil && in.HasBody && !r.matchWhen(in) {
+		return false
+	}
+	return true
+}
+
+// matchHeaders reports whether the request headers satisfy MatchHeaders
+func (r *DispatchRule) matchHeaders(headers http.Header) bool {
+	for name, want := range r.MatchHeaders {
+		if !hasHeader(headers, name, want) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasHeader reports whether the header has the value, a value of * only
+// requires the header to be present
+func hasHeader(headers http.Header, name string, want string) bool {
+	values := headers.Values(name)
+	if want == "*" {
+		return len(values) > 0
+	}
+	return slices.Contains(values, want)
+}
+
+// matchContentType reports whether the request content type satisfies
+// MatchContentType, compared without parameters. A type/* entry matches
+// any subtype.
+func (r *DispatchRule) matchContentType(contentType string) bool {
+	if len(r.MatchContentType) == 0 {
+		return true
+	}
+	_, ok := r.matchingContentType(normalizeContentType(contentType))
+	return ok
+}
+
+// matchingContentType returns the MatchContentType entry matching the
+// media type, lowercased
+func (r *DispatchRule) matchingContentType(mediaType string) (string, bool) {
+	for _, want := range r.MatchContentType {
+		want = strings.ToLower(want)
+		if want == mediaType || strings.HasSuffix(want, "/*") && strings.HasPrefix(mediaType, want[:len(want)-1]) {
+			return want, true
+		}
+	}
+	return "", false
+}
+
+// acceptsRaw reports whether the rule takes a payload of the content type
+// without validating it as JSON, which is the case for non-JSON types the
+// rule explicitly matches
+func (r *DispatchRule) acceptsRaw(contentType string) bool {
+	return r != nil && len(r.MatchContentType) > 0 && !isJSONContentType(contentType)
+}
+
+// isJSONContentType reports whether the content type is JSON, including
+// structured syntax suffixes like application/cloudevents+json
+func isJSONContentType(contentType string) bool {
+	mediaType := normalizeContentType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// GeoCondition matches the sender location or network, an empty list
+// matches anything
+type GeoCondition struct {
+	Countries []string `yaml:"Countries"`
+	ASNs      []uint   `yaml:"ASNs"`
+}
+
+// match reports whether the sender geo information satisfies the condition,
+// senders with unknown location never match
+func (g *GeoCondition) match(geo *storage.GeoInfo) bool {
+	if geo == nil {
+		return false
+	}
+	if len(g.Countries) > 0 && !slices.ContainsFunc(g.Countries, func(c string) bool {
+		return strings.EqualFold(c, geo.Country)
+	}) {
+		return false
+	}
+	if len(g.ASNs) > 0 && !slices.Contains(g.ASNs, geo.ASN) {
+		return false
+	}
+	return true
+}
+
+// BodyCondition matches a value in the JSON payload
+type BodyCondition struct {
+	// Path is a JSONPath expression, e.g. $.action
+	Path string `yaml:"Path"`
+	// Equals is the expected value, when empty the value only has to exist
+	Equals interface{} `yaml:"Equals"`
+
+	path   *jsonpath.Path
+	equals interface{}
+}
+
+// prepare compiles the path and normalizes the expected value to its JSON
+// representation, so YAML and JSON numbers compare equal
+func (b *BodyCondition) prepare() error {
+	path, err := jsonpath.Compile(b.Path)
+	if err != nil {
+		return err
+	}
+	b.path = path
+
+	if b.Equals != nil {
+		data, err := json.Marshal(b.Equals)
+		if err != nil {
+			return fmt.Errorf("invalid Equals: %w", err)
+		}
+		if err := json.Unmarshal(data, &b.equals); err != nil {
+			return fmt.Errorf("invalid Equals: %w", err)
+		}
+	}
+	return nil
+}
+
+// match reports whether the decoded payload satisfies the condition
+func (b *BodyCondition) match(body interface{}) bool {
+	value, ok := b.path.Get(body)
+	if !ok {
+		return false
+	}
+	if b.equals == nil {
+		return true
+	}
+	return reflect.DeepEqual(value, b.equals)
+}
+
+// sourceMismatch returns the first rule evaluated before the matched rule
+// which only did not match because of the sender address
+func sourceMismatch(in ruleInput, config *Config, matched *DispatchRule) *DispatchRule {
+	for i := range config.Dispatch {
+		rule := &config.Dispatch[i]
+		if rule == matched {
+			break
+		}
+		if !rule.matchSource(in.RemoteIP) && rule.matchRequest(in) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// findRule finds the dispatch rule matching the request, falling back to
+// the default rule
+func findRule(in ruleInput, config *Config) *DispatchRule {
+	for i := range config.Dispatch {
+		if config.Dispatch[i].match(in) {
+			return &config.Dispatch[i]
+		}
+	}
+	if config.Default != nil && config.Default.match(in) {
+		return config.Default
+	}
+	return nil
+}
+
+// ruleByLabel returns the rule with the label, e.g. the rule an event was
+// stored under, or nil if there is none
+func ruleByLabel(config *Config, label string) *DispatchRule {
+	for i := range config.Dispatch {
+		if config.Dispatch[i].label() == label {
+			return &config.Dispatch[i]
+		}
+	}
+	if config.Default != nil && config.Default.label() == label {
+		return config.Default
+	}
+	return nil
+}
+
+// ruleTargets are the targets rendered for a matching rule
+type ruleTargets struct {
+	rule    *DispatchRule
+	targets []Target
+}
+
+// renderAdditional returns the other rules matching the request and their
+// targets when Match is all. Rules outside their active windows and
+// targets already receiving the event, starting with the primary rule
+// targets, are left out.
+func renderAdditional(in ruleInput, config *Config, primary *DispatchRule, targets []Target) ([]ruleTargets, error) {
+	if config.Match != MatchAll || primary == nil {
+		return nil, nil
+	}
+
+	now := time.Now()
+	seen := map[string]bool{}
+	for _, t := range targets {
+		seen[t.URL] = true
+	}
+	var additional []ruleTargets
+	for i := range config.Dispatch {
+		rule := &config.Dispatch[i]
+		if rule == primary || rule.Drop || !rule.match(in) || !rule.allowsMethod(in.Method) || !rule.forwardsAt(now) || !rule.sampled() {
+			continue
+		}
+		rendered, err := rule.renderTargets(in)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.label(), err)
+		}
+		var unique []Target
+		for _, t := range rendered {
+			if !seen[t.URL] {
+				seen[t.URL] = true
+				unique = append(unique, t)
+			}
+		}
+		if len(unique) > 0 {
+			additional = append(additional, ruleTargets{rule: rule, targets: unique})
+		}
+	}
+	return additional, nil
+}
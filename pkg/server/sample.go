@@ -0,0 +1,38 @@
+package server
+
+import (
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var sampledOutCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "webhook_dispatcher_sampled_out_total",
+	Help: "Number of events stored but not forwarded because of the rule SamplePercent",
+}, []string{"rule"})
+
+func init() {
+	prometheus.MustRegister(sampledOutCounter)
+}
+
+// prepareSample validates SamplePercent
+func (r *DispatchRule) prepareSample() error {
+	if r.SamplePercent != nil && (*r.SamplePercent < 0 || *r.SamplePercent > 100) {
+		return fmt.Errorf("rule %s: SamplePercent must be between 0 and 100", r.label())
+	}
+	return nil
+}
+
+// sampled reports whether an event is selected for forwarding by the rule
+// SamplePercent, counting the events left out
+func (r *DispatchRule) sampled() bool {
+	if r.SamplePercent == nil || *r.SamplePercent >= 100 {
+		return true
+	}
+	if rand.Float64()*100 < *r.SamplePercent {
+		return true
+	}
+	sampledOutCounter.WithLabelValues(r.label()).Inc()
+	return false
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/jsonschema"
+)
+
+// SchemaVersion is a version of a registered payload schema
+type SchemaVersion struct {
+	Version int `yaml:"Version"`
+	// Schema is a JSON Schema, see jsonschema.Compile for the supported
+	// keywords
+	Schema interface{} `yaml:"Schema"`
+
+	compiled *jsonschema.Schema
+}
+
+// prepareSchemas compiles the registered schemas, sorting versions newest
+// first so events are tagged with the newest version they match
+func (c *Config) prepareSchemas() error {
+	for name, versions := range c.Schemas {
+		if len(versions) == 0 {
+			return fmt.Errorf("schema %s: no versions", name)
+		}
+		seen := map[int]bool{}
+		for i := range versions {
+			v := &versions[i]
+			if v.Version < 1 || seen[v.Version] {
+				return fmt.Errorf("schema %s: versions must be unique positive numbers", name)
+			}
+			seen[v.Version] = true
+			compiled, err := jsonschema.Compile(v.Schema)
+			if err != nil {
+				return fmt.Errorf("schema %s version %d: %w", name, v.Version, err)
+			}
+			v.compiled = compiled
+		}
+		sort.Slice(versions, func(i, j int) bool {
+			return versions[i].Version > versions[j].Version
+		})
+	}
+	return nil
+}
+
+// matchSchema returns the newest version of the rule schema the payload
+// is valid against
+func (r *DispatchRule) matchSchema(body interface{}) (int, error) {
+	var errs []string
+	for _, v := range r.schema {
+		err := v.compiled.Validate(body)
+		if err == nil {
+			return v.Version, nil
+		}
+		errs = append(errs, fmt.Sprintf("version %d: %v", v.Version, err))
+	}
+	return 0, fmt.Errorf("payload matches no version of schema %s (%s)", r.Schema, strings.Join(errs, "; "))
+}
+
+// acceptsSchemaVersion reports whether the target receives events tagged
+// with the schema version, events without a version are always accepted
+func (t *Target) acceptsSchemaVersion(version int) bool {
+	if version == 0 || len(t.SchemaVersions) == 0 {
+		return true
+	}
+	for _, v := range t.SchemaVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// filterSchemaVersion returns the targets accepting the schema version
+func filterSchemaVersion(targets []Target, version int) []Target {
+	if version == 0 {
+		return targets
+	}
+	var accepted []Target
+	for _, t := range targets {
+		if t.acceptsSchemaVersion(version) {
+			accepted = append(accepted, t)
+		}
+	}
+	return accepted
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Secret is a secret value given inline, via an environment variable or
+// read from a file
+type Secret struct {
+	Value    string `yaml:"Value"`
+	FromEnv  string `yaml:"FromEnv"`
+	FromFile string `yaml:"FromFile"`
+
+	resolved string
+}
+
+// load resolves the secret value, called when the config is loaded
+func (s *Secret) load() error {
+	switch {
+	case s.FromEnv != "":
+		v, ok := os.LookupEnv(s.FromEnv)
+		if !ok {
+			return fmt.Errorf("environment variable %s is not set", s.FromEnv)
+		}
+		s.resolved = v
+	case s.FromFile != "":
+		data, err := os.ReadFile(s.FromFile)
+		if err != nil {
+			return err
+		}
+		s.resolved = strings.TrimSpace(string(data))
+	default:
+		s.resolved = s.Value
+	}
+	return nil
+}
+
+// Get returns the resolved secret value
+func (s *Secret) Get() string {
+	return s.resolved
+}
@@ -4,54 +4,28 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
-	"regexp"
 	"strings"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
-	"gopkg.in/yaml.v3"
 )
 
-var ctx = context.Background()
 var enableLogging bool
+var trustProxy bool
+var startTime = time.Now()
 
+// Per-stage timeouts, configurable via STORAGE_TIMEOUT and PROCESSING_TIMEOUT
 var (
-	redisEventsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "webhook_dispatcher_redis_events_total",
-		Help: "Total number of events stored in Redis",
-	})
-	mongodbEventsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "webhook_dispatcher_mongodb_events_total",
-		Help: "Total number of events stored in MongoDB",
-	})
+	storageTimeout    = 5 * time.Second
+	processingTimeout = 30 * time.Second
 )
 
-func init() {
-	prometheus.MustRegister(redisEventsGauge)
-	prometheus.MustRegister(mongodbEventsGauge)
-}
-
-// Config represents the webhook dispatch configuration
-type Config struct {
-	Meta struct {
-		SchemaVersion int `yaml:"SchemaVersion"`
-	} `yaml:"Meta"`
-	Dispatch []DispatchRule `yaml:"Dispatch"`
-}
-
-// DispatchRule represents a single dispatch rule
-type DispatchRule struct {
-	Path    string   `yaml:"Path"`
-	Targets []string `yaml:"Targets"`
-}
-
 // Server starts the webhook server
 func Server() {
 	// Check if logging is enabled
@@ -60,6 +34,44 @@ func Server() {
 		log.Printf("Request logging enabled")
 	}
 
+	trustProxy = os.Getenv("TRUST_PROXY") == "1"
+
+	var err error
+	geoDB, err = openGeoIP()
+	if err != nil {
+		log.Fatalf("Failed to open GeoIP database: %v", err)
+	}
+	if geoDB != nil {
+		log.Printf("GeoIP enrichment enabled")
+	}
+
+	deliveryManifests, err = openManifestLog()
+	if err != nil {
+		log.Fatalf("Failed to open delivery manifest log: %v", err)
+	}
+	if deliveryManifests != nil {
+		defer deliveryManifests.Close()
+		log.Printf("Signed delivery manifests enabled")
+	}
+
+	deliveryLog, err = openDeliveryLog()
+	if err != nil {
+		log.Fatalf("Failed to open delivery log: %v", err)
+	}
+	if deliveryLog != nil {
+		defer deliveryLog.Close()
+		log.Printf("Recording deliveries to %s", os.Getenv("DELIVERY_LOG"))
+	}
+
+	storageTimeout = durationFromEnv("STORAGE_TIMEOUT", storageTimeout)
+	processingTimeout = durationFromEnv("PROCESSING_TIMEOUT", processingTimeout)
+	streamThreshold = sizeFromEnv("STREAM_THRESHOLD", streamThreshold)
+	maxBodySize = sizeFromEnv("MAX_BODY_SIZE", maxBodySize)
+	if workers := deliveryWorkersFromEnv(); workers > 0 {
+		deliveries = newDeliveryQueue(workers)
+		log.Printf("Limiting concurrent deliveries to %d workers", workers)
+	}
+
 	// Load config
 	configPath := os.Getenv("CONFIG")
 	if configPath == "" {
@@ -75,161 +87,113 @@ func Server() {
 		log.Printf("Loaded config from %s with %d dispatch rules", configPath, len(config.Dispatch))
 	}
 
-	// Initialize Redis storage (always required)
-	redisHost := os.Getenv("REDIS")
-	if redisHost == "" {
-		redisHost = "127.0.0.1"
-	}
+	applyConfigSettings(config)
 
-	redisStore, err := storage.NewRedisStorage(redisHost)
-	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+	driftDetection = driftDetectionFromEnv()
+	if driftDetection {
+		config.needsBody = true
+		log.Printf("Payload schema drift detection enabled")
 	}
-	log.Printf("Connected to Redis at %s:6379", redisHost)
-
-	// Check if MongoDB is also configured
-	mongoURI := os.Getenv("MONGODB_URI")
-	var store storage.Storage
-	var mongoStore *storage.MongoDBStorage
 
-	if mongoURI != "" {
-		// MongoDB is configured, use dual storage (Redis + MongoDB)
-		mongoDatabase := os.Getenv("MONGODB_DATABASE")
-		if mongoDatabase == "" {
-			mongoDatabase = "webhook-dispatcher"
+	if VerifyTargets || os.Getenv("VERIFY_TARGETS") == "1" {
+		if failed := probeTargets(config); failed > 0 {
+			log.Fatalf("Target verification failed, %d targets unreachable", failed)
 		}
-		mongoCollection := os.Getenv("MONGODB_COLLECTION")
-		if mongoCollection == "" {
-			mongoCollection = "events"
-		}
-
-		var err error
-		mongoStore, err = storage.NewMongoDBStorage(mongoURI, mongoDatabase, mongoCollection)
-		if err != nil {
-			log.Fatalf("Failed to connect to MongoDB: %v", err)
-		}
-		log.Printf("Connected to MongoDB at %s (database: %s, collection: %s)", mongoURI, mongoDatabase, mongoCollection)
-
-		// Use dual storage
-		store = storage.NewDualStorage(redisStore, mongoStore)
-		log.Printf("Using dual storage: Redis (primary) + MongoDB (secondary)")
-	} else {
-		// Use only Redis
-		store = redisStore
-		log.Printf("Using Redis storage only")
+		log.Printf("All targets reachable")
 	}
 
+	store := setupStorage()
 	defer store.Close()
 
 	// Start metrics collection goroutine
-	go updateMetrics(redisStore, mongoStore)
+	go updateMetrics()
+	startMetricsPush()
+	startBacklogMirror()
+	startTrashPurge(store)
+	setupDeadLetters(store)
+	setupDeliveryTracking(store)
+	liveConfig.Store(config)
+	startRuleSync(store, configPath)
+	startDeliveryStream()
 
 	// Create HTTP handlers
 	http.Handle("/metrics", promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	auth, err := newAPIAuth(os.Getenv("ADMIN_TOKEN"), config)
+	if err != nil {
+		log.Fatalf("Failed to set up admin authentication: %v", err)
+	}
+	registerAPI(http.DefaultServeMux, store, config, auth)
+	registerQueueAPI(http.DefaultServeMux, config, auth)
+	http.HandleFunc("/", withRecovery(store, func(w http.ResponseWriter, r *http.Request) {
+		// Rules may change at runtime, see startRuleSync
+		config := liveConfig.Load()
 		// Show homepage for GET requests to root path
 		if r.Method == "GET" && r.URL.Path == "/" {
-			handleHomepage(w, r)
+			handleHomepage(w, r, config)
 			return
 		}
 		handleWebhook(w, r, store, config)
-	})
+	}))
 
 	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8000"
+	addrs, err := listenAddrs()
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
 	}
-	addr := fmt.Sprintf(":%s", port)
-	log.Printf("Starting webhook server on %s", addr)
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	lns, err := listen(addrs)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+	for _, ln := range lns {
+		log.Printf("Starting webhook server on %s", ln.Addr())
+	}
+	shutdownTimeout := durationFromEnv("SHUTDOWN_TIMEOUT", 30*time.Second)
+	if err := serve(lns, http.DefaultServeMux, shutdownTimeout); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
 
-// loadConfig loads and parses the config file
-func loadConfig(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
+// handleWebhook processes incoming webhook requests
+func handleWebhook(w http.ResponseWriter, r *http.Request, store storage.Storage, config *Config) {
+	in := newRuleInput(r)
+	rule := findRule(in, config)
+	setResponseHeaders(w, rule, config)
+	setCORSHeaders(w, r, config)
+
+	// HEAD and OPTIONS probes are answered for the rule the webhook would
+	// match
+	if isProbe(r, rule) {
+		in.Method = probeMethod(r)
+		rule = findRule(in, config)
+		setResponseHeaders(w, rule, config)
+		handleProbe(w, r, rule, config)
+		return
 	}
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, err
+	if rule != nil && !rule.allowsMethod(r.Method) {
+		w.Header().Set("Allow", strings.Join(rule.Methods, ", "))
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+		return
 	}
 
-	return &config, nil
-}
-
-// handleHomepage serves the homepage
-func handleHomepage(w http.ResponseWriter, r *http.Request) {
-	html := `<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Webhook Dispatcher</title>
-    <style>
-        body {
-            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, "Helvetica Neue", Arial, sans-serif;
-            max-width: 800px;
-            margin: 50px auto;
-            padding: 20px;
-            line-height: 1.6;
-            color: #333;
-        }
-        h1 {
-            color: #2c3e50;
-        }
-        .description {
-            font-size: 1.1em;
-            margin: 20px 0;
-        }
-        .status {
-            background-color: #d4edda;
-            border: 1px solid #c3e6cb;
-            color: #155724;
-            padding: 12px;
-            border-radius: 4px;
-            margin-top: 20px;
-        }
-        .right {
-            position: fixed;
-            bottom: 0px;
-            right: 20px;
-            font-size: 1.2em;
-        }
-    </style>
-</head>
-<body>
-    <h1>Webhook Dispatcher</h1>
-    <div class="description">
-        <p>A simple webhook receiver that stores webhook payloads and can forward them to configured targets.</p>
-    </div>
-    <div class="status">
-        <strong>Status:</strong> Service is running and ready to receive webhooks
-    </div>
-    <p class="right">
-        <a href="https://github.com/sikalabs/webhook-dispatcher" target="_blank" style="color:black">webhook-dispatcher</a> by <a href="https://sikalabs.com" target="_blank" style="color:black">sikalabs</a>
-    </p>
-</body>
-</html>`
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, html)
-}
-
-// handleWebhook processes incoming webhook requests
-func handleWebhook(w http.ResponseWriter, r *http.Request, store storage.Storage, config *Config) {
-	// Read request body
-	body, err := io.ReadAll(r.Body)
+	// Read request body, large bodies are spilled to disk
+	p, err := readBody(r.Body, r.ContentLength)
+	if errors.Is(err, errBodyTooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, fmt.Sprintf("Payloads are limited to %d bytes", maxBodySize))
+		log.Printf("Rejected body over %d bytes from %s", maxBodySize, r.RemoteAddr)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, ErrCodeReadBody, "Failed to read request body")
 		log.Printf("Error reading body: %v", err)
 		return
 	}
 	defer r.Body.Close()
+	if p.spilled() {
+		handleStreamedWebhook(w, r, in, rule, p, store, config)
+		return
+	}
+	body := p.data
 
 	// Log incoming request if enabled
 	if enableLogging {
@@ -243,151 +207,300 @@ func handleWebhook(w http.ResponseWriter, r *http.Request, store storage.Storage
 				log.Printf("  %s: %s", name, value)
 			}
 		}
-		log.Printf("Body: %s", string(body))
+		log.Printf("Body: %s", previewConfig.render(body))
 		log.Printf("========================")
 	}
 
-	// Parse body as JSON (validate it's valid JSON)
-	var jsonData interface{}
-	if err := json.Unmarshal(body, &jsonData); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		log.Printf("Invalid JSON from %s: %v", r.RemoteAddr, err)
+	// Verify signature if the rule requires it
+	if !verifySignature(w, r, rule, p) {
 		return
 	}
 
-	// Slugify the path
-	slugifiedPath := slugify(r.URL.Path)
+	// Validate the body is JSON, parsing it only when rules need the
+	// payload, which is the most expensive step of ingestion. Rules
+	// matching other content types take the body as is.
+	switch {
+	case rule.acceptsRaw(r.Header.Get("Content-Type")):
+	case config.needsBody:
+		var jsonData interface{}
+		if err := json.Unmarshal(body, &jsonData); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidJSON, err.Error())
+			log.Printf("Invalid JSON from %s: %v", r.RemoteAddr, err)
+			return
+		}
+		in.Body, in.HasBody = jsonData, true
+	case !json.Valid(body):
+		err := validateJSON(bytes.NewReader(body))
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidJSON, err.Error())
+		log.Printf("Invalid JSON from %s: %v", r.RemoteAddr, err)
+		return
+	}
 
-	// Generate key: webhook-<slugified-path>-<unix-timestamp>
-	unixTime := time.Now().Unix()
-	key := fmt.Sprintf("webhook-%s-%d", slugifiedPath, unixTime)
+	// Evaluate body conditions, the payload may select a different rule
+	if in.HasBody {
+		if matched := findRule(in, config); matched != rule {
+			rule = matched
+			setResponseHeaders(w, rule, config)
+			if !verifySignature(w, r, rule, p) {
+				return
+			}
+		}
+	}
 
-	// Store in storage backend
-	err = store.Store(ctx, key, r.URL.Path, string(body))
-	if err != nil {
-		http.Error(w, "Failed to store webhook", http.StatusInternalServerError)
-		log.Printf("Failed to store webhook: %v", err)
+	if rule != nil && rule.Drop {
+		writeDropped(w, r, rule)
 		return
 	}
 
-	log.Printf("Stored webhook: %s (path: %s, size: %d bytes)", key, r.URL.Path, len(body))
+	// Tag the event with the schema version the payload matches
+	if rule != nil && rule.Schema != "" {
+		version, err := rule.matchSchema(in.Body)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, ErrCodeSchemaMismatch, err.Error())
+			log.Printf("Rejected webhook for %s: %v", r.URL.Path, err)
+			return
+		}
+		in.SchemaVersion = version
+	}
 
-	// Forward to targets based on dispatch rules
-	targets := findTargets(r.URL.Path, config)
-	if len(targets) > 0 {
-		forwardToTargets(targets, body, r.Header)
+	releaseDedup, ok := rule.claimDedup(r.Context(), in)
+	if !ok {
+		writeDuplicate(w, r, rule)
+		return
 	}
+	// Release the dedup key unless the webhook is accepted, so the sender
+	// can retry after an error
+	accepted := false
+	defer func() {
+		if !accepted {
+			releaseDedup()
+		}
+	}()
 
-	// Send success response
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "Webhook received and stored: %s\n", key)
-}
+	key := eventKey(r.URL.Path, rule)
+	in.Key = key
+	p.key, p.path = key, r.URL.Path
 
-// findTargets finds matching targets for the given path
-func findTargets(path string, config *Config) []string {
-	for _, rule := range config.Dispatch {
-		if rule.Path == path {
-			return rule.Targets
+	// Render target URL templates before storing, so a bad template does
+	// not leave an event that was never forwarded
+	var targets []Target
+	if rule != nil {
+		targets, err = rule.renderTargets(in)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, ErrCodeUnprocessable, err.Error())
+			log.Printf("Failed to render targets for %s: %v", r.URL.Path, err)
+			return
 		}
 	}
-	return nil
-}
+	if rule != nil && len(targets) > 0 && !rule.forwardsAt(time.Now()) {
+		log.Printf("Rule %s is outside its active windows, not forwarding %s", rule.label(), r.URL.Path)
+		targets = nil
+	}
+	if rule != nil && len(targets) > 0 && !rule.sampled() {
+		targets = nil
+	}
+	additional, err := renderAdditional(in, config, rule, targets)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, ErrCodeUnprocessable, err.Error())
+		log.Printf("Failed to render targets for %s: %v", r.URL.Path, err)
+		return
+	}
 
-// forwardToTargets forwards the webhook to all target URLs
-func forwardToTargets(targets []string, body []byte, headers http.Header) {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	// Record payload metrics, labeled by the matching rule path
+	pathLabel := rule.label()
+	observePayload(rule, pathLabel, r.Header.Get("Content-Type"), len(body))
+	if driftDetection && in.HasBody {
+		payloadShapes.observe(pathLabel, in.Body)
 	}
 
-	for _, target := range targets {
-		go func(url string) {
-			req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
-			if err != nil {
-				log.Printf("Failed to create request for %s: %v", url, err)
+	// Store in storage backend
+	event := newEvent(r, in, key, rule)
+	flagEvent(event, in, config, rule)
+	event.Body = bytesToString(body)
+	event.Payload = in.Body
+	if in.SchemaVersion != 0 {
+		event.Schema, event.SchemaVersion = rule.Schema, in.SchemaVersion
+	}
+	stored := false
+	if rule.stores() {
+		storeCtx, cancel := context.WithTimeout(r.Context(), storageTimeout)
+		defer cancel()
+		err = store.Store(storeCtx, event)
+		stored = err == nil
+		if err != nil {
+			if !handleStorageFailure(w, r, rule, event, err, true) {
 				return
 			}
+		} else {
+			log.Printf("Stored webhook: %s (path: %s, rule: %s, size: %d bytes)", key, r.URL.Path, rule.label(), len(body))
+		}
+	}
+	activity.recordEvent(key, r.URL.Path, len(body))
 
-			// Copy relevant headers
-			req.Header.Set("Content-Type", headers.Get("Content-Type"))
-			if req.Header.Get("Content-Type") == "" {
-				req.Header.Set("Content-Type", "application/json")
-			}
+	// Apply the rule rate limit, the event is stored either way
+	delay, allowed := time.Duration(0), true
+	if rule != nil && len(targets) > 0 {
+		delay, allowed = rule.throttle()
+	}
+	if !allowed {
+		writeRateLimited(w, r, rule)
+		return
+	}
 
-			resp, err := client.Do(req)
+	// Forward to targets based on dispatch rules
+	var results []DeliveryResult
+	if rule != nil && len(targets) > 0 {
+		if rule.Sync {
+			if err := sleepContext(r.Context(), delay); err != nil {
+				log.Printf("Webhook %s not forwarded, request ended while rate limited: %v", key, err)
+				return
+			}
+			results, err = dispatchSync(r.Context(), rule, targets, p, rule.forwardHeaders(in))
 			if err != nil {
-				log.Printf("Failed to forward webhook to %s: %v", url, err)
+				writeError(w, http.StatusBadGateway, ErrCodeProcessingFailed, err.Error())
+				log.Printf("Failed to process webhook for %s: %v", r.URL.Path, err)
+				return
+			}
+			if rule.OnTargetFailure == TargetFailureReject && allFailed(results) {
+				writeError(w, http.StatusBadGateway, ErrCodeDeliveryFailed, fmt.Sprintf("All %d deliveries failed", len(results)))
 				return
 			}
-			defer resp.Body.Close()
+		} else {
+			dispatchAfter(delay, rule, targets, p, rule.forwardHeaders(in))
+		}
+	}
+	for _, rt := range additional {
+		if delay, ok := rt.rule.throttle(); ok {
+			dispatchAfter(delay, rt.rule, rt.targets, p, rt.rule.forwardHeaders(in))
+		} else {
+			log.Printf("Rate limit of rule %s exceeded, not forwarding %s", rt.rule.label(), r.URL.Path)
+		}
+	}
 
-			log.Printf("Forwarded webhook to %s (status: %d)", url, resp.StatusCode)
-		}(target)
+	// Send success response
+	accepted = true
+	if rule != nil && rule.Sync && rule.OnTargetFailure == TargetFailureReport {
+		writeDeliveryResults(w, key, stored, results)
+		return
 	}
+	writeAccepted(w, rule, responseData{Key: key, Stored: stored, Path: r.URL.Path, Payload: event.Body})
 }
 
-// slugify converts a path into a slug suitable for Redis keys
-func slugify(path string) string {
-	// Remove leading/trailing slashes
-	path = strings.Trim(path, "/")
-
-	// If empty path, use "root"
-	if path == "" {
-		return "root"
+// newEvent returns the event of a request without its body
+func newEvent(r *http.Request, in ruleInput, key string, rule *DispatchRule) *storage.Event {
+	event := &storage.Event{
+		Key:       key,
+		Path:      r.URL.Path,
+		RawPath:   r.URL.EscapedPath(),
+		Timestamp: time.Now(),
 	}
-
-	// Replace slashes with hyphens
-	path = strings.ReplaceAll(path, "/", "-")
-
-	// Remove or replace special characters
-	reg := regexp.MustCompile("[^a-zA-Z0-9-_]+")
-	path = reg.ReplaceAllString(path, "-")
-
-	// Remove consecutive hyphens
-	reg = regexp.MustCompile("-+")
-	path = reg.ReplaceAllString(path, "-")
-
-	// Trim hyphens from start/end
-	path = strings.Trim(path, "-")
-
-	// Convert to lowercase
-	path = strings.ToLower(path)
-
-	return path
+	if rule != nil {
+		event.Rule, event.Labels = rule.label(), rule.Labels
+	}
+	event.TraceParent, event.TraceState = traceContext(r.Header)
+	if in.RemoteIP != nil {
+		event.RemoteIP = in.RemoteIP.String()
+	}
+	event.Geo = in.Geo
+	return event
 }
 
-// updateMetrics periodically updates Prometheus metrics
-func updateMetrics(redisStore *storage.RedisStorage, mongoStore *storage.MongoDBStorage) {
-	ticker := time.NewTicker(15 * time.Second)
-	defer ticker.Stop()
+// flagEvent flags an event which a rule did not match only because of the
+// sender address
+func flagEvent(event *storage.Event, in ruleInput, config *Config, rule *DispatchRule) {
+	if skipped := sourceMismatch(in, config, rule); skipped != nil {
+		event.Flags = append(event.Flags, "source_mismatch")
+		log.Printf("Webhook %s from %s does not match the sources of rule %s", event.Key, in.RemoteIP, skipped.label())
+	}
+}
 
-	// Update immediately on start
-	updateMetricsOnce(redisStore, mongoStore)
+// handleStorageFailure applies the OnStorageFailure policy of the rule and
+// reports whether the webhook should still be accepted, otherwise the error
+// response has been written. Events that cannot be spooled are rejected.
+func handleStorageFailure(w http.ResponseWriter, r *http.Request, rule *DispatchRule, event *storage.Event, err error, spoolable bool) bool {
+	policy := storageFailurePolicy(rule)
+	if policy == StorageFailureSpool && !spoolable {
+		policy = StorageFailureReject
+	}
 
-	for range ticker.C {
-		updateMetricsOnce(redisStore, mongoStore)
+	switch policy {
+	case StorageFailureSpool:
+		if spool == nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeStorageFailed, "Failed to store webhook")
+			log.Printf("Failed to store webhook, spool not configured: %v", err)
+			return false
+		}
+		if spoolErr := spool.Append(event); spoolErr != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeStorageFailed, "Failed to store webhook")
+			log.Printf("Failed to store webhook: %v, failed to spool: %v", err, spoolErr)
+			return false
+		}
+		log.Printf("Storage write failed, spooled %s to disk: %v", event.Key, err)
+	case StorageFailureForward:
+		log.Printf("Failed to store webhook %s, forwarding anyway: %v", event.Key, err)
+	default:
+		if errors.Is(err, storage.ErrUnavailable) {
+			w.Header().Set("Retry-After", "10")
+			writeError(w, http.StatusServiceUnavailable, ErrCodeStorageUnavailable, "Storage is not available yet")
+			log.Printf("Rejected webhook for %s: %v", r.URL.Path, err)
+			return false
+		}
+		writeError(w, http.StatusInternalServerError, ErrCodeStorageFailed, "Failed to store webhook")
+		log.Printf("Failed to store webhook: %v", err)
+		return false
 	}
+	return true
 }
 
-func updateMetricsOnce(redisStore *storage.RedisStorage, mongoStore *storage.MongoDBStorage) {
-	if redisStore != nil {
-		count, err := redisStore.Count(ctx)
+// verifySignature verifies the request signature or token if the rule
+// requires it, responding 401 when it does not match
+func verifySignature(w http.ResponseWriter, r *http.Request, rule *DispatchRule, body payload) bool {
+	if rule == nil || !rule.Verify.enabled() {
+		return true
+	}
+	var secret string
+	var ok bool
+	if rule.Verify.needsBody() {
+		reader, err := body.open()
 		if err != nil {
-			log.Printf("Failed to get Redis event count: %v", err)
-		} else {
-			redisEventsGauge.Set(float64(count))
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, "Failed to read request body")
+			log.Printf("Failed to open request body: %v", err)
+			return false
 		}
+		defer reader.Close()
+		secret, ok = rule.Verify.verify(r.Header, reader)
 	} else {
-		redisEventsGauge.Set(-1)
+		secret, ok = rule.Verify.verifyToken(r.Header)
 	}
+	signatureVerificationsCounter.WithLabelValues(rule.label(), secret).Inc()
+	if !ok {
+		writeError(w, http.StatusUnauthorized, ErrCodeInvalidSignature, "Missing or invalid "+rule.Verify.credential())
+		log.Printf("Invalid %s from %s for %s", rule.Verify.credential(), r.RemoteAddr, r.URL.Path)
+		return false
+	}
+	return true
+}
 
-	if mongoStore != nil {
-		count, err := mongoStore.Count(ctx)
-		if err != nil {
-			log.Printf("Failed to get MongoDB event count: %v", err)
-		} else {
-			mongodbEventsGauge.Set(float64(count))
+// allFailed reports whether there were deliveries and none succeeded
+func allFailed(results []DeliveryResult) bool {
+	for _, result := range results {
+		if result.OK() {
+			return false
 		}
-	} else {
-		mongodbEventsGauge.Set(-1)
 	}
+	return len(results) > 0
+}
+
+// durationFromEnv parses a duration (e.g. "5s") from an environment variable
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Warning: Invalid %s %q, using default %s", name, value, def)
+		return def
+	}
+	return d
 }
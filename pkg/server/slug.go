@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SlugConfig controls how request paths are turned into the slugs of event
+// keys. The defaults lowercase the path and replace everything but
+// letters, digits, hyphens and underscores with hyphens.
+type SlugConfig struct {
+	// PreserveCase keeps upper case letters
+	PreserveCase bool `yaml:"PreserveCase"`
+	// KeepDots keeps dots, e.g. in versioned paths like /v1.2/orders
+	KeepDots bool `yaml:"KeepDots"`
+	// Separator replaces slashes and other characters, defaults to "-"
+	Separator string `yaml:"Separator"`
+}
+
+// slugConfig is the slugification policy in effect
+var slugConfig SlugConfig
+
+// slugSeparatorRegexp restricts separators to characters safe in keys
+var slugSeparatorRegexp = regexp.MustCompile(`^[a-zA-Z0-9._:-]+$`)
+
+var (
+	slugInvalidRegexp         = regexp.MustCompile("[^a-zA-Z0-9-_]+")
+	slugInvalidKeepDotsRegexp = regexp.MustCompile(`[^a-zA-Z0-9-_.]+`)
+)
+
+// prepare validates the separator
+func (c SlugConfig) prepare() error {
+	if c.Separator != "" && !slugSeparatorRegexp.MatchString(c.Separator) {
+		return fmt.Errorf("slug: invalid Separator %q", c.Separator)
+	}
+	return nil
+}
+
+// slugify converts a path into a slug suitable for Redis keys
+func slugify(path string) string {
+	sep := slugConfig.Separator
+	if sep == "" {
+		sep = "-"
+	}
+
+	// Remove leading/trailing slashes
+	path = strings.Trim(path, "/")
+
+	// If empty path, use "root"
+	if path == "" {
+		return "root"
+	}
+
+	// Replace slashes and special characters with the separator
+	invalid := slugInvalidRegexp
+	if slugConfig.KeepDots {
+		invalid = slugInvalidKeepDotsRegexp
+	}
+	path = strings.ReplaceAll(path, "/", "\x00")
+	path = invalid.ReplaceAllString(path, sep)
+
+	// Remove consecutive separators and trim them from start/end
+	parts := strings.Split(path, sep)
+	kept := parts[:0]
+	for _, part := range parts {
+		if part != "" {
+			kept = append(kept, part)
+		}
+	}
+	path = strings.Join(kept, sep)
+
+	if !slugConfig.PreserveCase {
+		path = strings.ToLower(path)
+	}
+	if path == "" {
+		return "root"
+	}
+	return path
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// PathStats holds payload statistics for a single path
+type PathStats struct {
+	Count        int64            `json:"count"`
+	TotalBytes   int64            `json:"total_bytes"`
+	MinBytes     int              `json:"min_bytes"`
+	MaxBytes     int              `json:"max_bytes"`
+	AvgBytes     float64          `json:"avg_bytes"`
+	LastBytes    int              `json:"last_bytes"`
+	LastSeen     time.Time        `json:"last_seen"`
+	ContentTypes map[string]int64 `json:"content_types"`
+}
+
+// statsCollector aggregates payload statistics per path in memory
+type statsCollector struct {
+	mu    sync.Mutex
+	paths map[string]*PathStats
+}
+
+var payloadStats = &statsCollector{paths: map[string]*PathStats{}}
+
+// observe records a single payload
+func (s *statsCollector) observe(path string, contentType string, size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ps, ok := s.paths[path]
+	if !ok {
+		ps = &PathStats{
+			MinBytes:     size,
+			ContentTypes: map[string]int64{},
+		}
+		s.paths[path] = ps
+	}
+
+	ps.Count++
+	ps.TotalBytes += int64(size)
+	if size < ps.MinBytes {
+		ps.MinBytes = size
+	}
+	if size > ps.MaxBytes {
+		ps.MaxBytes = size
+	}
+	ps.AvgBytes = float64(ps.TotalBytes) / float64(ps.Count)
+	ps.LastBytes = size
+	ps.LastSeen = time.Now()
+	ps.ContentTypes[contentType]++
+}
+
+// snapshot returns a copy of the current statistics
+func (s *statsCollector) snapshot() map[string]PathStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]PathStats, len(s.paths))
+	for path, ps := range s.paths {
+		cp := *ps
+		cp.ContentTypes = make(map[string]int64, len(ps.ContentTypes))
+		for ct, n := range ps.ContentTypes {
+			cp.ContentTypes[ct] = n
+		}
+		out[path] = cp
+	}
+	return out
+}
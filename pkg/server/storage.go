@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+)
+
+// Connected storage backends, nil until connected
+var (
+	connectedRedis   atomic.Pointer[storage.RedisStorage]
+	connectedMongoDB atomic.Pointer[storage.MongoDBStorage]
+)
+
+// maxConnectBackoff caps the delay between storage connection attempts
+const maxConnectBackoff = 30 * time.Second
+
+// OpenStorage connects to the storage backends configured via environment
+// variables, for use by CLI commands working with stored events
+func OpenStorage() storage.Storage {
+	store, err := connectStorageWithRetry(1, time.Second)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	return store
+}
+
+// spool keeps events whose storage write failed, nil unless SPOOL_DIR is set
+var spool *storage.Spool
+
+// setupStorage connects to storage as configured by environment variables
+// and sets up the spool for failed writes if SPOOL_DIR is set.
+//
+// STORAGE_CONNECT_ATTEMPTS (default 1) and STORAGE_CONNECT_BACKOFF (default
+// 1s, doubled after each attempt) control startup retries. With
+// STORAGE_LAZY=reject or STORAGE_LAZY=buffer the server starts immediately
+// and connects in the background, meanwhile rejecting webhooks with 503 or
+// buffering up to STORAGE_BUFFER_SIZE (default 1000) of them in memory.
+func setupStorage() storage.Storage {
+	store := setupBackend()
+
+	spoolDir := os.Getenv("SPOOL_DIR")
+	if spoolDir == "" {
+		return store
+	}
+	var err error
+	spool, err = storage.NewSpool(spoolDir)
+	if err != nil {
+		log.Fatalf("Failed to set up spool: %v", err)
+	}
+	go spool.RunDrain(context.Background(), store, durationFromEnv("SPOOL_DRAIN_INTERVAL", 10*time.Second))
+	log.Printf("Spooling failed storage writes to %s", spoolDir)
+	return store
+}
+
+// setupBackend connects to the storage backends, see setupStorage
+func setupBackend() storage.Storage {
+	attempts := 1
+	if v := os.Getenv("STORAGE_CONNECT_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid STORAGE_CONNECT_ATTEMPTS %q", v)
+		}
+		attempts = n
+	}
+	backoff := durationFromEnv("STORAGE_CONNECT_BACKOFF", time.Second)
+
+	mode := os.Getenv("STORAGE_LAZY")
+	if mode == "" {
+		store, err := connectStorageWithRetry(attempts, backoff)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		return store
+	}
+
+	bufferSize := 0
+	switch mode {
+	case "reject":
+	case "buffer":
+		bufferSize = 1000
+		if v := os.Getenv("STORAGE_BUFFER_SIZE"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				log.Fatalf("Invalid STORAGE_BUFFER_SIZE %q", v)
+			}
+			bufferSize = n
+		}
+	default:
+		log.Fatalf("Invalid STORAGE_LAZY %q, use reject or buffer", mode)
+	}
+
+	lazy := storage.NewLazyStorage(bufferSize)
+	log.Printf("Connecting to storage in background (mode: %s)", mode)
+	go func() {
+		// Retry until connected
+		store, _ := connectStorageWithRetry(0, backoff)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		lazy.SetBackend(ctx, store)
+		log.Printf("Storage is ready")
+	}()
+	return lazy
+}
+
+// connectStorageWithRetry connects to storage, retrying with exponential
+// backoff; attempts 0 retries forever
+func connectStorageWithRetry(attempts int, backoff time.Duration) (storage.Storage, error) {
+	for attempt := 1; ; attempt++ {
+		store, err := connectStorage()
+		if err == nil {
+			return store, nil
+		}
+		if attempts > 0 && attempt >= attempts {
+			return nil, err
+		}
+		log.Printf("Storage connection attempt %d failed: %v, retrying in %s", attempt, err, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxConnectBackoff)
+	}
+}
+
+// connectStorage connects to Redis and, if configured, MongoDB
+func connectStorage() (storage.Storage, error) {
+	// Initialize Redis storage (always required)
+	redisHost := os.Getenv("REDIS")
+	if redisHost == "" {
+		redisHost = "127.0.0.1"
+	}
+
+	redisStore, err := storage.NewRedisStorage(redisHost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	log.Printf("Connected to Redis at %s:6379", redisHost)
+
+	// Check if MongoDB is also configured
+	mongoURI := os.Getenv("MONGODB_URI")
+	var store storage.Storage
+	var mongoStore *storage.MongoDBStorage
+
+	if mongoURI != "" {
+		// MongoDB is configured, use dual storage (Redis + MongoDB)
+		mongoDatabase := os.Getenv("MONGODB_DATABASE")
+		if mongoDatabase == "" {
+			mongoDatabase = "webhook-dispatcher"
+		}
+		mongoCollection := os.Getenv("MONGODB_COLLECTION")
+		if mongoCollection == "" {
+			mongoCollection = "events"
+		}
+
+		mongoStore, err = storage.NewMongoDBStorage(mongoURI, mongoDatabase, mongoCollection)
+		if err != nil {
+			redisStore.Close()
+			return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+		}
+		log.Printf("Connected to MongoDB at %s (database: %s, collection: %s)", mongoURI, mongoDatabase, mongoCollection)
+
+		// Use dual storage
+		store = storage.NewDualStorage(redisStore, mongoStore)
+		log.Printf("Using dual storage: Redis (primary) + MongoDB (secondary)")
+	} else {
+		// Use only Redis
+		store = redisStore
+		log.Printf("Using Redis storage only")
+	}
+
+	connectedRedis.Store(redisStore)
+	connectedMongoDB.Store(mongoStore)
+	return store, nil
+}
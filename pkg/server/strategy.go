@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// Delivery strategies of dispatch rules
+const (
+	// StrategyAll delivers to every target
+	StrategyAll = "all"
+	// StrategyRoundRobin delivers to one target, rotating between them
+	StrategyRoundRobin = "roundrobin"
+	// StrategyWeighted delivers to one target picked at random in
+	// proportion to the target weights
+	StrategyWeighted = "weighted"
+	// StrategyCanary delivers to one target in proportion to the target
+	// weights like weighted, but picked by the event key so the assignment
+	// is deterministic, e.g. weights 90 and 10 to canary a new consumer
+	StrategyCanary = "canary"
+)
+
+// selectTargets returns the targets the event with the key is delivered
+// to according to the rule strategy
+func (r *DispatchRule) selectTargets(key string) []Target {
+	if len(r.Targets) < 2 {
+		return r.Targets
+	}
+	switch r.Strategy {
+	case StrategyRoundRobin:
+		i := (r.rotation.Add(1) - 1) % uint64(len(r.Targets))
+		return r.Targets[i : i+1]
+	case StrategyWeighted:
+		return r.pickWeighted(rand.IntN(r.totalWeight))
+	case StrategyCanary:
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return r.pickWeighted(int(h.Sum32() % uint32(r.totalWeight)))
+	}
+	return r.Targets
+}
+
+// pickWeighted returns the target owning the n-th share of the weights
+func (r *DispatchRule) pickWeighted(n int) []Target {
+	for i := range r.Targets {
+		n -= r.Targets[i].weight()
+		if n < 0 {
+			return r.Targets[i : i+1]
+		}
+	}
+	return r.Targets
+}
+
+// prepareStrategy validates the rule strategy
+func (r *DispatchRule) prepareStrategy() error {
+	switch r.Strategy {
+	case "", StrategyAll:
+	case StrategyRoundRobin:
+		r.rotation = &atomic.Uint64{}
+	case StrategyWeighted, StrategyCanary:
+		r.totalWeight = 0
+		for i := range r.Targets {
+			if w := r.Targets[i].Weight; w != nil && *w < 0 {
+				return fmt.Errorf("target %s: Weight must not be negative", r.Targets[i].URL)
+			}
+			r.totalWeight += r.Targets[i].weight()
+		}
+		if r.totalWeight == 0 && len(r.Targets) > 0 {
+			return fmt.Errorf("%s Strategy requires a target with a positive Weight", r.Strategy)
+		}
+	default:
+		return fmt.Errorf("unknown Strategy %q", r.Strategy)
+	}
+	return nil
+}
+
+// weight returns the target weight, targets without a configured weight
+// count as 1
+func (t *Target) weight() int {
+	if t.Weight == nil {
+		return 1
+	}
+	return *t.Weight
+}
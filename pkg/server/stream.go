@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+)
+
+// handleStreamedWebhook processes a webhook whose body was spilled to disk,
+// see readBody. The body is verified, validated, stored and forwarded
+// without loading it into memory, so body conditions, URL templates using
+// the body, processors and WASM plugins are not available.
+func handleStreamedWebhook(w http.ResponseWriter, r *http.Request, in ruleInput, rule *DispatchRule, body payload, store storage.Storage, config *Config) {
+	// The payload is removed when the request is done, unless handed over
+	// to asynchronous forwarding
+	forwarding := false
+	defer func() {
+		if !forwarding {
+			body.remove()
+		}
+	}()
+
+	if enableLogging {
+		log.Printf("Incoming %s %s from %s with a large body (%d bytes)", r.Method, r.URL.Path, r.RemoteAddr, body.size)
+	}
+
+	if !verifySignature(w, r, rule, body) {
+		return
+	}
+
+	if !rule.acceptsRaw(r.Header.Get("Content-Type")) {
+		reader, err := body.open()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, "Failed to read request body")
+			log.Printf("Failed to open request body: %v", err)
+			return
+		}
+		err = validateJSON(reader)
+		reader.Close()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidJSON, err.Error())
+			log.Printf("Invalid JSON from %s: %v", r.RemoteAddr, err)
+			return
+		}
+	}
+
+	if rule != nil && rule.Drop {
+		writeDropped(w, r, rule)
+		return
+	}
+
+	if rule.needsBody() {
+		writeError(w, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge,
+			fmt.Sprintf("Payloads over %d bytes cannot be processed", streamThreshold))
+		return
+	}
+
+	releaseDedup, ok := rule.claimDedup(r.Context(), in)
+	if !ok {
+		writeDuplicate(w, r, rule)
+		return
+	}
+	accepted := false
+	defer func() {
+		if !accepted {
+			releaseDedup()
+		}
+	}()
+
+	key := eventKey(r.URL.Path, rule)
+	in.Key = key
+	body.key, body.path = key, r.URL.Path
+	var targets []Target
+	if rule != nil {
+		var err error
+		targets, err = rule.renderTargets(in)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, ErrCodeUnprocessable, err.Error())
+			log.Printf("Failed to render targets for %s: %v", r.URL.Path, err)
+			return
+		}
+	}
+	if rule != nil && len(targets) > 0 && !rule.forwardsAt(time.Now()) {
+		log.Printf("Rule %s is outside its active windows, not forwarding %s", rule.label(), r.URL.Path)
+		targets = nil
+	}
+	if rule != nil && len(targets) > 0 && !rule.sampled() {
+		targets = nil
+	}
+	additional, err := renderAdditional(in, config, rule, targets)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, ErrCodeUnprocessable, err.Error())
+		log.Printf("Failed to render targets for %s: %v", r.URL.Path, err)
+		return
+	}
+	for _, rt := range additional {
+		if rt.rule.needsBody() {
+			writeError(w, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge,
+				fmt.Sprintf("Payloads over %d bytes cannot be processed", streamThreshold))
+			return
+		}
+	}
+
+	pathLabel := rule.label()
+	observePayload(rule, pathLabel, r.Header.Get("Content-Type"), int(body.size))
+
+	event := newEvent(r, in, key, rule)
+	flagEvent(event, in, config, rule)
+	event.Size = body.size
+	stored := false
+	if rule.stores() {
+		err = storeStream(r.Context(), store, event, body)
+		stored = err == nil
+		if err != nil {
+			if !handleStorageFailure(w, r, rule, event, err, false) {
+				return
+			}
+		} else {
+			log.Printf("Stored webhook: %s (path: %s, rule: %s, size: %d bytes, streamed)", key, r.URL.Path, rule.label(), body.size)
+		}
+	}
+	activity.recordEvent(key, r.URL.Path, int(body.size))
+
+	// Apply the rule rate limit, the event is stored either way
+	delay, allowed := time.Duration(0), true
+	if rule != nil && len(targets) > 0 {
+		delay, allowed = rule.throttle()
+	}
+	if !allowed {
+		writeRateLimited(w, r, rule)
+		return
+	}
+
+	// Without processors the targets of additional rules can share the
+	// deliveries of the primary rule, delayed by the longest rate limit
+	for _, rt := range additional {
+		ruleDelay, ok := rt.rule.throttle()
+		if !ok {
+			log.Printf("Rate limit of rule %s exceeded, not forwarding %s", rt.rule.label(), r.URL.Path)
+			continue
+		}
+		delay = max(delay, ruleDelay)
+		targets = append(targets, rt.targets...)
+	}
+	if rule != nil && len(targets) > 0 {
+		headers := rule.forwardHeaders(in)
+		if rule.Sync {
+			if err := sleepContext(r.Context(), delay); err != nil {
+				log.Printf("Webhook %s not forwarded, request ended while rate limited: %v", key, err)
+				return
+			}
+			results := forwardToTargetsSync(r.Context(), targets, body, headers)
+			if rule.OnTargetFailure == TargetFailureReject && allFailed(results) {
+				writeError(w, http.StatusBadGateway, ErrCodeDeliveryFailed, fmt.Sprintf("All %d deliveries failed", len(results)))
+				return
+			}
+			if rule.OnTargetFailure == TargetFailureReport {
+				accepted = true
+				writeDeliveryResults(w, key, stored, results)
+				return
+			}
+		} else {
+			forwarding = true
+			time.AfterFunc(delay, func() {
+				defer recoverGoroutine("delayed forwarding of " + key)
+				forwardToTargets(targets, body, headers)
+			})
+		}
+	}
+
+	accepted = true
+	writeAccepted(w, rule, responseData{Key: key, Stored: stored, Path: r.URL.Path})
+}
+
+// storeStream stores an event with a spilled body if the backend supports it
+func storeStream(ctx context.Context, store storage.Storage, event *storage.Event, body payload) error {
+	streamer, ok := store.(storage.StreamStorer)
+	if !ok {
+		return storage.ErrNotSupported
+	}
+	reader, err := body.open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, storageTimeout)
+	defer cancel()
+	return streamer.StoreStream(ctx, event, reader)
+}
+
+// needsBody reports whether the rule has to decode the payload, which is
+// not done for streamed payloads: transforms, schemas, deduplication by a
+// field and conditions on the body
+func (r *DispatchRule) needsBody() bool {
+	return r != nil && (r.transforms() || r.Schema != "" || r.Dedup != nil && r.Dedup.Field != "" ||
+		r.MatchBody != nil || r.NotBody != nil || r.when != nil)
+}
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/version"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultTargetTimeout applies to targets without a configured timeout
+const defaultTargetTimeout = 10 * time.Second
+
+// OutboundConfig configures requests sent to targets
+type OutboundConfig struct {
+	// UserAgent defaults to webhook-dispatcher/<version>
+	UserAgent string `yaml:"UserAgent"`
+	// Headers identifying the dispatcher, added to every forwarded request
+	Headers map[string]string `yaml:"Headers"`
+	// Allow restricts targets to the listed hosts and CIDRs, when empty all
+	// destinations not denied are allowed
+	Allow []string `yaml:"Allow"`
+	// Deny blocks the listed hosts and CIDRs
+	Deny []string `yaml:"Deny"`
+	// AllowLinkLocal permits link-local and cloud metadata addresses, which
+	// are blocked by default
+	AllowLinkLocal bool `yaml:"AllowLinkLocal"`
+	// HTTP2 is auto (default), h2c or off, see HTTP2Auto
+	HTTP2 string `yaml:"HTTP2"`
+
+	policy *hostPolicy
+}
+
+// Target is a forwarding destination. In the config it is either a plain
+// URL string or an object with the URL and options. URLs like
+// pull://<consumer> queue events for a PullConsumer.
+type Target struct {
+	// URL may be a template rendered per request, see targetTemplateData
+	URL string `yaml:"URL" json:"url"`
+	// UserAgent overrides the global outbound user agent
+	UserAgent string `yaml:"UserAgent" json:"-"`
+	// Headers added to requests sent to the target, overriding outbound
+	// headers
+	Headers map[string]string `yaml:"Headers" json:"-"`
+	// Timeout of a delivery to the target, defaults to the rule
+	// TargetTimeout or 10s
+	Timeout time.Duration `yaml:"Timeout" json:"-"`
+	// Retry retries failed deliveries to the target, defaults to the rule
+	// Retry
+	Retry *RetryConfig `yaml:"Retry" json:"-"`
+	// CircuitBreaker pauses deliveries to the target after consecutive
+	// failures, defaults to the rule CircuitBreaker
+	CircuitBreaker *CircuitBreakerConfig `yaml:"CircuitBreaker" json:"-"`
+	// Fallback targets are tried in order when delivery to the target fails
+	// with a network error or a non-2xx status
+	Fallback []Target `yaml:"Fallback" json:"-"`
+	// Format converts payloads to the content type the target expects:
+	// json, form, xml, msgpack or protobuf. JSON, form and XML payloads can
+	// be converted, others are rejected.
+	Format string `yaml:"Format" json:"-"`
+	// Protobuf sets the message of the protobuf Format
+	Protobuf *ProtobufConfig `yaml:"Protobuf" json:"-"`
+	// JWE encrypts the payload for the target
+	JWE *JWEConfig `yaml:"JWE" json:"-"`
+	// Signing signs requests to the target with an HMAC of the body as
+	// sent, after JWE encryption
+	Signing *SigningConfig `yaml:"Signing" json:"-"`
+	// SchemaVersions restricts the target to events tagged with the listed
+	// versions of the rule schema
+	SchemaVersions []int `yaml:"SchemaVersions" json:"-"`
+	// Weight is the share of events the target receives with the weighted
+	// strategy, defaults to 1
+	Weight *int `yaml:"Weight" json:"-"`
+	// Maintenance windows hold deliveries to the target until they end
+	Maintenance []MaintenanceWindow `yaml:"Maintenance" json:"-"`
+	// Digest makes the target receive a periodic summary of the events of
+	// each path instead of every event
+	Digest *DigestConfig `yaml:"Digest" json:"-"`
+	// HTTP2 overrides the outbound HTTP2 mode for the target
+	HTTP2 string `yaml:"HTTP2" json:"-"`
+	// ForwardMethod is the method of requests sent to the target: POST
+	// (default), another method or original to use the method of the
+	// incoming request. Defaults to the rule ForwardMethod.
+	ForwardMethod string `yaml:"ForwardMethod" json:"-"`
+	// Auth adds basic auth or bearer token credentials to requests sent to
+	// the target
+	Auth *TargetAuthConfig `yaml:"Auth" json:"-"`
+	// TLS sets a client certificate and CA bundle for the target
+	TLS *TargetTLSConfig `yaml:"TLS" json:"-"`
+
+	headers     http.Header
+	policy      *hostPolicy
+	transport   *http.Transport
+	urlTemplate *template.Template
+	// template is the URL template a rendered target was created from
+	template string
+	// shadow targets do not affect the outcome of a dispatch
+	shadow bool
+	// rule is the label of the rule the target belongs to
+	rule string
+	// passHeaders are the incoming headers forwarded to the target
+	passHeaders []string
+	// pull is the consumer of pull://<consumer> targets
+	pull string
+	// queryless is the URL before a forwarded query string was appended
+	queryless string
+}
+
+// UnmarshalYAML accepts both the plain URL and the object form
+func (t *Target) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		t.URL = value.Value
+		return nil
+	}
+
+	type plain Target
+	if err := value.Decode((*plain)(t)); err != nil {
+		return err
+	}
+	if t.URL == "" {
+		return fmt.Errorf("line %d: target URL is required", value.Line)
+	}
+	return nil
+}
+
+// clone returns a copy of the target not sharing its fallbacks, headers
+// and lists, so preparing it for one rule does not change it for another.
+// Option structs are shared, their preparation does not depend on the rule.
+func (t Target) clone() Target {
+	t.Headers = maps.Clone(t.Headers)
+	t.SchemaVersions = slices.Clone(t.SchemaVersions)
+	t.Maintenance = slices.Clone(t.Maintenance)
+	if t.Fallback != nil {
+		fallback := make([]Target, len(t.Fallback))
+		for i := range t.Fallback {
+			fallback[i] = t.Fallback[i].clone()
+		}
+		t.Fallback = fallback
+	}
+	return t
+}
+
+// prepare computes the headers added to requests sent to the target and
+// loads the encryption key
+func (t *Target) prepare(outbound OutboundConfig) error {
+	t.headers = http.Header{}
+	for name, value := range outbound.Headers {
+		t.headers.Set(name, value)
+	}
+
+	userAgent := "webhook-dispatcher/" + version.Version
+	if outbound.UserAgent != "" {
+		userAgent = outbound.UserAgent
+	}
+	if t.UserAgent != "" {
+		userAgent = t.UserAgent
+	}
+	t.headers.Set("User-Agent", userAgent)
+	t.policy = outbound.policy
+	var err error
+	policy := t.policy
+	if policy == nil {
+		policy = defaultPolicy
+	}
+	if t.TLS != nil {
+		if err := t.TLS.prepare(); err != nil {
+			return fmt.Errorf("target %s: %w", t.URL, err)
+		}
+	}
+	if t.transport, err = policy.transportFor(t.HTTP2, t.TLS); err != nil {
+		return fmt.Errorf("target %s: %w", t.URL, err)
+	}
+	for name, value := range t.Headers {
+		t.headers.Set(name, value)
+	}
+
+	if name, ok := strings.CutPrefix(t.URL, pullScheme); ok {
+		if name == "" {
+			return fmt.Errorf("target %s: consumer name is required", t.URL)
+		}
+		t.pull = name
+	}
+
+	if t.ForwardMethod, err = prepareForwardMethod(t.ForwardMethod); err != nil {
+		return fmt.Errorf("target %s: %w", t.URL, err)
+	}
+
+	if err := t.prepareFormat(); err != nil {
+		return fmt.Errorf("target %s: %w", t.URL, err)
+	}
+
+	tmpl, err := parseURLTemplate(t.URL)
+	if err != nil {
+		return fmt.Errorf("target %s: invalid URL template: %w", t.URL, err)
+	}
+	t.urlTemplate = tmpl
+
+	if t.JWE != nil {
+		if err := t.JWE.prepare(); err != nil {
+			return fmt.Errorf("target %s: %w", t.URL, err)
+		}
+	}
+
+	if t.Auth != nil {
+		if err := t.Auth.prepare(); err != nil {
+			return fmt.Errorf("target %s: %w", t.URL, err)
+		}
+	}
+
+	if t.Signing != nil {
+		if err := t.Signing.prepare(); err != nil {
+			return fmt.Errorf("target %s: %w", t.URL, err)
+		}
+	}
+
+	for i := range t.Maintenance {
+		if err := t.Maintenance[i].prepare(); err != nil {
+			return fmt.Errorf("target %s: Maintenance: %w", t.URL, err)
+		}
+	}
+
+	if t.Retry != nil {
+		if err := t.Retry.prepare(); err != nil {
+			return fmt.Errorf("target %s: %w", t.URL, err)
+		}
+	}
+
+	if t.CircuitBreaker != nil {
+		if err := t.CircuitBreaker.prepare(); err != nil {
+			return fmt.Errorf("target %s: %w", t.URL, err)
+		}
+	}
+
+	if t.Digest != nil {
+		if err := t.Digest.prepare(); err != nil {
+			return fmt.Errorf("target %s: %w", t.URL, err)
+		}
+	}
+
+	for i := range t.Fallback {
+		if t.Fallback[i].Timeout == 0 {
+			t.Fallback[i].Timeout = t.Timeout
+		}
+		if t.Fallback[i].ForwardMethod == "" {
+			t.Fallback[i].ForwardMethod = t.ForwardMethod
+		}
+		if err := t.Fallback[i].prepare(outbound); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// label identifies the target in statistics and debug captures, rendered
+// targets are identified by their template to keep the number bounded
+func (t *Target) label() string {
+	if t.template != "" {
+		return t.template
+	}
+	return t.URL
+}
+
+// httpTransport returns the transport of the target, the transport of its
+// outbound policy unless it has its own
+func (t *Target) httpTransport() *http.Transport {
+	switch {
+	case t.transport != nil:
+		return t.transport
+	case t.policy != nil:
+		return t.policy.transport
+	}
+	return defaultPolicy.transport
+}
+
+// timeout returns the delivery timeout of the target
+func (t *Target) timeout() time.Duration {
+	if t.Timeout > 0 {
+		return t.Timeout
+	}
+	return defaultTargetTimeout
+}
+
+// targetURLs returns URLs of the targets
+func targetURLs(targets []Target) []string {
+	urls := make([]string, len(targets))
+	for i, t := range targets {
+		urls[i] = t.URL
+	}
+	return urls
+}
+
+// findTarget returns the target of a rule by their labels, as recorded for
+// queued and dead-lettered deliveries. Templated targets get the URL they
+// were rendered to, without their fallbacks, which can not be rendered
+// without the request.
+func findTarget(config *Config, ruleLabel string, targetLabel string, url string) (Target, bool) {
+	if rule := ruleByLabel(config, ruleLabel); rule != nil {
+		for _, target := range slices.Concat(rule.Targets, rule.Shadow) {
+			if target.label() != targetLabel {
+				continue
+			}
+			// The URL differs for URL templates and forwarded query strings
+			if target.templated() || url != target.URL {
+				target.template = target.URL
+				target.URL = url
+				target.Fallback = nil
+			}
+			return target, true
+		}
+	}
+	return Target{}, false
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// TargetAuthConfig adds credentials to requests sent to a target, either
+// basic auth with Username and Password or a bearer Token
+type TargetAuthConfig struct {
+	Username string  `yaml:"Username"`
+	Password *Secret `yaml:"Password"`
+	Token    *Secret `yaml:"Token"`
+}
+
+// prepare checks that one kind of credentials is set and resolves the
+// secrets
+func (a *TargetAuthConfig) prepare() error {
+	if (a.Token != nil) == (a.Username != "") {
+		return fmt.Errorf("auth: set either Username and Password or Token")
+	}
+	if a.Token != nil {
+		if err := a.Token.load(); err != nil {
+			return fmt.Errorf("auth: Token: %w", err)
+		}
+		if a.Token.Get() == "" {
+			return fmt.Errorf("auth: empty Token")
+		}
+		return nil
+	}
+	if a.Password == nil {
+		return fmt.Errorf("auth: Password is required with Username")
+	}
+	if err := a.Password.load(); err != nil {
+		return fmt.Errorf("auth: Password: %w", err)
+	}
+	return nil
+}
+
+// apply sets the Authorization header of the request
+func (a *TargetAuthConfig) apply(req *http.Request) {
+	if a.Token != nil {
+		req.Header.Set("Authorization", "Bearer "+a.Token.Get())
+		return
+	}
+	req.SetBasicAuth(a.Username, a.Password.Get())
+}
+
+// redactCredentials returns a copy of the headers safe to keep in debug
+// captures
+func redactCredentials(header http.Header) http.Header {
+	header = header.Clone()
+	if header.Get("Authorization") != "" {
+		header.Set("Authorization", "[redacted]")
+	}
+	return header
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// targetTemplateFuncs are available in target URL templates
+var targetTemplateFuncs = template.FuncMap{
+	"pathEscape":  url.PathEscape,
+	"queryEscape": url.QueryEscape,
+}
+
+// targetTemplateData is the data target URL templates are rendered with,
+// e.g. https://api.example.com/{{ .PathSegment 2 }}/events or
+// https://example.com/{{ .Body.repo.name }}
+type targetTemplateData struct {
+	Path    string
+	Method  string
+	Headers http.Header
+	// Body is the parsed JSON payload
+	Body interface{}
+	// Captures are the PathRegex capture groups by number and name
+	Captures map[string]string
+	// Params are the path parameters and named PathRegex groups
+	Params map[string]string
+}
+
+// PathSegment returns the n-th segment of the request path, starting at 1
+func (d targetTemplateData) PathSegment(n int) string {
+	segments := strings.Split(strings.Trim(d.Path, "/"), "/")
+	if n < 1 || n > len(segments) {
+		return ""
+	}
+	return segments[n-1]
+}
+
+// Header returns the first value of a request header
+func (d targetTemplateData) Header(name string) string {
+	return d.Headers.Get(name)
+}
+
+// parseURLTemplate parses the target URL as a template if it has actions
+func parseURLTemplate(raw string) (*template.Template, error) {
+	if !strings.Contains(raw, "{{") {
+		return nil, nil
+	}
+	return template.New("url").Funcs(targetTemplateFuncs).Option("missingkey=error").Parse(raw)
+}
+
+// renderTargets returns the targets selected for the request by the rule
+// strategy followed by the shadow targets, with URL templates rendered
+func (r *DispatchRule) renderTargets(in ruleInput) ([]Target, error) {
+	targets := r.selectTargets(in.Key)
+	if len(r.Shadow) > 0 {
+		targets = append(targets[:len(targets):len(targets)], r.Shadow...)
+	}
+	targets = filterSchemaVersion(targets, in.SchemaVersion)
+	targets, err := r.renderTargetTemplates(targets, in)
+	if err != nil || !r.ForwardQuery || in.Query == "" {
+		return targets, err
+	}
+	return appendQuery(targets, in.Query)
+}
+
+// renderTargetTemplates renders the URL templates of the targets
+func (r *DispatchRule) renderTargetTemplates(targets []Target, in ruleInput) ([]Target, error) {
+	templated := false
+	for i := range targets {
+		if targets[i].templated() {
+			templated = true
+			break
+		}
+	}
+	if !templated {
+		return targets, nil
+	}
+
+	captures, _ := r.matchPath(in.Path)
+	data := targetTemplateData{
+		Path:     in.Path,
+		Method:   in.Method,
+		Headers:  in.Headers,
+		Body:     in.Body,
+		Captures: captures,
+		Params:   r.pathParams(in.Path),
+	}
+	return renderTargetList(targets, data)
+}
+
+func renderTargetList(targets []Target, data targetTemplateData) ([]Target, error) {
+	rendered := make([]Target, len(targets))
+	for i, target := range targets {
+		rendered[i] = target
+		if target.urlTemplate != nil {
+			var sb strings.Builder
+			if err := target.urlTemplate.Execute(&sb, data); err != nil {
+				return nil, fmt.Errorf("target %s: %w", target.URL, err)
+			}
+			rendered[i].URL = sb.String()
+			rendered[i].template = target.URL
+		}
+		if len(target.Fallback) > 0 {
+			fallback, err := renderTargetList(target.Fallback, data)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i].Fallback = fallback
+		}
+	}
+	return rendered, nil
+}
+
+// appendQuery returns copies of the targets and their fallbacks with the
+// query string appended to their URLs, labeled by their configured URLs
+func appendQuery(targets []Target, query string) ([]Target, error) {
+	out := make([]Target, len(targets))
+	for i, target := range targets {
+		out[i] = target
+		if target.pull == "" {
+			u, err := url.Parse(target.URL)
+			if err != nil {
+				return nil, fmt.Errorf("target %s: %w", target.URL, err)
+			}
+			if u.RawQuery != "" {
+				u.RawQuery += "&"
+			}
+			u.RawQuery += query
+			out[i].URL, out[i].queryless = u.String(), target.URL
+			if out[i].template == "" {
+				out[i].template = target.URL
+			}
+		}
+		if len(target.Fallback) > 0 {
+			fallback, err := appendQuery(target.Fallback, query)
+			if err != nil {
+				return nil, err
+			}
+			out[i].Fallback = fallback
+		}
+	}
+	return out, nil
+}
+
+// destination returns the URL of the target without a forwarded query
+// string
+func (t *Target) destination() string {
+	if t.queryless != "" {
+		return t.queryless
+	}
+	return t.URL
+}
+
+// templated reports whether the target or its fallbacks use URL templates
+func (t *Target) templated() bool {
+	if t.urlTemplate != nil {
+		return true
+	}
+	for i := range t.Fallback {
+		if t.Fallback[i].templated() {
+			return true
+		}
+	}
+	return false
+}
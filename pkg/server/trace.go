@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+)
+
+// traceparentRegexp matches a W3C traceparent header value
+var traceparentRegexp = regexp.MustCompile(`^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// traceContext returns the W3C trace context of a request, empty when the
+// traceparent header is missing or malformed
+func traceContext(headers http.Header) (string, string) {
+	traceparent := headers.Get("traceparent")
+	if !traceparentRegexp.MatchString(traceparent) {
+		return "", ""
+	}
+	return traceparent, headers.Get("tracestate")
+}
+
+// setTraceContext propagates the W3C trace context to an outgoing request
+func setTraceContext(headers http.Header, traceparent string, tracestate string) {
+	if traceparent == "" {
+		return
+	}
+	headers.Set("traceparent", traceparent)
+	if tracestate != "" {
+		headers.Set("tracestate", tracestate)
+	}
+}
+
+// copyTraceContext propagates the trace context of incoming headers
+func copyTraceContext(dst http.Header, src http.Header) {
+	traceparent, tracestate := traceContext(src)
+	setTraceContext(dst, traceparent, tracestate)
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/storage"
+)
+
+// defaultTrashTTL is how long deleted events stay in the trash unless
+// TRASH_TTL is set
+const defaultTrashTTL = 7 * 24 * time.Hour
+
+// TrashTTL returns how long deleted events are kept in the trash, set by
+// TRASH_TTL
+func TrashTTL() time.Duration {
+	return durationFromEnv("TRASH_TTL", defaultTrashTTL)
+}
+
+// trashPurgeInterval is how often events past the trash TTL are purged
+const trashPurgeInterval = time.Hour
+
+// startTrashPurge permanently deletes events which have been in the trash
+// for longer than TRASH_TTL, if the backend supports the trash
+func startTrashPurge(store storage.Storage) {
+	trasher, ok := store.(storage.Trasher)
+	if !ok {
+		return
+	}
+	ttl := TrashTTL()
+	go func() {
+		defer recoverGoroutine("trash purge")
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			n, err := trasher.PurgeTrash(ctx, time.Now().Add(-ttl))
+			cancel()
+			if err != nil && !errors.Is(err, storage.ErrNotSupported) && !errors.Is(err, storage.ErrUnavailable) {
+				log.Printf("Failed to purge trash: %v", err)
+			} else if n > 0 {
+				log.Printf("Purged %d events from the trash", n)
+			}
+		}
+	}()
+}
+
+// trashQuery reads the key or path parameter selecting events
+func trashQuery(r *http.Request) storage.TrashQuery {
+	return storage.TrashQuery{Key: r.URL.Query().Get("key"), Path: r.URL.Query().Get("path")}
+}
+
+// writeStorageError writes the error response of a failed storage operation
+func writeStorageError(w http.ResponseWriter, err error, what string) {
+	switch {
+	case errors.Is(err, storage.ErrNotSupported):
+		writeError(w, http.StatusNotImplemented, ErrCodeNotImplemented, "The storage backend does not support "+what)
+	case errors.Is(err, storage.ErrUnavailable):
+		writeError(w, http.StatusServiceUnavailable, ErrCodeStorageUnavailable, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, ErrCodeStorageFailed, "Failed "+what)
+		log.Printf("Failed %s: %v", what, err)
+	}
+}
+
+// handleDeleteEvents moves the events selected by key or path to the trash
+func handleDeleteEvents(w http.ResponseWriter, r *http.Request, store storage.Storage) {
+	query := trashQuery(r)
+	if (query.Key == "") == (query.Path == "") {
+		writeError(w, http.StatusBadRequest, ErrCodeBadRequest, "Exactly one of key and path is required")
+		return
+	}
+	trasher, ok := store.(storage.Trasher)
+	if !ok {
+		writeStorageError(w, storage.ErrNotSupported, "deleting events")
+		return
+	}
+	n, err := trasher.Trash(r.Context(), query)
+	if err != nil {
+		writeStorageError(w, err, "deleting events")
+		return
+	}
+	log.Printf("Moved %d events to the trash (key: %q, path: %q)", n, query.Key, query.Path)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"trashed": n})
+}
+
+// maxTrashEvents limits the events returned by a trash listing
+const maxTrashEvents = 500
+
+// handleTrash lists (GET) or permanently purges (DELETE) the trash
+func handleTrash(w http.ResponseWriter, r *http.Request, store storage.Storage) {
+	trasher, ok := store.(storage.Trasher)
+	if !ok {
+		writeStorageError(w, storage.ErrNotSupported, "the trash")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		limit := int64(50)
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 1 || n > maxTrashEvents {
+				writeError(w, http.StatusBadRequest, ErrCodeBadRequest, fmt.Sprintf("limit must be 1-%d", maxTrashEvents))
+				return
+			}
+			limit = n
+		}
+		events, err := trasher.ListTrash(r.Context(), limit)
+		if err != nil {
+			writeStorageError(w, err, "listing the trash")
+			return
+		}
+		for i := range events {
+			events[i].Body = previewConfig.render([]byte(events[i].Body))
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"count":  len(events),
+			"events": events,
+		})
+	case http.MethodDelete:
+		n, err := trasher.PurgeTrash(r.Context(), time.Now())
+		if err != nil {
+			writeStorageError(w, err, "purging the trash")
+			return
+		}
+		log.Printf("Purged %d events from the trash", n)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"purged": n})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+	}
+}
+
+// handleRestoreEvents moves the events selected by key or path back from
+// the trash
+func handleRestoreEvents(w http.ResponseWriter, r *http.Request, store storage.Storage) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed")
+		return
+	}
+	query := trashQuery(r)
+	if (query.Key == "") == (query.Path == "") {
+		writeError(w, http.StatusBadRequest, ErrCodeBadRequest, "Exactly one of key and path is required")
+		return
+	}
+	trasher, ok := store.(storage.Trasher)
+	if !ok {
+		writeStorageError(w, storage.ErrNotSupported, "restoring events")
+		return
+	}
+	n, err := trasher.Restore(r.Context(), query)
+	if err != nil {
+		writeStorageError(w, err, "restoring events")
+		return
+	}
+	log.Printf("Restored %d events from the trash (key: %q, path: %q)", n, query.Key, query.Path)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"restored": n})
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// VerifyConfig configures authentication of incoming webhooks by an HMAC
+// signature or a shared token. Webhooks failing it get 401 and are neither
+// stored nor forwarded.
+type VerifyConfig struct {
+	// Type is hmac (default), verifying a signature of the body, or token,
+	// comparing the header value with the secrets
+	Type string `yaml:"Type"`
+	// Header carrying the signature or token, e.g. X-Hub-Signature-256
+	Header string `yaml:"Header"`
+	// Prefix stripped from the header value, e.g. "sha256=" or "Bearer "
+	Prefix string `yaml:"Prefix"`
+	// Algorithm is sha256 (default), sha1 or sha512
+	Algorithm string `yaml:"Algorithm"`
+	// Encoding of the signature, hex (default) or base64
+	Encoding string `yaml:"Encoding"`
+	// Secrets accepted for verification; list more than one during rotation
+	Secrets []NamedSecret `yaml:"Secrets"`
+}
+
+// NamedSecret is a secret with a name reported in metrics
+type NamedSecret struct {
+	Name   string `yaml:"Name"`
+	Secret `yaml:",inline"`
+}
+
+// enabled reports whether verification is configured
+func (v *VerifyConfig) enabled() bool {
+	return len(v.Secrets) > 0
+}
+
+// prepare validates the configuration and resolves the secrets
+func (v *VerifyConfig) prepare() error {
+	if !v.enabled() {
+		return nil
+	}
+	if v.Header == "" {
+		return fmt.Errorf("verify: Header is required")
+	}
+	switch v.Type {
+	case "", "hmac":
+		if _, err := v.hashFunc(); err != nil {
+			return err
+		}
+		if v.Encoding != "" && v.Encoding != "hex" && v.Encoding != "base64" {
+			return fmt.Errorf("verify: unknown encoding %q", v.Encoding)
+		}
+	case "token":
+	default:
+		return fmt.Errorf("verify: unknown type %q", v.Type)
+	}
+	for i := range v.Secrets {
+		if v.Secrets[i].Name == "" {
+			v.Secrets[i].Name = fmt.Sprintf("secret-%d", i)
+		}
+		if err := v.Secrets[i].load(); err != nil {
+			return fmt.Errorf("verify: secret %s: %w", v.Secrets[i].Name, err)
+		}
+	}
+	return nil
+}
+
+func (v *VerifyConfig) hashFunc() (func() hash.Hash, error) {
+	switch v.Algorithm {
+	case "", "sha256":
+		return sha256.New, nil
+	case "sha1":
+		return sha1.New, nil
+	case "sha512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("verify: unknown algorithm %q", v.Algorithm)
+}
+
+// needsBody reports whether verification reads the request body
+func (v *VerifyConfig) needsBody() bool {
+	return v.Type != "token"
+}
+
+// credential names what the sender has to provide, used in errors
+func (v *VerifyConfig) credential() string {
+	if v.Type == "token" {
+		return "token"
+	}
+	return "signature"
+}
+
+// verifyToken compares the request token with all configured secrets and
+// returns the name of the matching secret
+func (v *VerifyConfig) verifyToken(headers http.Header) (string, bool) {
+	token := strings.TrimPrefix(headers.Get(v.Header), v.Prefix)
+	if token == "" {
+		return "", false
+	}
+	for _, secret := range v.Secrets {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret.Get())) == 1 {
+			return secret.Name, true
+		}
+	}
+	return "", false
+}
+
+// verify checks the request signature against all configured secrets and
+// returns the name of the matching secret
+func (v *VerifyConfig) verify(headers http.Header, body io.Reader) (string, bool) {
+	signature := strings.TrimPrefix(headers.Get(v.Header), v.Prefix)
+	if signature == "" {
+		return "", false
+	}
+
+	var expected []byte
+	var err error
+	if v.Encoding == "base64" {
+		expected, err = base64.StdEncoding.DecodeString(signature)
+	} else {
+		expected, err = hex.DecodeString(signature)
+	}
+	if err != nil {
+		return "", false
+	}
+
+	// Compute the MACs of all secrets in a single pass over the body
+	newHash, _ := v.hashFunc()
+	macs := make([]hash.Hash, len(v.Secrets))
+	writers := make([]io.Writer, len(v.Secrets))
+	for i, secret := range v.Secrets {
+		macs[i] = hmac.New(newHash, []byte(secret.Get()))
+		writers[i] = macs[i]
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), body); err != nil {
+		return "", false
+	}
+	for i, mac := range macs {
+		if hmac.Equal(mac.Sum(nil), expected) {
+			return v.Secrets[i].Name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+)
+
+// whenEnv declares the variables available to When expressions
+var whenEnv, _ = cel.NewEnv(
+	cel.Variable("path", cel.StringType),
+	cel.Variable("method", cel.StringType),
+	cel.Variable("headers", cel.MapType(cel.StringType, cel.StringType)),
+	cel.Variable("body", cel.DynType),
+	cel.Variable("params", cel.MapType(cel.StringType, cel.StringType)),
+)
+
+// compileWhen compiles a When expression, which must evaluate to a bool
+func compileWhen(expr string) (cel.Program, error) {
+	ast, issues := whenEnv.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("expression must evaluate to bool, got %s", ast.OutputType())
+	}
+	return whenEnv.Program(ast)
+}
+
+// matchWhen evaluates the rule When expression. Header names are lower
+// case, multiple values are joined with a comma. Evaluation errors, such as
+// a missing body field, do not match.
+func (r *DispatchRule) matchWhen(in ruleInput) bool {
+	headers := make(map[string]string, len(in.Headers))
+	for name, values := range in.Headers {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+
+	out, _, err := r.when.Eval(map[string]interface{}{
+		"path":    in.Path,
+		"method":  in.Method,
+		"headers": headers,
+		"body":    in.Body,
+		"params":  r.pathParams(in.Path),
+	})
+	if err != nil {
+		if enableLogging {
+			log.Printf("When of rule %s did not evaluate: %v", r.label(), err)
+		}
+		return false
+	}
+	matched, _ := out.Value().(bool)
+	return matched
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a recurring period of the week
+type TimeWindow struct {
+	// Days the window starts on (mon, tue, ...), every day when empty
+	Days []string `yaml:"Days"`
+	// From is the start time (HH:MM), defaults to 00:00
+	From string `yaml:"From"`
+	// To is the end time (HH:MM, exclusive), defaults to 24:00. A To before
+	// From spans midnight, e.g. From 18:00 To 08:00 on fri lasts until
+	// Saturday 08:00.
+	To string `yaml:"To"`
+	// TimeZone is an IANA time zone name, defaults to the local time zone
+	TimeZone string `yaml:"TimeZone"`
+
+	days     map[time.Weekday]bool
+	from, to int
+	location *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// prepare parses the window
+func (w *TimeWindow) prepare() error {
+	w.days = map[time.Weekday]bool{}
+	for _, day := range w.Days {
+		d, ok := weekdays[strings.ToLower(day)[:min(3, len(day))]]
+		if !ok {
+			return fmt.Errorf("invalid day %q", day)
+		}
+		w.days[d] = true
+	}
+
+	var err error
+	if w.from, err = parseClock(w.From, 0); err != nil {
+		return fmt.Errorf("invalid From: %w", err)
+	}
+	if w.to, err = parseClock(w.To, 24*60); err != nil {
+		return fmt.Errorf("invalid To: %w", err)
+	}
+
+	w.location = time.Local
+	if w.TimeZone != "" {
+		if w.location, err = time.LoadLocation(w.TimeZone); err != nil {
+			return fmt.Errorf("invalid TimeZone: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseClock parses HH:MM into minutes since midnight
+func parseClock(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	var h, m int
+	if _, err := fmt.Sscanf(value, "%d:%d", &h, &m); err != nil || h < 0 || h > 24 || m < 0 || m > 59 || h == 24 && m > 0 {
+		return 0, fmt.Errorf("%q is not HH:MM", value)
+	}
+	return h*60 + m, nil
+}
+
+// onDay reports whether the window starts on the day
+func (w *TimeWindow) onDay(day time.Weekday) bool {
+	return len(w.days) == 0 || w.days[day]
+}
+
+// contains reports whether the time falls into the window
+func (w *TimeWindow) contains(t time.Time) bool {
+	t = t.In(w.location)
+	minute := t.Hour()*60 + t.Minute()
+	if w.from < w.to {
+		return w.onDay(t.Weekday()) && minute >= w.from && minute < w.to
+	}
+	// The window spans midnight
+	if minute >= w.from && w.onDay(t.Weekday()) {
+		return true
+	}
+	return minute < w.to && w.onDay(t.AddDate(0, 0, -1).Weekday())
+}
+
+// inWindows reports whether the time falls into any of the windows
+func inWindows(windows []TimeWindow, t time.Time) bool {
+	for i := range windows {
+		if windows[i].contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardsAt reports whether the rule forwards events at the time, rules
+// with ActiveWindows only forward within them
+func (r *DispatchRule) forwardsAt(t time.Time) bool {
+	return len(r.ActiveWindows) == 0 || inWindows(r.ActiveWindows, t)
+}
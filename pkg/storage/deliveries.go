@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sikalabs/webhook-dispatcher/pkg/manifest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Delivery is a single attempt to deliver an event to a target
+type Delivery struct {
+	Key  string    `bson:"key" json:"key"`
+	Time time.Time `bson:"time" json:"time"`
+	// Target is the configured target, the URL template for templated
+	// targets, and URL the URL delivered to
+	Target string `bson:"target" json:"target"`
+	URL    string `bson:"url" json:"url"`
+	Status int    `bson:"status,omitempty" json:"status,omitempty"`
+	Error  string `bson:"error,omitempty" json:"error,omitempty"`
+	// Duration of the attempt in milliseconds
+	Duration float64 `bson:"duration_ms" json:"duration_ms"`
+	// Manifest is the signed manifest of the attempt, if manifests are
+	// enabled
+	Manifest *manifest.Manifest `bson:"manifest,omitempty" json:"manifest,omitempty"`
+}
+
+// OK reports whether the target accepted the delivery
+func (d Delivery) OK() bool {
+	return d.Error == "" && d.Status >= 200 && d.Status < 300
+}
+
+// DeliveryTracker is implemented by backends recording delivery attempts
+type DeliveryTracker interface {
+	// RecordDelivery records a delivery attempt
+	RecordDelivery(ctx context.Context, delivery Delivery) error
+	// Deliveries returns the delivery attempts of an event, oldest first
+	Deliveries(ctx context.Context, key string) ([]Delivery, error)
+}
+
+// redisDeliveries returns the Redis list of the delivery attempts of an
+// event
+func redisDeliveries(key string) string {
+	return "deliveries:" + key
+}
+
+// RecordDelivery appends a delivery attempt to the list of the event
+func (r *RedisStorage) RecordDelivery(ctx context.Context, delivery Delivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return r.client.RPush(ctx, redisDeliveries(delivery.Key), data).Err()
+}
+
+// Deliveries returns the delivery attempts of an event from Redis
+func (r *RedisStorage) Deliveries(ctx context.Context, key string) ([]Delivery, error) {
+	values, err := r.client.LRange(ctx, redisDeliveries(key), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deliveries: %w", err)
+	}
+	deliveries := make([]Delivery, 0, len(values))
+	for _, value := range values {
+		var delivery Delivery
+		if err := json.Unmarshal([]byte(value), &delivery); err != nil {
+			return nil, fmt.Errorf("invalid delivery: %w", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// deliveries returns the collection of delivery attempts, next to the
+// events collection
+func (m *MongoDBStorage) deliveries() *mongo.Collection {
+	return m.collection.Database().Collection(m.collection.Name() + "_deliveries")
+}
+
+// RecordDelivery inserts a delivery attempt into MongoDB
+func (m *MongoDBStorage) RecordDelivery(ctx context.Context, delivery Delivery) error {
+	if _, err := m.deliveries().InsertOne(ctx, delivery); err != nil {
+		return fmt.Errorf("failed to insert delivery: %w", err)
+	}
+	return nil
+}
+
+// Deliveries returns the delivery attempts of an event from MongoDB
+func (m *MongoDBStorage) Deliveries(ctx context.Context, key string) ([]Delivery, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: 1}})
+	cursor, err := m.deliveries().Find(ctx, bson.D{{Key: "key", Value: key}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deliveries: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := []Delivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, fmt.Errorf("failed to decode deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// RecordDelivery records a delivery attempt in MongoDB
+func (d *DualStorage) RecordDelivery(ctx context.Context, delivery Delivery) error {
+	return d.mongodb.RecordDelivery(ctx, delivery)
+}
+
+// Deliveries returns the delivery attempts of an event from MongoDB
+func (d *DualStorage) Deliveries(ctx context.Context, key string) ([]Delivery, error) {
+	return d.mongodb.Deliveries(ctx, key)
+}
+
+// deliveryTracker returns the backend if it records deliveries
+func (l *LazyStorage) deliveryTracker() (DeliveryTracker, error) {
+	backend, err := l.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	tracker, ok := backend.(DeliveryTracker)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return tracker, nil
+}
+
+// RecordDelivery records a delivery attempt if the backend supports it
+func (l *LazyStorage) RecordDelivery(ctx context.Context, delivery Delivery) error {
+	tracker, err := l.deliveryTracker()
+	if err != nil {
+		return err
+	}
+	return tracker.RecordDelivery(ctx, delivery)
+}
+
+// Deliveries returns the delivery attempts of an event if the backend
+// supports it
+func (l *LazyStorage) Deliveries(ctx context.Context, key string) ([]Delivery, error) {
+	tracker, err := l.deliveryTracker()
+	if err != nil {
+		return nil, err
+	}
+	return tracker.Deliveries(ctx, key)
+}
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeadLetter is a delivery which failed after all retries and fallbacks
+type DeadLetter struct {
+	ID   string    `bson:"id" json:"id"`
+	Time time.Time `bson:"time" json:"time"`
+	// Key and Path of the event
+	Key  string `bson:"key" json:"key"`
+	Path string `bson:"path" json:"path"`
+	// Rule is the label of the rule the target belongs to
+	Rule string `bson:"rule" json:"rule"`
+	// Target is the URL delivered to and TargetLabel the configured
+	// target, which differs for URL templates
+	Target      string `bson:"target" json:"target"`
+	TargetLabel string `bson:"target_label" json:"target_label"`
+	Status      int    `bson:"status,omitempty" json:"status,omitempty"`
+	Error       string `bson:"error,omitempty" json:"error,omitempty"`
+	// Body is the payload as sent to the target, empty for payloads too
+	// large to keep, which are replayed from the stored event
+	Body string `bson:"body,omitempty" json:"body,omitempty"`
+	// Headers forwarded with the payload, such as Content-Type
+	Headers map[string][]string `bson:"headers,omitempty" json:"headers,omitempty"`
+}
+
+// DeadLetterQueue is implemented by backends keeping undeliverable
+// webhooks for inspection and replay
+type DeadLetterQueue interface {
+	// PushDeadLetter adds a dead letter to the queue
+	PushDeadLetter(ctx context.Context, letter DeadLetter) error
+	// DeadLetters returns up to limit dead letters, newest first, or all of
+	// them for a limit of 0
+	DeadLetters(ctx context.Context, limit int64) ([]DeadLetter, error)
+	// TakeDeadLetter removes a dead letter from the queue and returns it,
+	// or ErrNotFound
+	TakeDeadLetter(ctx context.Context, id string) (*DeadLetter, error)
+}
+
+// redisDeadLetters is the Redis list of dead letters, newest first
+const redisDeadLetters = "dlq"
+
+// PushDeadLetter adds a dead letter to the Redis list
+func (r *RedisStorage) PushDeadLetter(ctx context.Context, letter DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	return r.client.LPush(ctx, redisDeadLetters, data).Err()
+}
+
+// DeadLetters returns dead letters from the Redis list
+func (r *RedisStorage) DeadLetters(ctx context.Context, limit int64) ([]DeadLetter, error) {
+	values, err := r.client.LRange(ctx, redisDeadLetters, 0, limit-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	letters := make([]DeadLetter, 0, len(values))
+	for _, value := range values {
+		var letter DeadLetter
+		if err := json.Unmarshal([]byte(value), &letter); err != nil {
+			return nil, fmt.Errorf("invalid dead letter: %w", err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// TakeDeadLetter removes a dead letter from the Redis list, scanning it
+// for the ID
+func (r *RedisStorage) TakeDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	values, err := r.client.LRange(ctx, redisDeadLetters, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	for _, value := range values {
+		var letter DeadLetter
+		if err := json.Unmarshal([]byte(value), &letter); err != nil || letter.ID != id {
+			continue
+		}
+		// Another instance may have taken it meanwhile
+		n, err := r.client.LRem(ctx, redisDeadLetters, 1, value).Result()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, ErrNotFound
+		}
+		return &letter, nil
+	}
+	return nil, ErrNotFound
+}
+
+// deadLetters returns the collection of dead letters, next to the events
+// collection
+func (m *MongoDBStorage) deadLetters() *mongo.Collection {
+	return m.collection.Database().Collection(m.collection.Name() + "_dlq")
+}
+
+// PushDeadLetter adds a dead letter to MongoDB
+func (m *MongoDBStorage) PushDeadLetter(ctx context.Context, letter DeadLetter) error {
+	if _, err := m.deadLetters().InsertOne(ctx, letter); err != nil {
+		return fmt.Errorf("failed to insert dead letter: %w", err)
+	}
+	return nil
+}
+
+// DeadLetters returns dead letters from MongoDB
+func (m *MongoDBStorage) DeadLetters(ctx context.Context, limit int64) ([]DeadLetter, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: -1}}).SetLimit(limit)
+	cursor, err := m.deadLetters().Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	letters := []DeadLetter{}
+	if err := cursor.All(ctx, &letters); err != nil {
+		return nil, fmt.Errorf("failed to decode dead letters: %w", err)
+	}
+	return letters, nil
+}
+
+// TakeDeadLetter removes a dead letter from MongoDB
+func (m *MongoDBStorage) TakeDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	var letter DeadLetter
+	err := m.deadLetters().FindOneAndDelete(ctx, bson.D{{Key: "id", Value: id}}).Decode(&letter)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to take dead letter: %w", err)
+	}
+	return &letter, nil
+}
+
+// PushDeadLetter adds a dead letter to MongoDB, which keeps them durably
+func (d *DualStorage) PushDeadLetter(ctx context.Context, letter DeadLetter) error {
+	return d.mongodb.PushDeadLetter(ctx, letter)
+}
+
+// DeadLetters returns dead letters from MongoDB
+func (d *DualStorage) DeadLetters(ctx context.Context, limit int64) ([]DeadLetter, error) {
+	return d.mongodb.DeadLetters(ctx, limit)
+}
+
+// TakeDeadLetter removes a dead letter from MongoDB
+func (d *DualStorage) TakeDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	return d.mongodb.TakeDeadLetter(ctx, id)
+}
+
+// deadLetterQueue returns the backend if it keeps dead letters
+func (l *LazyStorage) deadLetterQueue() (DeadLetterQueue, error) {
+	backend, err := l.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	queue, ok := backend.(DeadLetterQueue)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return queue, nil
+}
+
+// PushDeadLetter adds a dead letter if the backend supports it
+func (l *LazyStorage) PushDeadLetter(ctx context.Context, letter DeadLetter) error {
+	queue, err := l.deadLetterQueue()
+	if err != nil {
+		return err
+	}
+	return queue.PushDeadLetter(ctx, letter)
+}
+
+// DeadLetters lists dead letters if the backend supports it
+func (l *LazyStorage) DeadLetters(ctx context.Context, limit int64) ([]DeadLetter, error) {
+	queue, err := l.deadLetterQueue()
+	if err != nil {
+		return nil, err
+	}
+	return queue.DeadLetters(ctx, limit)
+}
+
+// TakeDeadLetter removes a dead letter if the backend supports it
+func (l *LazyStorage) TakeDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	queue, err := l.deadLetterQueue()
+	if err != nil {
+		return nil, err
+	}
+	return queue.TakeDeadLetter(ctx, id)
+}
@@ -2,7 +2,11 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"time"
 )
 
 // DualStorage implements Storage interface for both Redis and MongoDB
@@ -20,21 +24,50 @@ func NewDualStorage(redis *RedisStorage, mongodb *MongoDBStorage) *DualStorage {
 }
 
 // Store saves a webhook event to both Redis and MongoDB
-func (d *DualStorage) Store(ctx context.Context, key string, path string, body string) error {
+func (d *DualStorage) Store(ctx context.Context, event *Event) error {
 	// Store in Redis first (primary storage)
-	if err := d.redis.Store(ctx, key, path, body); err != nil {
+	if err := d.redis.Store(ctx, event); err != nil {
 		return err
 	}
 
 	// Store in MongoDB (secondary storage)
 	// Log error but don't fail the request if MongoDB fails
-	if err := d.mongodb.Store(ctx, key, path, body); err != nil {
+	if err := d.mongodb.Store(ctx, event); err != nil {
 		log.Printf("Warning: Failed to store in MongoDB: %v", err)
 	}
 
 	return nil
 }
 
+// StoreStream saves a webhook event with a large body to both Redis and
+// MongoDB
+func (d *DualStorage) StoreStream(ctx context.Context, event *Event, body io.ReadSeeker) error {
+	if err := d.redis.StoreStream(ctx, event, body); err != nil {
+		return err
+	}
+
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := d.mongodb.StoreStream(ctx, event, body); err != nil {
+		log.Printf("Warning: Failed to store in MongoDB: %v", err)
+	}
+
+	return nil
+}
+
+// Get returns an event from MongoDB, falling back to Redis
+func (d *DualStorage) Get(ctx context.Context, key string) (*Event, error) {
+	event, err := d.mongodb.Get(ctx, key)
+	if err == nil {
+		return event, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		log.Printf("Warning: Failed to get event from MongoDB: %v", err)
+	}
+	return d.redis.Get(ctx, key)
+}
+
 // Count returns the count from Redis (primary storage)
 func (d *DualStorage) Count(ctx context.Context) (int64, error) {
 	return d.redis.Count(ctx)
@@ -50,6 +83,84 @@ func (d *DualStorage) MongoDBCount(ctx context.Context) (int64, error) {
 	return d.mongodb.Count(ctx)
 }
 
+// Search searches events in MongoDB
+func (d *DualStorage) Search(ctx context.Context, query SearchQuery) ([]Event, error) {
+	return d.mongodb.Search(ctx, query)
+}
+
+// Trash moves events to the trash in MongoDB and Redis. Events selected by
+// path are found in MongoDB, since Redis cannot search by path.
+func (d *DualStorage) Trash(ctx context.Context, query TrashQuery) (int64, error) {
+	keys, err := d.trashKeys(ctx, query, false)
+	if err != nil {
+		return 0, err
+	}
+	n, err := d.mongodb.Trash(ctx, query)
+	if err != nil {
+		return n, err
+	}
+	for _, key := range keys {
+		if _, err := d.redis.Trash(ctx, TrashQuery{Key: key}); err != nil {
+			log.Printf("Warning: Failed to trash %s in Redis: %v", key, err)
+		}
+	}
+	return n, nil
+}
+
+// Restore moves events back from the trash in MongoDB and Redis
+func (d *DualStorage) Restore(ctx context.Context, query TrashQuery) (int64, error) {
+	keys, err := d.trashKeys(ctx, query, true)
+	if err != nil {
+		return 0, err
+	}
+	n, err := d.mongodb.Restore(ctx, query)
+	if err != nil {
+		return n, err
+	}
+	for _, key := range keys {
+		if _, err := d.redis.Restore(ctx, TrashQuery{Key: key}); err != nil {
+			log.Printf("Warning: Failed to restore %s in Redis: %v", key, err)
+		}
+	}
+	return n, nil
+}
+
+// trashKeys returns the keys of the events in or out of the trash in
+// MongoDB matching the query
+func (d *DualStorage) trashKeys(ctx context.Context, query TrashQuery, trashed bool) ([]string, error) {
+	if query.Key != "" {
+		return []string{query.Key}, nil
+	}
+	filter, err := trashFilter(query, trashed)
+	if err != nil {
+		return nil, err
+	}
+	values, err := d.mongodb.collection.Distinct(ctx, "key", filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find events: %w", err)
+	}
+	keys := make([]string, 0, len(values))
+	for _, v := range values {
+		if key, ok := v.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// ListTrash lists the trash in MongoDB
+func (d *DualStorage) ListTrash(ctx context.Context, limit int64) ([]Event, error) {
+	return d.mongodb.ListTrash(ctx, limit)
+}
+
+// PurgeTrash purges the trash in both backends
+func (d *DualStorage) PurgeTrash(ctx context.Context, before time.Time) (int64, error) {
+	if _, err := d.redis.PurgeTrash(ctx, before); err != nil {
+		log.Printf("Warning: Failed to purge trash in Redis: %v", err)
+	}
+	return d.mongodb.PurgeTrash(ctx, before)
+}
+
 // Close closes both storage connections
 func (d *DualStorage) Close() error {
 	// Close both connections, log errors but continue
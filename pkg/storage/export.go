@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Exporter is implemented by storage backends which can export all their
+// events in bounded memory
+type Exporter interface {
+	// Export calls fn for each stored event, including events in the trash,
+	// in a stable order. Along with the event fn gets a cursor, passing it
+	// as after resumes the export after the event. Backends which can not
+	// resume exactly may repeat a few events before the cursor.
+	Export(ctx context.Context, after string, fn func(event Event, cursor string) error) error
+}
+
+// exportBatchSize is the number of events fetched from the backend at once
+const exportBatchSize = 500
+
+// Export exports events from MongoDB ordered by their ID, which is the
+// cursor. Bodies of streamed events are read from GridFS.
+func (m *MongoDBStorage) Export(ctx context.Context, after string, fn func(event Event, cursor string) error) error {
+	filter := bson.D{}
+	if after != "" {
+		id, err := primitive.ObjectIDFromHex(after)
+		if err != nil {
+			return fmt.Errorf("invalid export cursor %q: %w", after, err)
+		}
+		filter = bson.D{{Key: "_id", Value: bson.D{{Key: "$gt", Value: id}}}}
+	}
+
+	// The parsed payload is rebuilt from the body on import
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetBatchSize(exportBatchSize).
+		SetProjection(bson.D{{Key: "payload", Value: 0}})
+	cursor, err := m.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return fmt.Errorf("failed to export events: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var bucket *gridfs.Bucket
+	for cursor.Next(ctx) {
+		var event Event
+		if err := cursor.Decode(&event); err != nil {
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+		id, ok := cursor.Current.Lookup("_id").ObjectIDOK()
+		if !ok {
+			return fmt.Errorf("event %s has no ObjectID", event.Key)
+		}
+		if event.Streamed {
+			if bucket == nil {
+				if bucket, err = m.bucket(ctx); err != nil {
+					return fmt.Errorf("failed to open GridFS bucket: %w", err)
+				}
+			}
+			if event.Body, err = downloadBody(bucket, event.Key); err != nil {
+				return err
+			}
+		}
+		if err := fn(event, id.Hex()); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// exportEnd is the cursor after the last event of a Redis export, the
+// SCAN cursor 0 would restart it
+const exportEnd = "end"
+
+// Export exports events from Redis by SCAN, whose cursor is the export
+// cursor. Events of a SCAN batch share the cursor of its start, so a
+// resumed export repeats up to a batch of events.
+func (r *RedisStorage) Export(ctx context.Context, after string, fn func(event Event, cursor string) error) error {
+	if after == exportEnd {
+		return nil
+	}
+	var cursor uint64
+	if after != "" {
+		var err error
+		if cursor, err = strconv.ParseUint(after, 10, 64); err != nil {
+			return fmt.Errorf("invalid export cursor %q: %w", after, err)
+		}
+	}
+
+	for {
+		keys, next, err := r.client.Scan(ctx, cursor, redisEventPattern, exportBatchSize).Result()
+		if err != nil {
+			return fmt.Errorf("failed to scan keys: %w", err)
+		}
+		resume := strconv.FormatUint(cursor, 10)
+		for i, key := range keys {
+			event, err := r.Get(ctx, eventKeyFromRedis(key))
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if i == len(keys)-1 {
+				resume = strconv.FormatUint(next, 10)
+				if next == 0 {
+					resume = exportEnd
+				}
+			}
+			if err := fn(*event, resume); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Export exports events from MongoDB, which keeps the full events
+func (d *DualStorage) Export(ctx context.Context, after string, fn func(event Event, cursor string) error) error {
+	return d.mongodb.Export(ctx, after, fn)
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrUnavailable is returned while the storage backend is not connected
+var ErrUnavailable = errors.New("storage is not available yet")
+
+// ErrNotSupported is returned when the backend does not support an operation
+var ErrNotSupported = errors.New("operation not supported by storage backend")
+
+// LazyStorage serves as storage before the real backend is connected.
+// Until then events are either buffered in memory (up to bufferSize)
+// or rejected with ErrUnavailable.
+type LazyStorage struct {
+	mu         sync.RWMutex
+	backend    Storage
+	buffer     []*Event
+	bufferSize int
+}
+
+// NewLazyStorage creates a storage waiting for its backend, bufferSize 0
+// disables buffering
+func NewLazyStorage(bufferSize int) *LazyStorage {
+	return &LazyStorage{bufferSize: bufferSize}
+}
+
+// SetBackend sets the connected backend and flushes buffered events to it
+func (l *LazyStorage) SetBackend(ctx context.Context, backend Storage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.backend = backend
+	for _, e := range l.buffer {
+		if err := backend.Store(ctx, e); err != nil {
+			log.Printf("Failed to flush buffered event %s: %v", e.Key, err)
+		}
+	}
+	if len(l.buffer) > 0 {
+		log.Printf("Flushed %d buffered events to storage", len(l.buffer))
+	}
+	l.buffer = nil
+}
+
+// Ready reports whether the backend is connected
+func (l *LazyStorage) Ready() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.backend != nil
+}
+
+func (l *LazyStorage) getBackend() (Storage, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.backend == nil {
+		return nil, ErrUnavailable
+	}
+	return l.backend, nil
+}
+
+// Store saves the event to the backend, or buffers it while not connected
+func (l *LazyStorage) Store(ctx context.Context, event *Event) error {
+	l.mu.Lock()
+	if l.backend == nil {
+		defer l.mu.Unlock()
+		if len(l.buffer) >= l.bufferSize {
+			return fmt.Errorf("%w (buffer full)", ErrUnavailable)
+		}
+		l.buffer = append(l.buffer, event)
+		return nil
+	}
+	backend := l.backend
+	l.mu.Unlock()
+
+	return backend.Store(ctx, event)
+}
+
+// StoreStream saves an event with a large body if the backend supports it.
+// Such events are never buffered.
+func (l *LazyStorage) StoreStream(ctx context.Context, event *Event, body io.ReadSeeker) error {
+	backend, err := l.getBackend()
+	if err != nil {
+		return err
+	}
+	streamer, ok := backend.(StreamStorer)
+	if !ok {
+		return ErrNotSupported
+	}
+	return streamer.StoreStream(ctx, event, body)
+}
+
+// Get returns an event from the backend
+func (l *LazyStorage) Get(ctx context.Context, key string) (*Event, error) {
+	backend, err := l.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	return backend.Get(ctx, key)
+}
+
+// Count returns the number of events in the backend
+func (l *LazyStorage) Count(ctx context.Context) (int64, error) {
+	backend, err := l.getBackend()
+	if err != nil {
+		return 0, err
+	}
+	return backend.Count(ctx)
+}
+
+// Search searches events if the backend supports it
+func (l *LazyStorage) Search(ctx context.Context, query SearchQuery) ([]Event, error) {
+	backend, err := l.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	searcher, ok := backend.(Searcher)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return searcher.Search(ctx, query)
+}
+
+// trasher returns the backend if it supports the trash
+func (l *LazyStorage) trasher() (Trasher, error) {
+	backend, err := l.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	trasher, ok := backend.(Trasher)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return trasher, nil
+}
+
+// Trash moves events to the trash if the backend supports it
+func (l *LazyStorage) Trash(ctx context.Context, query TrashQuery) (int64, error) {
+	trasher, err := l.trasher()
+	if err != nil {
+		return 0, err
+	}
+	return trasher.Trash(ctx, query)
+}
+
+// Restore moves events back from the trash if the backend supports it
+func (l *LazyStorage) Restore(ctx context.Context, query TrashQuery) (int64, error) {
+	trasher, err := l.trasher()
+	if err != nil {
+		return 0, err
+	}
+	return trasher.Restore(ctx, query)
+}
+
+// ListTrash lists the trash if the backend supports it
+func (l *LazyStorage) ListTrash(ctx context.Context, limit int64) ([]Event, error) {
+	trasher, err := l.trasher()
+	if err != nil {
+		return nil, err
+	}
+	return trasher.ListTrash(ctx, limit)
+}
+
+// PurgeTrash purges the trash if the backend supports it
+func (l *LazyStorage) PurgeTrash(ctx context.Context, before time.Time) (int64, error) {
+	trasher, err := l.trasher()
+	if err != nil {
+		return 0, err
+	}
+	return trasher.PurgeTrash(ctx, before)
+}
+
+// Close closes the backend if connected
+func (l *LazyStorage) Close() error {
+	backend, err := l.getBackend()
+	if err != nil {
+		return nil
+	}
+	return backend.Close()
+}
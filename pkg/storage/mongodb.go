@@ -2,11 +2,17 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -32,6 +38,22 @@ func NewMongoDBStorage(uri string, database string, collection string) (*MongoDB
 
 	coll := client.Database(database).Collection(collection)
 
+	// Text index on body enables full-text search
+	_, err = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "body", Value: "text"}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create text index: %w", err)
+	}
+
+	// Delivery attempts are looked up by event key
+	_, err = coll.Database().Collection(collection+"_deliveries").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "key", Value: 1}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create deliveries index: %w", err)
+	}
+
 	return &MongoDBStorage{
 		client:     client,
 		collection: coll,
@@ -39,15 +61,22 @@ func NewMongoDBStorage(uri string, database string, collection string) (*MongoDB
 }
 
 // Store saves a webhook event to MongoDB
-func (m *MongoDBStorage) Store(ctx context.Context, key string, path string, body string) error {
-	event := Event{
-		Key:       key,
-		Path:      path,
-		Body:      body,
-		Timestamp: time.Now(),
+func (m *MongoDBStorage) Store(ctx context.Context, event *Event) error {
+	doc := *event
+	if doc.Timestamp.IsZero() {
+		doc.Timestamp = time.Now()
 	}
 
-	_, err := m.collection.InsertOne(ctx, event)
+	// Keep the parsed payload so JSON fields can be queried, unless the
+	// caller already parsed it
+	if doc.Payload == nil {
+		var payload interface{}
+		if err := json.Unmarshal([]byte(doc.Body), &payload); err == nil {
+			doc.Payload = payload
+		}
+	}
+
+	_, err := m.collection.InsertOne(ctx, doc)
 	if err != nil {
 		return fmt.Errorf("failed to insert event to MongoDB: %w", err)
 	}
@@ -55,15 +84,251 @@ func (m *MongoDBStorage) Store(ctx context.Context, key string, path string, bod
 	return nil
 }
 
+// bucket returns the GridFS bucket holding bodies of streamed events,
+// bound to the context deadline
+func (m *MongoDBStorage) bucket(ctx context.Context) (*gridfs.Bucket, error) {
+	bucket, err := gridfs.NewBucket(m.collection.Database(), options.GridFSBucket().SetName(m.collection.Name()+"_bodies"))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		bucket.SetWriteDeadline(deadline)
+		bucket.SetReadDeadline(deadline)
+	}
+	return bucket, nil
+}
+
+// StoreStream saves a webhook event to MongoDB with the body in GridFS,
+// which is not limited by the maximum document size
+func (m *MongoDBStorage) StoreStream(ctx context.Context, event *Event, body io.ReadSeeker) error {
+	bucket, err := m.bucket(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open GridFS bucket: %w", err)
+	}
+	fileID, err := bucket.UploadFromStream(event.Key, body)
+	if err != nil {
+		return fmt.Errorf("failed to upload body to GridFS: %w", err)
+	}
+
+	doc := *event
+	doc.Body = ""
+	doc.Streamed = true
+	if doc.Timestamp.IsZero() {
+		doc.Timestamp = time.Now()
+	}
+	if _, err := m.collection.InsertOne(ctx, doc); err != nil {
+		bucket.Delete(fileID)
+		return fmt.Errorf("failed to insert event to MongoDB: %w", err)
+	}
+	return nil
+}
+
+// Get returns an event stored in MongoDB
+func (m *MongoDBStorage) Get(ctx context.Context, key string) (*Event, error) {
+	var event Event
+	err := m.collection.FindOne(ctx, bson.D{{Key: "key", Value: key}, notTrashed}).Decode(&event)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event: %w", err)
+	}
+
+	if event.Streamed {
+		bucket, err := m.bucket(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open GridFS bucket: %w", err)
+		}
+		if event.Body, err = downloadBody(bucket, key); err != nil {
+			return nil, err
+		}
+	}
+	return &event, nil
+}
+
+// downloadBody reads the body of a streamed event from GridFS
+func downloadBody(bucket *gridfs.Bucket, key string) (string, error) {
+	var body strings.Builder
+	if _, err := bucket.DownloadToStreamByName(key, &body); err != nil {
+		return "", fmt.Errorf("failed to download body from GridFS: %w", err)
+	}
+	return body.String(), nil
+}
+
 // Count returns the number of events stored in MongoDB
 func (m *MongoDBStorage) Count(ctx context.Context) (int64, error) {
-	count, err := m.collection.CountDocuments(ctx, bson.D{})
+	count, err := m.collection.CountDocuments(ctx, bson.D{notTrashed})
 	if err != nil {
 		return 0, fmt.Errorf("failed to count documents: %w", err)
 	}
 	return count, nil
 }
 
+// Search returns events matching the query, newest first
+func (m *MongoDBStorage) Search(ctx context.Context, query SearchQuery) ([]Event, error) {
+	filter := bson.D{notTrashed}
+	if query.Path != "" {
+		filter = append(filter, bson.E{Key: "path", Value: query.Path})
+	}
+	if query.Text != "" {
+		filter = append(filter, bson.E{Key: "$text", Value: bson.D{{Key: "$search", Value: query.Text}}})
+	}
+	for field, value := range query.Meta {
+		filter = append(filter, bson.E{Key: field, Value: bson.D{{Key: "$in", Value: fieldValues(value)}}})
+	}
+	for field, value := range query.Fields {
+		filter = append(filter, bson.E{Key: "payload." + field, Value: bson.D{{Key: "$in", Value: fieldValues(value)}}})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	if query.Limit > 0 {
+		opts.SetLimit(query.Limit)
+	}
+
+	cursor, err := m.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search events: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	events := []Event{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, fmt.Errorf("failed to decode events: %w", err)
+	}
+	return events, nil
+}
+
+// notTrashed filters out events in the trash
+var notTrashed = bson.E{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}}
+
+// trashFilter returns the filter of the query for events in or out of the
+// trash
+func trashFilter(query TrashQuery, trashed bool) (bson.D, error) {
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+	filter := bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: trashed}}}}
+	if query.Key != "" {
+		return append(filter, bson.E{Key: "key", Value: query.Key}), nil
+	}
+	return append(filter, bson.E{Key: "path", Value: query.Path}), nil
+}
+
+// Trash moves the events matching the query to the trash
+func (m *MongoDBStorage) Trash(ctx context.Context, query TrashQuery) (int64, error) {
+	filter, err := trashFilter(query, false)
+	if err != nil {
+		return 0, err
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "deleted_at", Value: time.Now()}}}}
+	result, err := m.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to trash events: %w", err)
+	}
+	return result.ModifiedCount, nil
+}
+
+// Restore moves the trashed events matching the query back
+func (m *MongoDBStorage) Restore(ctx context.Context, query TrashQuery) (int64, error) {
+	filter, err := trashFilter(query, true)
+	if err != nil {
+		return 0, err
+	}
+	update := bson.D{{Key: "$unset", Value: bson.D{{Key: "deleted_at", Value: ""}}}}
+	result, err := m.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to restore events: %w", err)
+	}
+	return result.ModifiedCount, nil
+}
+
+// ListTrash returns trashed events, most recently deleted first
+func (m *MongoDBStorage) ListTrash(ctx context.Context, limit int64) ([]Event, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "deleted_at", Value: -1}}).SetLimit(limit)
+	cursor, err := m.collection.Find(ctx, bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: true}}}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trash: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	events := []Event{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, fmt.Errorf("failed to decode events: %w", err)
+	}
+	return events, nil
+}
+
+// PurgeTrash permanently deletes events trashed before the time, including
+// the GridFS bodies of streamed events
+func (m *MongoDBStorage) PurgeTrash(ctx context.Context, before time.Time) (int64, error) {
+	filter := bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$lt", Value: before}}}}
+
+	streamed := append(bson.D{{Key: "streamed", Value: true}}, filter...)
+	cursor, err := m.collection.Find(ctx, streamed, options.Find().SetProjection(bson.D{{Key: "key", Value: 1}}))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find streamed events: %w", err)
+	}
+	var events []Event
+	if err := cursor.All(ctx, &events); err != nil {
+		return 0, fmt.Errorf("failed to decode events: %w", err)
+	}
+	if len(events) > 0 {
+		bucket, err := m.bucket(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open GridFS bucket: %w", err)
+		}
+		for _, event := range events {
+			if err := deleteGridFSFiles(ctx, bucket, event.Key); err != nil {
+				return 0, fmt.Errorf("failed to delete body of %s: %w", event.Key, err)
+			}
+		}
+	}
+
+	result, err := m.collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge trash: %w", err)
+	}
+	return result.DeletedCount, nil
+}
+
+// deleteGridFSFiles deletes the GridFS files with the name
+func deleteGridFSFiles(ctx context.Context, bucket *gridfs.Bucket, name string) error {
+	cursor, err := bucket.Find(bson.D{{Key: "filename", Value: name}})
+	if err != nil {
+		return err
+	}
+	var files []struct {
+		ID interface{} `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &files); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := bucket.Delete(file.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldValues returns the candidate typed values for a query value,
+// since "12345" may be stored either as a string or a number
+func fieldValues(value string) bson.A {
+	values := bson.A{value}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		values = append(values, f)
+	}
+	if b, err := strconv.ParseBool(value); err == nil {
+		values = append(values, b)
+	}
+	return values
+}
+
+// Ping checks the MongoDB connection
+func (m *MongoDBStorage) Ping(ctx context.Context) error {
+	return m.client.Ping(ctx, nil)
+}
+
 // Close closes the MongoDB connection
 func (m *MongoDBStorage) Close() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
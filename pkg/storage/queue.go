@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedisQueue mirrors the delivery backlog into Redis under stable names,
+// so autoscalers like the KEDA redis scaler can watch it:
+//
+//   - <name> is a list with an entry per delivery queued or in progress,
+//     its length (LLEN) is the backlog
+//   - <name>:instances is a hash of dispatcher instances and the unix time
+//     they were last seen, used to clean up entries of stopped instances
+//
+// List entries are <instance>/<delivery-id>.
+type RedisQueue struct {
+	storage  *RedisStorage
+	name     string
+	instance string
+}
+
+// Queue returns the backlog queue with the name for a dispatcher instance
+func (r *RedisStorage) Queue(name string, instance string) *RedisQueue {
+	return &RedisQueue{storage: r, name: name, instance: instance}
+}
+
+// Name returns the name of the list holding the backlog
+func (q *RedisQueue) Name() string {
+	return q.name
+}
+
+func (q *RedisQueue) entry(id string) string {
+	return q.instance + "/" + id
+}
+
+// Push adds a delivery to the backlog
+func (q *RedisQueue) Push(ctx context.Context, id string) error {
+	return q.storage.client.RPush(ctx, q.name, q.entry(id)).Err()
+}
+
+// Remove removes a finished delivery from the backlog
+func (q *RedisQueue) Remove(ctx context.Context, id string) error {
+	return q.storage.client.LRem(ctx, q.name, 1, q.entry(id)).Err()
+}
+
+// Len returns the backlog length
+func (q *RedisQueue) Len(ctx context.Context) (int64, error) {
+	return q.storage.client.LLen(ctx, q.name).Result()
+}
+
+// Reconcile records the instance as alive and removes entries which are
+// no longer pending: entries of the instance for which pending returns
+// false and entries of instances not seen within staleAfter
+func (q *RedisQueue) Reconcile(ctx context.Context, pending func(id string) bool, staleAfter time.Duration) error {
+	client := q.storage.client
+	instancesKey := q.name + ":instances"
+	now := time.Now()
+	if err := client.HSet(ctx, instancesKey, q.instance, now.Unix()).Err(); err != nil {
+		return fmt.Errorf("failed to record instance: %w", err)
+	}
+	seen, err := client.HGetAll(ctx, instancesKey).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get instances: %w", err)
+	}
+	entries, err := client.LRange(ctx, q.name, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get backlog: %w", err)
+	}
+
+	alive := func(instance string) bool {
+		unix, err := strconv.ParseInt(seen[instance], 10, 64)
+		return err == nil && now.Sub(time.Unix(unix, 0)) < staleAfter
+	}
+	for _, entry := range entries {
+		instance, id, _ := strings.Cut(entry, "/")
+		if instance == q.instance && pending(id) || instance != q.instance && alive(instance) {
+			continue
+		}
+		if err := client.LRem(ctx, q.name, 1, entry).Err(); err != nil {
+			return fmt.Errorf("failed to remove stale entry: %w", err)
+		}
+	}
+	for instance := range seen {
+		if !alive(instance) {
+			client.HDel(ctx, instancesKey, instance)
+		}
+	}
+	return nil
+}
@@ -2,7 +2,12 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -26,20 +31,107 @@ func NewRedisStorage(host string) (*RedisStorage, error) {
 	return &RedisStorage{client: client}, nil
 }
 
+// redisEventPattern matches the Redis keys of events
+const redisEventPattern = "webhook[-:]*"
+
+// redisEventKey returns the Redis key of an event. Keys in the default
+// webhook-<slug>-<timestamp> format are used as they are, keys of other
+// formats are prefixed with webhook: to tell events from other data.
+func redisEventKey(key string) string {
+	if strings.HasPrefix(key, "webhook-") {
+		return key
+	}
+	return "webhook:" + key
+}
+
+// eventKeyFromRedis returns the event key of a Redis key of an event
+func eventKeyFromRedis(key string) string {
+	return strings.TrimPrefix(key, "webhook:")
+}
+
 // Store saves a webhook event to Redis
-func (r *RedisStorage) Store(ctx context.Context, key string, path string, body string) error {
-	return r.client.Set(ctx, key, body, 0).Err()
+func (r *RedisStorage) Store(ctx context.Context, event *Event) error {
+	return r.client.Set(ctx, redisEventKey(event.Key), event.Body, 0).Err()
+}
+
+// streamChunkSize is the size of body chunks appended by StoreStream
+const streamChunkSize = 1 << 20
+
+// StoreStream saves a webhook event to Redis, appending the body in chunks
+func (r *RedisStorage) StoreStream(ctx context.Context, event *Event, body io.ReadSeeker) error {
+	key := redisEventKey(event.Key)
+	buf := make([]byte, streamChunkSize)
+	first := true
+	for {
+		n, err := io.ReadFull(body, buf)
+		if n > 0 || first {
+			var cmdErr error
+			if first {
+				cmdErr = r.client.Set(ctx, key, buf[:n], 0).Err()
+			} else {
+				cmdErr = r.client.Append(ctx, key, string(buf[:n])).Err()
+			}
+			if cmdErr != nil {
+				r.client.Del(context.Background(), key)
+				return cmdErr
+			}
+			first = false
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			r.client.Del(context.Background(), key)
+			return fmt.Errorf("failed to read body: %w", err)
+		}
+	}
+}
+
+// Get returns a webhook event stored in Redis
+func (r *RedisStorage) Get(ctx context.Context, key string) (*Event, error) {
+	body, err := r.client.Get(ctx, redisEventKey(key)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key: %w", err)
+	}
+
+	event := &Event{Key: key, Body: body}
+	// Keys in the default format end with the unix timestamp of the event
+	if i := strings.LastIndex(key, "-"); i >= 0 {
+		if unix, err := strconv.ParseInt(key[i+1:], 10, 64); err == nil {
+			event.Timestamp = time.Unix(unix, 0)
+		}
+	}
+	return event, nil
 }
 
 // Count returns the number of webhook events stored in Redis
 func (r *RedisStorage) Count(ctx context.Context) (int64, error) {
-	keys, err := r.client.Keys(ctx, "webhook-*").Result()
+	keys, err := r.client.Keys(ctx, redisEventPattern).Result()
 	if err != nil {
 		return 0, fmt.Errorf("failed to count keys: %w", err)
 	}
 	return int64(len(keys)), nil
 }
 
+// Claim sets the key for the TTL unless it exists, reporting whether it
+// was set by this call
+func (r *RedisStorage) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, key, 1, ttl).Result()
+}
+
+// Release deletes a key set by Claim
+func (r *RedisStorage) Release(ctx context.Context, key string) error {
+	return r.client.Del(ctx, key).Err()
+}
+
+// Ping checks the Redis connection
+func (r *RedisStorage) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
 // Close closes the Redis connection
 func (r *RedisStorage) Close() error {
 	return r.client.Close()
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RuleStore is implemented by backends persisting dispatch rules managed
+// through the admin API. Rules are opaque YAML documents keyed by name.
+type RuleStore interface {
+	// Rules returns all stored rules by name
+	Rules(ctx context.Context) (map[string]string, error)
+	// PutRule creates or replaces a rule
+	PutRule(ctx context.Context, name string, rule string) error
+	// DeleteRule deletes a rule, returning ErrNotFound if it does not exist
+	DeleteRule(ctx context.Context, name string) error
+}
+
+// redisRulesKey is the Redis hash holding the rules
+const redisRulesKey = "rules"
+
+// Rules returns the rules stored in Redis
+func (r *RedisStorage) Rules(ctx context.Context) (map[string]string, error) {
+	rules, err := r.client.HGetAll(ctx, redisRulesKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rules: %w", err)
+	}
+	return rules, nil
+}
+
+// PutRule stores a rule in Redis
+func (r *RedisStorage) PutRule(ctx context.Context, name string, rule string) error {
+	return r.client.HSet(ctx, redisRulesKey, name, rule).Err()
+}
+
+// DeleteRule deletes a rule from Redis
+func (r *RedisStorage) DeleteRule(ctx context.Context, name string) error {
+	n, err := r.client.HDel(ctx, redisRulesKey, name).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// storedRule is a rule document in MongoDB
+type storedRule struct {
+	Name      string    `bson:"name"`
+	Rule      string    `bson:"rule"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// rules returns the collection of rules, next to the events collection
+func (m *MongoDBStorage) rules() *mongo.Collection {
+	return m.collection.Database().Collection(m.collection.Name() + "_rules")
+}
+
+// Rules returns the rules stored in MongoDB
+func (m *MongoDBStorage) Rules(ctx context.Context) (map[string]string, error) {
+	cursor, err := m.rules().Find(ctx, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rules: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var docs []storedRule
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to decode rules: %w", err)
+	}
+	rules := make(map[string]string, len(docs))
+	for _, doc := range docs {
+		rules[doc.Name] = doc.Rule
+	}
+	return rules, nil
+}
+
+// PutRule stores a rule in MongoDB
+func (m *MongoDBStorage) PutRule(ctx context.Context, name string, rule string) error {
+	doc := storedRule{Name: name, Rule: rule, UpdatedAt: time.Now()}
+	_, err := m.rules().ReplaceOne(ctx, bson.D{{Key: "name", Value: name}}, doc, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to store rule: %w", err)
+	}
+	return nil
+}
+
+// DeleteRule deletes a rule from MongoDB
+func (m *MongoDBStorage) DeleteRule(ctx context.Context, name string) error {
+	res, err := m.rules().DeleteOne(ctx, bson.D{{Key: "name", Value: name}})
+	if err != nil {
+		return fmt.Errorf("failed to delete rule: %w", err)
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Rules returns the rules stored in MongoDB
+func (d *DualStorage) Rules(ctx context.Context) (map[string]string, error) {
+	return d.mongodb.Rules(ctx)
+}
+
+// PutRule stores a rule in both MongoDB and Redis
+func (d *DualStorage) PutRule(ctx context.Context, name string, rule string) error {
+	if err := d.mongodb.PutRule(ctx, name, rule); err != nil {
+		return err
+	}
+	return d.redis.PutRule(ctx, name, rule)
+}
+
+// DeleteRule deletes a rule from both MongoDB and Redis
+func (d *DualStorage) DeleteRule(ctx context.Context, name string) error {
+	err := d.mongodb.DeleteRule(ctx, name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if redisErr := d.redis.DeleteRule(ctx, name); redisErr != nil && !errors.Is(redisErr, ErrNotFound) {
+		return redisErr
+	}
+	return err
+}
+
+// ruleStore returns the backend if it can store rules
+func (l *LazyStorage) ruleStore() (RuleStore, error) {
+	backend, err := l.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	rules, ok := backend.(RuleStore)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return rules, nil
+}
+
+// Rules returns the stored rules if the backend supports it
+func (l *LazyStorage) Rules(ctx context.Context) (map[string]string, error) {
+	rules, err := l.ruleStore()
+	if err != nil {
+		return nil, err
+	}
+	return rules.Rules(ctx)
+}
+
+// PutRule stores a rule if the backend supports it
+func (l *LazyStorage) PutRule(ctx context.Context, name string, rule string) error {
+	rules, err := l.ruleStore()
+	if err != nil {
+		return err
+	}
+	return rules.PutRule(ctx, name, rule)
+}
+
+// DeleteRule deletes a rule if the backend supports it
+func (l *LazyStorage) DeleteRule(ctx context.Context, name string) error {
+	rules, err := l.ruleStore()
+	if err != nil {
+		return err
+	}
+	return rules.DeleteRule(ctx, name)
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// spoolFile is the journal file name inside the spool directory
+const spoolFile = "spool.jsonl"
+
+// Spool is a local disk journal of events whose storage write failed,
+// drained back into storage once it recovers
+type Spool struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewSpool creates a spool keeping its journal in dir
+func NewSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	return &Spool{path: filepath.Join(dir, spoolFile)}, nil
+}
+
+// Append appends an event to the journal
+func (s *Spool) Append(event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Drain writes spooled events to the backend, keeping those that still fail
+func (s *Spool) Drain(ctx context.Context, backend Storage) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var remaining [][]byte
+	drained := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := append([]byte{}, scanner.Bytes()...)
+		if len(remaining) > 0 {
+			// Keep order, once a write fails keep the rest
+			remaining = append(remaining, line)
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			log.Printf("Skipping corrupted spool entry: %v", err)
+			continue
+		}
+		if err := backend.Store(ctx, &event); err != nil {
+			remaining = append(remaining, line)
+			continue
+		}
+		drained++
+	}
+	f.Close()
+	if err := scanner.Err(); err != nil {
+		return drained, err
+	}
+
+	if len(remaining) == 0 {
+		return drained, os.Remove(s.path)
+	}
+
+	// Replace the journal with the events not drained yet
+	tmp := s.path + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return drained, err
+	}
+	w := bufio.NewWriter(out)
+	for _, line := range remaining {
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return drained, err
+	}
+	if err := out.Close(); err != nil {
+		return drained, err
+	}
+	return drained, os.Rename(tmp, s.path)
+}
+
+// RunDrain drains the journal periodically until ctx is done
+func (s *Spool) RunDrain(ctx context.Context, backend Storage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		drainCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		n, err := s.Drain(drainCtx, backend)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to drain spool: %v", err)
+		}
+		if n > 0 {
+			log.Printf("Drained %d spooled events to storage", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
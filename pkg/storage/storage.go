@@ -2,21 +2,61 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"io"
 	"time"
 )
 
+// ErrNotFound is returned when an event does not exist
+var ErrNotFound = errors.New("event not found")
+
 // Event represents a webhook event stored in the database
 type Event struct {
-	Key       string    `bson:"key" json:"key"`
-	Path      string    `bson:"path" json:"path"`
-	Body      string    `bson:"body" json:"body"`
-	Timestamp time.Time `bson:"timestamp" json:"timestamp"`
+	Key  string `bson:"key" json:"key"`
+	Path string `bson:"path" json:"path"`
+	// RawPath is the path as sent, before percent-decoding
+	RawPath   string      `bson:"raw_path,omitempty" json:"raw_path,omitempty"`
+	Body      string      `bson:"body" json:"body"`
+	Payload   interface{} `bson:"payload,omitempty" json:"-"`
+	Timestamp time.Time   `bson:"timestamp" json:"timestamp"`
+	// W3C trace context of the incoming request
+	TraceParent string `bson:"traceparent,omitempty" json:"traceparent,omitempty"`
+	TraceState  string `bson:"tracestate,omitempty" json:"tracestate,omitempty"`
+	// Sender address and its geo and network information
+	RemoteIP string   `bson:"remote_ip,omitempty" json:"remote_ip,omitempty"`
+	Geo      *GeoInfo `bson:"geo,omitempty" json:"geo,omitempty"`
+	// Streamed events were stored with StoreStream, their body is kept
+	// outside of the event record
+	Streamed bool  `bson:"streamed,omitempty" json:"streamed,omitempty"`
+	Size     int64 `bson:"size,omitempty" json:"size,omitempty"`
+	// Flags mark events needing attention, e.g. source_mismatch
+	Flags []string `bson:"flags,omitempty" json:"flags,omitempty"`
+	// Rule is the name of the rule which handled the event and Labels its
+	// labels
+	Rule   string            `bson:"rule,omitempty" json:"rule,omitempty"`
+	Labels map[string]string `bson:"labels,omitempty" json:"labels,omitempty"`
+	// DeletedAt is set while the event is in the trash
+	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	// Schema and SchemaVersion the payload matched
+	Schema        string `bson:"schema,omitempty" json:"schema,omitempty"`
+	SchemaVersion int    `bson:"schema_version,omitempty" json:"schema_version,omitempty"`
+}
+
+// GeoInfo is the location and network of a webhook sender
+type GeoInfo struct {
+	Country string `bson:"country,omitempty" json:"country,omitempty"`
+	City    string `bson:"city,omitempty" json:"city,omitempty"`
+	ASN     uint   `bson:"asn,omitempty" json:"asn,omitempty"`
+	ASOrg   string `bson:"as_org,omitempty" json:"as_org,omitempty"`
 }
 
 // Storage is the interface for storing webhook events
 type Storage interface {
 	// Store saves a webhook event
-	Store(ctx context.Context, key string, path string, body string) error
+	Store(ctx context.Context, event *Event) error
+
+	// Get returns a single event by its key
+	Get(ctx context.Context, key string) (*Event, error)
 
 	// Count returns the number of stored events
 	Count(ctx context.Context) (int64, error)
@@ -24,3 +64,31 @@ type Storage interface {
 	// Close closes the storage connection
 	Close() error
 }
+
+// StreamStorer is implemented by storage backends that can store bodies
+// too large to be held in memory
+type StreamStorer interface {
+	// StoreStream saves the event with the body read from body instead of
+	// event.Body
+	StoreStream(ctx context.Context, event *Event, body io.ReadSeeker) error
+}
+
+// SearchQuery describes a search over stored events
+type SearchQuery struct {
+	// Text is matched against the full event body
+	Text string
+	// Fields maps JSON field paths (e.g. "order.id") to expected values
+	Fields map[string]string
+	// Meta maps event metadata fields (e.g. "geo.country") to expected values
+	Meta map[string]string
+	// Path restricts results to a single webhook path
+	Path string
+	// Limit is the maximum number of returned events
+	Limit int64
+}
+
+// Searcher is implemented by storage backends that can search stored events
+type Searcher interface {
+	// Search returns events matching the query, newest first
+	Search(ctx context.Context, query SearchQuery) ([]Event, error)
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DeliveryJob is a delivery to a single target waiting in the delivery
+// stream
+type DeliveryJob struct {
+	// ID is the stream entry ID, set when the job is read
+	ID   string    `json:"-"`
+	Time time.Time `json:"time"`
+	// Key and Path of the event
+	Key  string `json:"key"`
+	Path string `json:"path"`
+	// Rule is the label of the rule the target belongs to
+	Rule string `json:"rule"`
+	// Target is the URL to deliver to and TargetLabel the configured
+	// target, which differs for URL templates
+	Target      string              `json:"target"`
+	TargetLabel string              `json:"target_label"`
+	Body        string              `json:"body"`
+	Headers     map[string][]string `json:"headers,omitempty"`
+}
+
+// RedisStream is a durable delivery queue on a Redis Stream read by a
+// consumer group. Entries stay pending until acknowledged, so the jobs of
+// a consumer which stopped are read again when it restarts under the same
+// name or claimed by another consumer once idle.
+type RedisStream struct {
+	storage  *RedisStorage
+	name     string
+	group    string
+	consumer string
+}
+
+// Stream returns the delivery stream with the name, read by the consumer
+// of the group
+func (r *RedisStorage) Stream(name string, group string, consumer string) *RedisStream {
+	return &RedisStream{storage: r, name: name, group: group, consumer: consumer}
+}
+
+// Setup creates the stream and the consumer group if they do not exist
+func (s *RedisStream) Setup(ctx context.Context) error {
+	err := s.storage.client.XGroupCreateMkStream(ctx, s.name, s.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group %s: %w", s.group, err)
+	}
+	return nil
+}
+
+// Add appends a job to the stream
+func (s *RedisStream) Add(ctx context.Context, job DeliveryJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return s.storage.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.name,
+		Values: []string{"job", string(data)},
+	}).Err()
+}
+
+// Read returns up to count new jobs, waiting up to block for them
+func (s *RedisStream) Read(ctx context.Context, count int64, block time.Duration) ([]DeliveryJob, error) {
+	return s.read(ctx, ">", count, block)
+}
+
+// Pending returns up to count jobs after the ID read earlier by the
+// consumer and not acknowledged, starting with ID 0
+func (s *RedisStream) Pending(ctx context.Context, after string, count int64) ([]DeliveryJob, error) {
+	return s.read(ctx, after, count, -1)
+}
+
+func (s *RedisStream) read(ctx context.Context, id string, count int64, block time.Duration) ([]DeliveryJob, error) {
+	streams, err := s.storage.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    s.group,
+		Consumer: s.consumer,
+		Streams:  []string{s.name, id},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jobs: %w", err)
+	}
+	var jobs []DeliveryJob
+	for _, stream := range streams {
+		jobs = append(jobs, s.decode(ctx, stream.Messages)...)
+	}
+	return jobs, nil
+}
+
+// Claim takes over up to count jobs idle for at least minIdle, read by
+// consumers which stopped without acknowledging them
+func (s *RedisStream) Claim(ctx context.Context, minIdle time.Duration, count int64) ([]DeliveryJob, error) {
+	messages, _, err := s.storage.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   s.name,
+		Group:    s.group,
+		Consumer: s.consumer,
+		MinIdle:  minIdle,
+		Start:    "0-0",
+		Count:    count,
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim delivery jobs: %w", err)
+	}
+	return s.decode(ctx, messages), nil
+}
+
+// decode parses the jobs of stream messages, acknowledging malformed ones
+// so they are not read again
+func (s *RedisStream) decode(ctx context.Context, messages []redis.XMessage) []DeliveryJob {
+	jobs := make([]DeliveryJob, 0, len(messages))
+	for _, message := range messages {
+		var job DeliveryJob
+		data, _ := message.Values["job"].(string)
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			log.Printf("Dropping malformed job %s of delivery stream %s: %v", message.ID, s.name, err)
+			s.Ack(ctx, message.ID)
+			continue
+		}
+		job.ID = message.ID
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// Ack acknowledges a finished job and removes it from the stream
+func (s *RedisStream) Ack(ctx context.Context, id string) error {
+	pipe := s.storage.client.Pipeline()
+	pipe.XAck(ctx, s.name, s.group, id)
+	pipe.XDel(ctx, s.name, id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Len returns the number of jobs queued or in progress
+func (s *RedisStream) Len(ctx context.Context) (int64, error) {
+	return s.storage.client.XLen(ctx, s.name).Result()
+}
+
+// Requeue appends a copy of a job to the end of the stream and removes
+// the original, so it is read again right away
+func (s *RedisStream) Requeue(ctx context.Context, id string) error {
+	messages, err := s.storage.client.XRangeN(ctx, s.name, id, id, 1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read job %s: %w", id, err)
+	}
+	jobs := s.decode(ctx, messages)
+	if len(jobs) == 0 {
+		return ErrNotFound
+	}
+	if err := s.Add(ctx, jobs[0]); err != nil {
+		return err
+	}
+	return s.Ack(ctx, id)
+}
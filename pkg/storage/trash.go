@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Trasher is implemented by backends deleting events in two phases:
+// deleted events are moved to the trash, from which they can be restored
+// until they are purged
+type Trasher interface {
+	// Trash moves the events matching the query to the trash
+	Trash(ctx context.Context, query TrashQuery) (int64, error)
+	// Restore moves the trashed events matching the query back
+	Restore(ctx context.Context, query TrashQuery) (int64, error)
+	// ListTrash returns trashed events, most recently deleted first
+	ListTrash(ctx context.Context, limit int64) ([]Event, error)
+	// PurgeTrash permanently deletes events trashed before the time
+	PurgeTrash(ctx context.Context, before time.Time) (int64, error)
+}
+
+// TrashQuery selects events by key or by path
+type TrashQuery struct {
+	Key  string
+	Path string
+}
+
+// validate checks that exactly one selector is set
+func (q TrashQuery) validate() error {
+	if (q.Key == "") == (q.Path == "") {
+		return fmt.Errorf("exactly one of key and path is required")
+	}
+	return nil
+}
+
+// Trashed events are renamed to trash:<key> in Redis and indexed by the
+// time of deletion in the trash:index sorted set
+const (
+	redisTrashPrefix = "trash:"
+	redisTrashIndex  = "trash:index"
+)
+
+// Trash moves an event to the trash, Redis can only select events by key
+func (r *RedisStorage) Trash(ctx context.Context, query TrashQuery) (int64, error) {
+	if err := query.validate(); err != nil {
+		return 0, err
+	}
+	if query.Key == "" {
+		return 0, ErrNotSupported
+	}
+	exists, err := r.client.Exists(ctx, redisEventKey(query.Key)).Result()
+	if err != nil || exists == 0 {
+		return 0, err
+	}
+	if err := r.client.Rename(ctx, redisEventKey(query.Key), redisTrashPrefix+query.Key).Err(); err != nil {
+		return 0, fmt.Errorf("failed to trash event: %w", err)
+	}
+	member := redis.Z{Score: float64(time.Now().Unix()), Member: query.Key}
+	if err := r.client.ZAdd(ctx, redisTrashIndex, member).Err(); err != nil {
+		return 1, fmt.Errorf("failed to index trashed event: %w", err)
+	}
+	return 1, nil
+}
+
+// Restore moves an event back from the trash
+func (r *RedisStorage) Restore(ctx context.Context, query TrashQuery) (int64, error) {
+	if err := query.validate(); err != nil {
+		return 0, err
+	}
+	if query.Key == "" {
+		return 0, ErrNotSupported
+	}
+	exists, err := r.client.Exists(ctx, redisTrashPrefix+query.Key).Result()
+	if err != nil || exists == 0 {
+		return 0, err
+	}
+	restored, err := r.client.RenameNX(ctx, redisTrashPrefix+query.Key, redisEventKey(query.Key)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to restore event: %w", err)
+	}
+	if !restored {
+		return 0, fmt.Errorf("event %s exists", query.Key)
+	}
+	r.client.ZRem(ctx, redisTrashIndex, query.Key)
+	return 1, nil
+}
+
+// ListTrash returns the keys of trashed events and when they were deleted
+func (r *RedisStorage) ListTrash(ctx context.Context, limit int64) ([]Event, error) {
+	members, err := r.client.ZRevRangeWithScores(ctx, redisTrashIndex, 0, limit-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trash: %w", err)
+	}
+	events := make([]Event, 0, len(members))
+	for _, m := range members {
+		deletedAt := time.Unix(int64(m.Score), 0)
+		events = append(events, Event{Key: m.Member.(string), DeletedAt: &deletedAt})
+	}
+	return events, nil
+}
+
+// PurgeTrash permanently deletes events trashed before the time
+func (r *RedisStorage) PurgeTrash(ctx context.Context, before time.Time) (int64, error) {
+	keys, err := r.client.ZRangeByScore(ctx, redisTrashIndex, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(before.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list trash: %w", err)
+	}
+	var purged int64
+	for _, key := range keys {
+		if err := r.client.Del(ctx, redisTrashPrefix+key).Err(); err != nil {
+			return purged, fmt.Errorf("failed to purge event %s: %w", key, err)
+		}
+		r.client.ZRem(ctx, redisTrashIndex, key)
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,163 @@
+package wasm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Plugins are WebAssembly modules exporting:
+//
+//	alloc(size i32) i32                  allocates memory for the input payload
+//	transform(ptr i32, len i32) i64      optional, returns (outPtr << 32) | outLen
+//	filter(ptr i32, len i32) i32         optional, returns 0 to drop the event
+//
+// Each call runs in a fresh module instance, so plugins cannot keep state
+// between events.
+
+// memoryLimitPages caps the memory of a plugin instance at 64 MiB, in 64
+// KiB pages
+const memoryLimitPages = 1024
+
+var (
+	runtime     wazero.Runtime
+	runtimeOnce sync.Once
+)
+
+func getRuntime() wazero.Runtime {
+	runtimeOnce.Do(func() {
+		ctx := context.Background()
+		// Closing modules when the context is done stops plugins running
+		// past the processing timeout, e.g. in an endless loop
+		config := wazero.NewRuntimeConfig().
+			WithCloseOnContextDone(true).
+			WithMemoryLimitPages(memoryLimitPages)
+		runtime = wazero.NewRuntimeWithConfig(ctx, config)
+		wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+	})
+	return runtime
+}
+
+// Plugin is a compiled WebAssembly module
+type Plugin struct {
+	path     string
+	compiled wazero.CompiledModule
+}
+
+var (
+	// loaded are the plugins loaded so far by path and content hash, so
+	// configs read again when rules change share the compiled modules
+	loaded   = map[string]*Plugin{}
+	loadedMu sync.Mutex
+)
+
+// Load reads and compiles a WebAssembly module. A module loaded before
+// from the same path with the same content is reused.
+func Load(path string) (*Plugin, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	key := path + ":" + hex.EncodeToString(sum[:])
+
+	loadedMu.Lock()
+	defer loadedMu.Unlock()
+	if plugin, ok := loaded[key]; ok {
+		return plugin, nil
+	}
+	plugin, err := compile(path, data)
+	if err != nil {
+		return nil, err
+	}
+	loaded[key] = plugin
+	return plugin, nil
+}
+
+// compile compiles a WebAssembly module and checks its exports
+func compile(path string, data []byte) (*Plugin, error) {
+	compiled, err := getRuntime().CompileModule(context.Background(), data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile %s: %w", path, err)
+	}
+
+	exports := compiled.ExportedFunctions()
+	if _, ok := exports["alloc"]; !ok {
+		compiled.Close(context.Background())
+		return nil, fmt.Errorf("%s does not export alloc", path)
+	}
+	_, hasTransform := exports["transform"]
+	_, hasFilter := exports["filter"]
+	if !hasTransform && !hasFilter {
+		compiled.Close(context.Background())
+		return nil, fmt.Errorf("%s exports neither transform nor filter", path)
+	}
+
+	return &Plugin{path: path, compiled: compiled}, nil
+}
+
+// Path returns the file the plugin was loaded from
+func (p *Plugin) Path() string {
+	return p.path
+}
+
+// Run passes the payload through the plugin, returning the (possibly
+// transformed) payload and whether the event should be kept
+func (p *Plugin) Run(ctx context.Context, payload []byte) ([]byte, bool, error) {
+	mod, err := getRuntime().InstantiateModule(ctx, p.compiled, wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to instantiate: %w", err)
+	}
+	defer mod.Close(ctx)
+
+	ptr, err := writeInput(ctx, mod, payload)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if filter := mod.ExportedFunction("filter"); filter != nil {
+		res, err := filter.Call(ctx, uint64(ptr), uint64(len(payload)))
+		if err != nil {
+			return nil, false, fmt.Errorf("filter failed: %w", err)
+		}
+		if uint32(res[0]) == 0 {
+			return payload, false, nil
+		}
+	}
+
+	transform := mod.ExportedFunction("transform")
+	if transform == nil {
+		return payload, true, nil
+	}
+
+	res, err := transform.Call(ctx, uint64(ptr), uint64(len(payload)))
+	if err != nil {
+		return nil, false, fmt.Errorf("transform failed: %w", err)
+	}
+	outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+	out, ok := mod.Memory().Read(outPtr, outLen)
+	if !ok {
+		return nil, false, fmt.Errorf("transform returned out of range memory")
+	}
+	// Copy, the memory is released with the module
+	return append([]byte{}, out...), true, nil
+}
+
+func writeInput(ctx context.Context, mod api.Module, payload []byte) (uint32, error) {
+	res, err := mod.ExportedFunction("alloc").Call(ctx, uint64(len(payload)))
+	if err != nil {
+		return 0, fmt.Errorf("alloc failed: %w", err)
+	}
+	ptr := uint32(res[0])
+	if !mod.Memory().Write(ptr, payload) {
+		return 0, fmt.Errorf("alloc returned out of range memory")
+	}
+	return ptr, nil
+}